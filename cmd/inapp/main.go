@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -31,20 +34,38 @@ func main() {
 	}
 	config.InitializeRedis(redisConfig)
 
+	// MySQL serves the requests clients send over the WebSocket, the API server owns the migrations
+	config.ConnectMySQL(config.MySQLConnectionConfig(envConfig))
+
 	ctx := context.Background()
 	rdb := db.GetRedisClient()
 
-	// Initialize WebSocket hub
+	// Initialize WebSocket hub, it subscribes to the broadcast channels of the users connected to this node
 	inapp.InitializeHub(ctx, rdb)
 
 	// Create consumer group if not exists
 	_ = rdb.XGroupCreateMkStream(ctx, envConfig.InAppServiceConfig.StreamName, envConfig.InAppServiceConfig.GroupName, "$").Err()
 
-	// Start consumer loop (reads from stream, publishes to pub/sub)
-	go inapp.StartConsumer(ctx, rdb, envConfig.InAppServiceConfig.StreamName, envConfig.InAppServiceConfig.GroupName, envConfig.InAppServiceConfig.ConsumerName)
+	// Every node is a consumer of the group under its own name
+	consumerName := envConfig.InAppServiceConfig.ConsumerName
+	if consumerName == "" {
+		consumerName = inapp.DefaultConsumerName()
+	}
+	log.Printf("InApp consumer %s joining group %s", consumerName, envConfig.InAppServiceConfig.GroupName)
+
+	// The users connected to this node are published under the same name, for the presence API
+	inapp.DefaultHub.TrackPresence(consumerName)
 
-	// Start broadcast subscriber (listens to pub/sub, delivers to local WebSocket hub)
-	///	go startBroadcastSubscriber(ctx, rdb)
+	// Start consumer loop (reads from stream, publishes to pub/sub), stopped on shutdown
+	consumerCtx, stopConsumer := context.WithCancel(ctx)
+	consumerDone := make(chan struct{})
+	go func() {
+		defer close(consumerDone)
+		inapp.StartConsumer(consumerCtx, rdb, envConfig.InAppServiceConfig.StreamName, envConfig.InAppServiceConfig.GroupName, consumerName)
+	}()
+
+	// Tells the devices when a snoozed notification returns to the inbox
+	go inapp.WatchSnoozes(consumerCtx, rdb)
 
 	// Fiber HTTP + WebSocket server
 	app := fiber.New(fiber.Config{
@@ -68,51 +89,89 @@ func main() {
 		// Register with composite key: app_id:user_id
 		compositeKey := fmt.Sprintf("%s:%s", applicationID, userID)
 
-		client := inapp.DefaultHub.Register(compositeKey, conn)
+		// Resume after the last event the client saw, notifications sent while it was offline are replayed
+		since := conn.Query("since", conn.Headers("Last-Event-ID"))
+
+		client := inapp.DefaultHub.Register(compositeKey, conn, since)
 
-		// Read bumb is only used here to block the functions from exiting and closing the connection. there is no actual reading happening from the client in this implementation
+		// ReadPump answers the client's requests (list, unread_count, mark_read, ...) until the
+		// connection closes, the connection is released once this handler returns
 		client.ReadPump()
 	}))
 
+	// Server-sent events fallback for clients whose proxies break WebSockets, same JWT and payloads.
+	// EventSource sends Last-Event-ID when it reconnects, it takes precedence over the since of the URL.
+	app.Get("/sse", middleware.SSEJWTAuth, func(c *fiber.Ctx) error {
+		compositeKey := fmt.Sprintf("%s:%s", c.Locals("application_id").(string), c.Locals("user_id").(string))
+		since := c.Get("Last-Event-ID", c.Query("since"))
+		return inapp.DefaultHub.ServeSSE(c, compositeKey, since)
+	})
+
 	addr := ":" + envConfig.InAppServiceConfig.Port
 	if addr == ":" {
 		addr = ":4000"
 	}
 	log.Printf(" InApp WebSocket service listening on %s", addr)
-	log.Printf("   - GET /ws?token=<jwt> (WebSocket with JWT authentication)")
-	log.Fatal(app.Listen(addr))
+	log.Printf("   - GET /ws?token=<jwt>&since=<event_id> (WebSocket with JWT authentication)")
+	log.Printf("   - GET /sse?token=<jwt>&since=<event_id> (Server-sent events with JWT authentication)")
+	go func() {
+		if err := app.Listen(addr); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+	shutdown(app, stopConsumer, consumerDone, envConfig.InAppServiceConfig.ShutdownTimeout)
+}
+
+// shutdown stops accepting connections, closes the open ones with a reconnect close frame so
+// clients move to another node, lets the consumer finish its batch and unsubscribes. It exits
+// the process once timeout passed, whatever step it is stuck in.
+func shutdown(app *fiber.App, stopConsumer context.CancelFunc, consumerDone <-chan struct{}, timeout time.Duration) {
+	log.Printf("🛑 Shutting down, draining within %s", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		drain(ctx, app, stopConsumer, consumerDone)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			log.Printf("⚠️  Shutdown didn't finish within %s, exiting", timeout)
+			os.Exit(1)
+		}
+	}
+	log.Println("✅ InApp service stopped")
 }
 
-// // startBroadcastSubscriber listens to Redis Pub/Sub and delivers to local WebSocket hub
-// func startBroadcastSubscriber(ctx context.Context, rdb *redis.Client) {
-// 	// Pattern subscribe to: inapp:broadcast:*
-// 	pubsub := rdb.PSubscribe(ctx, inapp.BroadcastChannelPrefix+"*")
-// 	defer pubsub.Close()
-
-// 	log.Println("📡 Broadcast subscriber started")
-
-// 	ch := pubsub.Channel()
-// 	for msg := range ch {
-// 		// Expected channel format: inapp:broadcast:app_id:user_id
-// 		parts := strings.Split(msg.Channel, ":")
-// 		if len(parts) < 4 {
-// 			log.Printf("invalid broadcast channel format: %s (expected inapp:broadcast:app_id:user_id)", msg.Channel)
-// 			continue
-// 		}
-
-// 		applicationID := parts[2]
-// 		userID := parts[3]
-// 		compositeKey := fmt.Sprintf("%s:%s", applicationID, userID)
-
-// 		var payload map[string]interface{}
-// 		if err := json.Unmarshal([]byte(msg.Payload), &payload); err != nil {
-// 			log.Printf("invalid broadcast payload: %v", err)
-// 			continue
-// 		}
-
-// 		// Broadcast to the specific app:user combination
-// 		inapp.DefaultHub.BroadcastToUser(compositeKey, payload)
-// 	}
-
-// 	log.Println("⚠️  Broadcast subscriber stopped")
-// }
+func drain(ctx context.Context, app *fiber.App, stopConsumer context.CancelFunc, consumerDone <-chan struct{}) {
+	// closes the listener right away, then waits for the open connections
+	serverDone := make(chan error, 1)
+	go func() { serverDone <- app.ShutdownWithContext(ctx) }()
+
+	if err := inapp.DefaultHub.Drain(ctx); err != nil {
+		log.Printf("⚠️  Clients not drained in time: %v", err)
+	}
+
+	stopConsumer()
+	select {
+	case <-consumerDone:
+	case <-ctx.Done():
+		log.Printf("⚠️  Consumer didn't stop in time, its pending entries are claimed by the other nodes")
+	}
+
+	if err := <-serverDone; err != nil {
+		log.Printf("⚠️  Server shutdown: %v", err)
+	}
+	if err := inapp.DefaultHub.Close(); err != nil {
+		log.Printf("⚠️  Failed to close the broadcast subscription: %v", err)
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -28,19 +31,7 @@ func main() {
 
 	fmt.Println("Redis Config:", redisConfig)
 
-	MysqlConfig := envConfig.MySQLConfig
-
-	var mySQLConfig db.MySQLConfig
-	if envConfig.ENV_MODE != "local" {
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:3306)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			MysqlConfig.MYSQL_USER,
-			MysqlConfig.MYSQL_ROOT_PASSWORD,
-			MysqlConfig.DB_HOST,
-			MysqlConfig.MYSQL_DATABASE,
-		)
-		fmt.Println("MySQL DSN:", dsn)
-		mySQLConfig.DSN = dsn
-	}
+	mySQLConfig := config.MySQLConnectionConfig(envConfig)
 
 	// Initialize databases
 	config.InitializeRedis(redisConfig)
@@ -49,9 +40,19 @@ func main() {
 	// Initialize channels
 	config.InitializeEmailChannel(&envConfig.EmailEnvConfig)
 	config.InitializeResendProvider(&envConfig.ResendEnvConfig)
+	config.InitializeEmailTracking(&envConfig.ServerEnvConfig)
+	config.InitializeEmailUnsubscribe(&envConfig.ServerEnvConfig)
 	config.InitializeTwilioProvider(&envConfig.TwilioEnvConfig)
+	config.InitializeVonageProvider(&envConfig.VonageEnvConfig)
+	config.InitializeMessageBirdProvider(&envConfig.MessageBirdConfig)
+	config.InitializeSMSGatewayProvider(&envConfig.SMSGatewayConfig)
 	config.InitializeWebPushProvider(&envConfig.WebPushEnvConfig)
 	config.InitializeInAppProvider(&envConfig.InAppConfig)
+	config.InitializeSlackProvider(&envConfig.SlackEnvConfig)
+	config.InitializeDiscordProvider(&envConfig.DiscordEnvConfig)
+	config.InitializeTeamsProvider(&envConfig.TeamsEnvConfig)
+	config.InitializeTelegramProvider(&envConfig.TelegramEnvConfig)
+	config.InitializePushProviders(&envConfig.PushEnvConfig)
 	// Create Fiber app
 	app := fiber.New()
 
@@ -81,5 +82,23 @@ func main() {
 
 	// Start server
 	log.Println("🚀 Starting Agni server on port", envConfig.ServerEnvConfig.Port)
-	log.Fatal(app.Listen(":" + envConfig.ServerEnvConfig.Port))
+	go func() {
+		if err := app.Listen(":" + envConfig.ServerEnvConfig.Port); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	<-stop
+
+	// workers finish the notification they are sending before the SMTP sessions are closed
+	log.Println("🛑 Shutting down Agni server...")
+	if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+		log.Printf("⚠️  Server shutdown: %v", err)
+	}
+	delayedProcessor.Stop()
+	workerPool.Stop()
+	config.CloseEmailChannel()
+	log.Println("✅ Agni server stopped")
 }
@@ -47,10 +47,9 @@ type RawInAppNotification = Partial<InAppNotification> & {
 
 interface GetNotificationsResponse {
   notifications: RawInAppNotification[]
-  total: number
-  limit: number
-  offset: number
+  next_cursor: string
   has_more: boolean
+  total?: number
 }
 
 function normalizeNotification(raw: RawInAppNotification, index: number): InAppNotification {
@@ -156,7 +155,6 @@ export async function getInAppNotifications(userId: string): Promise<InAppNotifi
   const params = new URLSearchParams({
     user_id: userId,
     limit: '50',
-    offset: '0',
   })
 
   const response = await fetch(`/api/inapp/notifications?${params}`, {
@@ -207,13 +205,39 @@ export async function markAsRead(notificationId: string): Promise<void> {
   }
 }
 
-export function getWebSocketUrl(): string {
+// since is the event_id of the last notification received, the server replays what came after it
+export function getWebSocketUrl(since?: string): string {
   const envUrl = import.meta.env.VITE_INAPP_WS_URL as string | undefined
+  let url: string
   if (envUrl && envUrl.trim()) {
-    return envUrl.trim()
+    url = envUrl.trim()
+  } else {
+    const protocol = window.location.protocol === 'https:' ? 'wss' : 'ws'
+    const hostname = window.location.hostname || 'localhost'
+    url = `${protocol}://${hostname}/ws`
   }
 
-  const protocol = window.location.protocol === 'https:' ? 'wss' : 'ws'
-  const hostname = window.location.hostname || 'localhost'
-  return `${protocol}://${hostname}/ws`
+  if (!since) {
+    return url
+  }
+  const separator = url.includes('?') ? '&' : '?'
+  return `${url}${separator}since=${encodeURIComponent(since)}`
+}
+
+// Server-Sent Events fallback for networks that block WebSockets, same payloads as getWebSocketUrl
+export function getEventSourceUrl(since?: string): string {
+  const envUrl = import.meta.env.VITE_INAPP_SSE_URL as string | undefined
+  let url: string
+  if (envUrl && envUrl.trim()) {
+    url = envUrl.trim()
+  } else {
+    const hostname = window.location.hostname || 'localhost'
+    url = `${window.location.protocol}//${hostname}/sse`
+  }
+
+  if (!since) {
+    return url
+  }
+  const separator = url.includes('?') ? '&' : '?'
+  return `${url}${separator}since=${encodeURIComponent(since)}`
 }
@@ -7,6 +7,7 @@ import { Button } from '@/components/Button'
 import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/Card'
 import { NotificationIcon } from '@/components/NotificationIcon'
 import {
+  getEventSourceUrl,
   getInAppNotifications,
   getUnreadCount,
   getWebSocketUrl,
@@ -27,6 +28,17 @@ function formatRelativeTime(value: string): string {
   return formatDistanceToNow(parsed, { addSuffix: true })
 }
 
+type SyncEvent = {
+  type: 'unread_count' | 'read' | 'updated'
+  data?: {
+    unread_count?: number
+    notification_ids?: string[]
+    all?: boolean
+    notification_id?: string
+    action?: string
+  }
+}
+
 type IncomingNotification = Partial<InAppNotification> & {
   ID?: string
   ApplicationID?: string
@@ -38,6 +50,9 @@ type IncomingNotification = Partial<InAppNotification> & {
   Status?: string
   Read?: boolean
   CreatedAt?: string
+  event_id?: string
+  broadcast_id?: string
+  type?: string
 }
 
 function normalizeIncomingNotification(raw: IncomingNotification): InAppNotification {
@@ -91,13 +106,91 @@ export function StudentInbox({ userId, refreshTrigger = 0 }: StudentInboxProps)
     }
 
     let socket: WebSocket | null = null
+    let eventSource: EventSource | null = null
     let reconnectTimer: number | null = null
     let reconnectAttempts = 0
     let isActive = true
     let isConnecting = false
+    let lastEventId = ''
+
+    const handleMessage = (data: string) => {
+      try {
+        const message = JSON.parse(data) as SyncEvent | IncomingNotification
+
+        // read state and badge changes made on the user's other devices
+        if (message.type === 'unread_count') {
+          const { unread_count } = (message as SyncEvent).data ?? {}
+          if (typeof unread_count === 'number') {
+            setUnreadCount(unread_count)
+          }
+          return
+        }
+        if (message.type === 'read') {
+          const { all, notification_ids } = (message as SyncEvent).data ?? {}
+          setNotifications((prev) =>
+            prev.map((n) => (all || notification_ids?.includes(n.id) ? { ...n, read: true } : n)),
+          )
+          return
+        }
+        if (message.type === 'updated') {
+          // archived, snoozed or deleted on another device, it leaves the inbox
+          const { action, notification_id } = (message as SyncEvent).data ?? {}
+          if (action === 'archived' || action === 'snoozed' || action === 'deleted') {
+            setNotifications((prev) => prev.filter((n) => n.id !== notification_id))
+          }
+          return
+        }
+        if (message.type && message.type !== 'notification') {
+          return
+        }
+
+        const payload = message as IncomingNotification
+        if (payload.event_id) {
+          lastEventId = payload.event_id
+        }
+        const incoming = normalizeIncomingNotification(payload)
+
+        if (incoming.recipient && incoming.recipient !== userId) {
+          return
+        }
+
+        setNotifications((prev) => {
+          const exists = prev.some((n) => n.id === incoming.id)
+          if (exists) {
+            return prev
+          }
+          return [incoming, ...prev]
+        })
+
+        // the badge follows the unread_count event sent along with every new notification,
+        // broadcasts come without one
+        if (payload.broadcast_id) {
+          setUnreadCount((count) => count + 1)
+        }
+        toast.success(incoming.subject || 'New in-app notification', {
+          description: incoming.message || 'A notification has arrived.',
+        })
+      } catch {
+        // Ignore malformed payloads.
+      }
+    }
+
+    const connectEventSource = () => {
+      if (!isActive || eventSource) {
+        return
+      }
+      // EventSource reconnects by itself and resumes with the Last-Event-ID header
+      eventSource = new EventSource(getEventSourceUrl(lastEventId), { withCredentials: true })
+      eventSource.onmessage = (event) => handleMessage(event.data)
+    }
 
     const scheduleReconnect = () => {
-      if (!isActive || reconnectAttempts >= 5 || reconnectTimer !== null) {
+      if (!isActive || reconnectTimer !== null) {
+        return
+      }
+      if (reconnectAttempts >= 5) {
+        // WebSockets look blocked on this network, stream over Server-Sent Events instead
+        connectEventSource()
         return
       }
 
@@ -115,7 +208,7 @@ export function StudentInbox({ userId, refreshTrigger = 0 }: StudentInboxProps)
       }
 
       isConnecting = true
-      socket = new WebSocket(getWebSocketUrl())
+      socket = new WebSocket(getWebSocketUrl(lastEventId))
 
       socket.onopen = () => {
         if (!isActive) {
@@ -127,34 +220,7 @@ export function StudentInbox({ userId, refreshTrigger = 0 }: StudentInboxProps)
         reconnectAttempts = 0
       }
 
-      socket.onmessage = (event) => {
-        try {
-          const payload = JSON.parse(event.data) as IncomingNotification
-          const incoming = normalizeIncomingNotification(payload)
-
-          if (incoming.recipient && incoming.recipient !== userId) {
-            return
-          }
-
-          setNotifications((prev) => {
-            const exists = prev.some((n) => n.id === incoming.id)
-            if (exists) {
-              return prev
-            }
-            return [incoming, ...prev]
-          })
-
-          if (!incoming.read) {
-            setUnreadCount((prev) => prev + 1)
-          }
-
-          toast.success(incoming.subject || 'New in-app notification', {
-            description: incoming.message || 'A notification has arrived.',
-          })
-        } catch {
-          // Ignore malformed websocket payloads.
-        }
-      }
+      socket.onmessage = (event) => handleMessage(event.data)
 
       socket.onerror = () => {
         isConnecting = false
@@ -183,6 +249,7 @@ export function StudentInbox({ userId, refreshTrigger = 0 }: StudentInboxProps)
         socket.onerror = null
       }
       socket?.close()
+      eventSource?.close()
     }
   }, [userId])
 
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/config"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email"
+)
+
+// svixTolerance is how old a Resend (Svix) webhook delivery may be
+const svixTolerance = 5 * time.Minute
+
+type resendWebhookEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		EmailID string          `json:"email_id"`
+		To      []string        `json:"to"`
+		Tags    json.RawMessage `json:"tags"`
+		Bounce  struct {
+			Type    string `json:"type"`
+			SubType string `json:"subType"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+	} `json:"data"`
+}
+
+// HandleResendWebhook ingests Resend email.bounced and email.complained events
+// POST /api/email/webhooks/resend
+func HandleResendWebhook(c *fiber.Ctx) error {
+	secret := config.GetEnvConfig().ResendEnvConfig.WebhookSecret
+	if secret == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Resend webhook is not configured"})
+	}
+	if err := verifySvixSignature(secret, c.Get("svix-id"), c.Get("svix-timestamp"), c.Get("svix-signature"), c.Body()); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var event resendWebhookEvent
+	if err := json.Unmarshal(c.Body(), &event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var reason, detail string
+	switch event.Type {
+	case "email.bounced":
+		// transient bounces are retried by Resend, only permanent ones are suppressed
+		if !strings.EqualFold(event.Data.Bounce.Type, "Permanent") {
+			return c.JSON(fiber.Map{"message": "Ignored transient bounce"})
+		}
+		reason = "bounce"
+		detail = strings.TrimSpace(event.Data.Bounce.SubType + ": " + event.Data.Bounce.Message)
+	case "email.complained":
+		reason = "complaint"
+	default:
+		return c.JSON(fiber.Map{"message": "Ignored event " + event.Type})
+	}
+
+	applicationID, err := uuid.Parse(resendTag(event.Data.Tags, "application_id"))
+	if err != nil {
+		log.Printf("Resend %s event for email %s has no application_id tag", event.Type, event.Data.EmailID)
+		return c.JSON(fiber.Map{"message": "Ignored event without application"})
+	}
+
+	for _, address := range event.Data.To {
+		err := db.SuppressEmail(&db.EmailSuppression{
+			ApplicationID: applicationID,
+			Email:         email.NormalizeAddress(address),
+			Reason:        reason,
+			Source:        "resend",
+			Detail:        detail,
+		})
+		if err != nil {
+			log.Printf("Error suppressing %s: %v", address, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store suppression"})
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Event processed"})
+}
+
+// verifySvixSignature checks the "v1,<base64>" signatures Resend sends through Svix
+func verifySvixSignature(secret, id, timestamp, signatures string, body []byte) error {
+	if id == "" || timestamp == "" || signatures == "" {
+		return errors.New("missing webhook signature headers")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid webhook timestamp")
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > svixTolerance || age < -svixTolerance {
+		return errors.New("webhook timestamp is too old")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return errors.New("invalid webhook secret")
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, signature := range strings.Fields(signatures) {
+		version, value, ok := strings.Cut(signature, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return errors.New("invalid webhook signature")
+}
+
+// resendTag reads a tag that Resend may send either as an object or as a list of name/value pairs
+func resendTag(raw json.RawMessage, name string) string {
+	var asMap map[string]string
+	if err := json.Unmarshal(raw, &asMap); err == nil {
+		return asMap[name]
+	}
+	var asList []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &asList); err == nil {
+		for _, tag := range asList {
+			if tag.Name == name {
+				return tag.Value
+			}
+		}
+	}
+	return ""
+}
+
+type BounceEvent struct {
+	Email  string `json:"email"`
+	Type   string `json:"type"`             // bounce, complaint
+	Hard   *bool  `json:"hard,omitempty"`   // bounces are treated as hard unless set to false
+	Reason string `json:"reason,omitempty"` // free text, e.g. the SMTP diagnostic
+}
+
+type BounceRequest struct {
+	Events []BounceEvent `json:"events"`
+	// DSN is a raw RFC 3464 bounce message, parsed locally
+	DSN string `json:"dsn,omitempty"`
+}
+
+// IngestBounces stores bounces and complaints reported by any other email provider or MTA
+// POST /api/email/bounces
+// Body: { "application_token": "...", "application_secret": "...", "events": [{ "email": "...", "type": "bounce|complaint" }], "dsn": "..." }
+func IngestBounces(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid application context"})
+	}
+
+	var req BounceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	var suppressions []db.EmailSuppression
+	for _, event := range req.Events {
+		if event.Email == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "email is required"})
+		}
+		switch event.Type {
+		case "bounce":
+			if event.Hard != nil && !*event.Hard {
+				continue
+			}
+		case "complaint":
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "type must be one of: bounce, complaint"})
+		}
+		suppressions = append(suppressions, db.EmailSuppression{
+			ApplicationID: app.ID,
+			Email:         email.NormalizeAddress(event.Email),
+			Reason:        event.Type,
+			Source:        "webhook",
+			Detail:        event.Reason,
+		})
+	}
+
+	if req.DSN != "" {
+		recipients, err := email.ParseDSN([]byte(req.DSN))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		for _, recipient := range recipients {
+			if !recipient.Permanent() {
+				continue
+			}
+			suppressions = append(suppressions, db.EmailSuppression{
+				ApplicationID: app.ID,
+				Email:         recipient.Email,
+				Reason:        "bounce",
+				Source:        "dsn",
+				Detail:        strings.TrimSpace(recipient.Status + " " + recipient.DiagnosticCode),
+			})
+		}
+	}
+
+	for i := range suppressions {
+		if err := db.SuppressEmail(&suppressions[i]); err != nil {
+			log.Printf("Error suppressing %s: %v", suppressions[i].Email, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to store suppression"})
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Bounces processed", "suppressed": len(suppressions)})
+}
+
+type SuppressionRequest struct {
+	ApplicationName string `json:"application_name"`
+	Email           string `json:"email"`
+}
+
+// GetEmailSuppressions lists an application's suppressed addresses
+// GET /api/admin/email-suppressions?application_name=...&limit=50&offset=0
+func GetEmailSuppressions(c *fiber.Ctx) error {
+	app, err := db.GetApplicationByName(c.Query("application_name"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	suppressions, total, err := db.GetEmailSuppressions(app.ID.String(), limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch suppressions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"suppressions": suppressions,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// DeleteEmailSuppression removes an address from the suppression list so it can be emailed again
+// DELETE /api/admin/email-suppressions
+func DeleteEmailSuppression(c *fiber.Ctx) error {
+	var req SuppressionRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "application_name and email are required"})
+	}
+
+	app, err := db.GetApplicationByName(req.ApplicationName)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+
+	deleted, err := db.DeleteEmailSuppression(app.ID.String(), email.NormalizeAddress(req.Email))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete suppression"})
+	}
+	if deleted == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Suppression not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Suppression removed successfully"})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+)
+
+var testSvixKey = []byte("resend webhook signing key")
+
+// svixSign returns the v1 signature Svix sends for the body
+func svixSign(key []byte, id, timestamp, body string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id + "." + timestamp + "." + body))
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySvixSignature(t *testing.T) {
+	secret := "whsec_" + base64.StdEncoding.EncodeToString(testSvixKey)
+	body := `{"type": "email.bounced"}`
+	now := fmt.Sprint(time.Now().Unix())
+	valid := svixSign(testSvixKey, "msg_1", now, body)
+
+	tests := []struct {
+		name, secret, id, timestamp, signatures, body string
+		ok                                            bool
+	}{
+		{"valid", secret, "msg_1", now, valid, body, true},
+		{"secret without prefix", base64.StdEncoding.EncodeToString(testSvixKey), "msg_1", now, valid, body, true},
+		{"one of several signatures", secret, "msg_1", now, "v1,bm9wZQ== v2,abc " + valid, body, true},
+		{"rotated key", secret, "msg_1", now, svixSign([]byte("old key"), "msg_1", now, body), body, false},
+		{"other version", secret, "msg_1", now, "v2," + strings.TrimPrefix(valid, "v1,"), body, false},
+		{"tampered body", secret, "msg_1", now, valid, `{"type": "email.complained"}`, false},
+		{"other message id", secret, "msg_2", now, valid, body, false},
+		{"bad secret", "whsec_not base64!", "msg_1", now, valid, body, false},
+		{"missing signature", secret, "msg_1", now, "", body, false},
+		{"invalid timestamp", secret, "msg_1", "yesterday", valid, body, false},
+	}
+	for _, offset := range []time.Duration{-6 * time.Minute, 6 * time.Minute} {
+		timestamp := fmt.Sprint(time.Now().Add(offset).Unix())
+		tests = append(tests, struct {
+			name, secret, id, timestamp, signatures, body string
+			ok                                            bool
+		}{"timestamp off by " + offset.String(), secret, "msg_1", timestamp, svixSign(testSvixKey, "msg_1", timestamp, body), body, false})
+	}
+
+	for _, test := range tests {
+		err := verifySvixSignature(test.secret, test.id, test.timestamp, test.signatures, []byte(test.body))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestResendWebhookSuppressesOnlySignedPermanentBounces(t *testing.T) {
+	testStores(t)
+	t.Setenv("RESEND_WEBHOOK_SECRET", "whsec_"+base64.StdEncoding.EncodeToString(testSvixKey))
+	app := fiber.New()
+	app.Post("/api/email/webhooks/resend", HandleResendWebhook)
+
+	applicationID := uuid.New()
+	post := func(address, bounceType string, sign func(id, timestamp, body string) string) int {
+		body := fmt.Sprintf(`{"type": "email.bounced", "data": {"email_id": "e1", "to": [%q],
+			"tags": [{"name": "application_id", "value": %q}], "bounce": {"type": %q, "subType": "Suppressed", "message": "gone"}}}`,
+			address, applicationID, bounceType)
+		timestamp := fmt.Sprint(time.Now().Unix())
+		status, _ := do(t, app, http.MethodPost, "/api/email/webhooks/resend", body, map[string]string{
+			"svix-id":        "msg_" + address,
+			"svix-timestamp": timestamp,
+			"svix-signature": sign("msg_"+address, timestamp, body),
+		})
+		return status
+	}
+	signed := func(id, timestamp, body string) string { return svixSign(testSvixKey, id, timestamp, body) }
+	forged := func(id, timestamp, body string) string { return svixSign([]byte("guessed"), id, timestamp, body) }
+
+	if status := post("forged@example.com", "Permanent", forged); status != http.StatusUnauthorized {
+		t.Fatalf("forged event answered %d", status)
+	}
+	if status := post("Hard@Example.com", "Permanent", signed); status != http.StatusOK {
+		t.Fatalf("signed event answered %d", status)
+	}
+	if status := post("soft@example.com", "Transient", signed); status != http.StatusOK {
+		t.Fatalf("transient bounce answered %d", status)
+	}
+
+	suppressed, err := db.GetSuppressedEmails(applicationID.String(), []string{"forged@example.com", "hard@example.com", "soft@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !suppressed["hard@example.com"] || suppressed["forged@example.com"] || suppressed["soft@example.com"] {
+		t.Fatalf("suppressed %v, want hard@example.com only", suppressed)
+	}
+}
+
+func TestIngestBouncesParsesTheDSN(t *testing.T) {
+	testStores(t)
+	application := &db.Application{ID: uuid.New(), Name: "bounces"}
+	app := fiber.New()
+	app.Post("/api/email/bounces", withLocals(map[string]interface{}{"app": application}, IngestBounces))
+
+	dsn := "Content-Type: multipart/report; report-type=delivery-status; boundary=\"b\"\r\n\r\n" +
+		"--b\r\nContent-Type: message/delivery-status\r\n\r\nReporting-MTA: dns; mx.example.com\r\n\r\n" +
+		"Final-Recipient: rfc822; hard@example.com\r\nAction: failed\r\nStatus: 5.1.1\r\n\r\n" +
+		"Final-Recipient: rfc822; later@example.com\r\nAction: delayed\r\nStatus: 4.4.7\r\n--b--\r\n"
+	body := fmt.Sprintf(`{"events": [{"email": "spam@example.com", "type": "complaint"}, {"email": "soft@example.com", "type": "bounce", "hard": false}], "dsn": %q}`, dsn)
+	status, response := do(t, app, http.MethodPost, "/api/email/bounces", body, nil)
+	if status != http.StatusOK || !strings.Contains(response, `"suppressed":2`) {
+		t.Fatalf("got %d %s", status, response)
+	}
+
+	suppressed, _ := db.GetSuppressedEmails(application.ID.String(), []string{"hard@example.com", "later@example.com", "spam@example.com", "soft@example.com"})
+	if len(suppressed) != 2 || !suppressed["hard@example.com"] || !suppressed["spam@example.com"] {
+		t.Fatalf("suppressed %v", suppressed)
+	}
+
+	if status, _ := do(t, app, http.MethodPost, "/api/email/bounces", `{"dsn": "not a bounce"}`, nil); status != http.StatusBadRequest {
+		t.Fatalf("an invalid DSN answered %d", status)
+	}
+}
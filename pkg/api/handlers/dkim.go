@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/dkim"
+)
+
+type DKIMKeyRequest struct {
+	ApplicationName string `json:"application_name"`
+	Domain          string `json:"domain"`
+	Selector        string `json:"selector"`
+	PrivateKey      string `json:"private_key"` // PEM encoded RSA or Ed25519 key
+}
+
+// SetDKIMKey sets the DKIM key the application's SMTP email is signed with
+// PUT /api/admin/dkim-key
+// Returns the DNS TXT record that has to be published for the key
+func SetDKIMKey(c *fiber.Ctx) error {
+	var req DKIMKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	app, err := db.GetApplicationByName(req.ApplicationName)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+
+	// the signature only counts for DMARC when d= aligns with the From address
+	if email.EmailChannel != nil && !email.EmailChannel.AlignsWithFrom(req.Domain) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("DKIM domain %s doesn't align with the From domain %s", req.Domain, email.EmailChannel.FromDomain()),
+		})
+	}
+
+	signer, err := dkim.NewSigner(req.Domain, req.Selector, []byte(req.PrivateKey))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	record, err := signer.DNSRecord()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = db.UpsertDKIMKey(&db.DKIMKey{
+		ApplicationID: app.ID,
+		Domain:        req.Domain,
+		Selector:      req.Selector,
+		PrivateKey:    req.PrivateKey,
+	})
+	if err != nil {
+		log.Printf("Error saving DKIM key: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save DKIM key"})
+	}
+	email.ForgetDKIMSigner(app.ID.String())
+
+	return c.JSON(fiber.Map{
+		"message":   "DKIM key saved successfully",
+		"algorithm": signer.Algorithm(),
+		"dns_name":  req.Selector + "._domainkey." + req.Domain,
+		"dns_value": record,
+	})
+}
+
+// DeleteDKIMKey removes the application's DKIM key, its mail falls back to the global key
+// DELETE /api/admin/dkim-key
+func DeleteDKIMKey(c *fiber.Ctx) error {
+	var req DKIMKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	app, err := db.GetApplicationByName(req.ApplicationName)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Application not found"})
+	}
+
+	deleted, err := db.DeleteDKIMKey(app.ID.String())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete DKIM key"})
+	}
+	if deleted == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "DKIM key not found"})
+	}
+	email.ForgetDKIMSigner(app.ID.String())
+
+	return c.JSON(fiber.Map{"message": "DKIM key deleted successfully"})
+}
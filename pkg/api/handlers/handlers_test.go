@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm/logger"
+)
+
+// testStores points the database at a fresh SQLite file and Redis at an in-memory server
+func testStores(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	models := []interface{}{
+		&db.Application{},
+		&db.Notification{},
+		&db.TelegramChatLink{},
+		&db.EmailSuppression{},
+		&db.EmailEvent{},
+		&db.EmailUnsubscribe{},
+		&db.InAppBroadcast{},
+		&db.InAppTopicSubscription{},
+		&db.InAppBroadcastWatermark{},
+	}
+	config := db.MySQLConfig{DSN: filepath.Join(t.TempDir(), "agni.db"), LogLevel: logger.Silent}
+	if err := db.InitMySQL("local", config, models...); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.CloseMySQL() })
+
+	server := miniredis.RunT(t)
+	db.RedisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { db.RedisClient.Close() })
+	return server
+}
+
+// withLocals runs the handler with the locals the authentication middleware sets
+func withLocals(locals map[string]interface{}, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for key, value := range locals {
+			c.Locals(key, value)
+		}
+		return handler(c)
+	}
+}
+
+// do sends a request to the app and returns the status and body
+func do(t *testing.T, app *fiber.App, method, target, body string, headers map[string]string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
@@ -2,18 +2,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/r1i2t3/agni/pkg/db"
-	"gorm.io/gorm"
+	"github.com/r1i2t3/agni/pkg/inapp"
+	"github.com/r1i2t3/agni/pkg/notification"
 )
 
-func supportsReadStateColumns(mySQLDB *gorm.DB) bool {
-	return mySQLDB.Migrator().HasColumn(&db.Notification{}, "read")
-}
-
 // GetInAppNotifications retrieves notifications for a secure authenticated user
 func GetInAppNotifications(c *fiber.Ctx) error {
 
@@ -26,47 +25,52 @@ func GetInAppNotifications(c *fiber.Ctx) error {
 			"error": "Unauthorized",
 		})
 	}
-	unreadOnly := c.Query("unread_only", "false") == "true"
-	limit := c.QueryInt("limit", 50)
-	offset := c.QueryInt("offset", 0)
-	//print all for debugging
-	println(userID, unreadOnly, limit, offset)
-	mySQLDB := db.GetMySQLDB()
-	query := mySQLDB.Where("application_id = ? AND recipient = ? AND channel = ?",
-		applicationID, userID, "InApp")
-
-	if unreadOnly {
-		if supportsReadStateColumns(mySQLDB) {
-			query = query.Where(map[string]interface{}{"read": false})
-		} else {
-			query = query.Where("status <> ?", "read")
-		}
+	filter := db.InAppNotificationFilter{
+		UnreadOnly: c.Query("unread_only", "false") == "true",
+		Category:   c.Query("category"),
+		Archived:   c.Query("archived", "false") == "true",
+		Pinned:     queryBool(c, "pinned"),
+	}
+	page := db.InAppPageRequest{
+		Limit:        c.QueryInt("limit", db.DefaultInAppPageSize),
+		Cursor:       c.Query("cursor"),
+		IncludeTotal: c.Query("include_total", "false") == "true",
 	}
 
-	// Get total count
-	var total int64
-	query.Model(&db.Notification{}).Count(&total)
-
-	// Get notifications
-	var notifications []db.Notification
-	err := query.Order("created_at DESC").
-		Limit(limit).
-		Offset(offset).
-		Find(&notifications).Error
-
+	result, err := db.GetInAppNotifications(applicationID, userID, filter, page)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid cursor",
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to fetch notifications",
 		})
 	}
 
-	return c.JSON(fiber.Map{
-		"notifications": notifications,
-		"total":         total,
-		"limit":         limit,
-		"offset":        offset,
-		"has_more":      offset+len(notifications) < int(total),
-	})
+	response := fiber.Map{
+		"notifications": result.Notifications,
+		"next_cursor":   result.NextCursor,
+		"has_more":      result.NextCursor != "",
+	}
+	if result.Total != nil {
+		response["total"] = *result.Total
+	}
+	return c.JSON(response)
+}
+
+// queryBool reads an optional true/false query parameter, nil when it is missing
+func queryBool(c *fiber.Ctx, key string) *bool {
+	var value bool
+	switch c.Query(key) {
+	case "true":
+		value = true
+	case "false":
+	default:
+		return nil
+	}
+	return &value
 }
 
 // MarkAsRead marks a single notification as read
@@ -97,33 +101,22 @@ func MarkNotificationAsRead(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update notification
-	mySQLDB := db.GetMySQLDB()
-	now := time.Now()
-	updates := map[string]interface{}{}
-	if supportsReadStateColumns(mySQLDB) {
-		updates["read"] = true
-		updates["read_at"] = now
-	} else {
-		updates["status"] = "read"
-	}
-
-	result := mySQLDB.Model(&db.Notification{}).
-		Where("id = ? AND application_id = ? AND recipient = ? AND channel = ?", id, applicationID, userID, "InApp").
-		Updates(updates)
-
-	if result.Error != nil {
+	updated, err := db.MarkInAppNotificationRead(applicationID, userID, id)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to mark notification as read",
 		})
 	}
 
-	if result.RowsAffected == 0 {
+	if updated == 0 {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "Notification not found",
 		})
 	}
 
+	// Sync the badge and read state on the user's other devices
+	inapp.PublishReadState(c.Context(), db.GetRedisClient(), applicationID, userID, []string{id.String()})
+
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "Notification marked as read",
@@ -144,34 +137,20 @@ func MarkAllNotificationsAsRead(c *fiber.Ctx) error {
 	}
 
 	// Update all unread notifications for this user
-	mySQLDB := db.GetMySQLDB()
-	now := time.Now()
-	query := mySQLDB.Model(&db.Notification{}).
-		Where("application_id = ? AND recipient = ? AND channel = ?",
-			applicationID, userID, "InApp")
-
-	updates := map[string]interface{}{}
-	if supportsReadStateColumns(mySQLDB) {
-		query = query.Where(map[string]interface{}{"read": false})
-		updates["read"] = true
-		updates["read_at"] = now
-	} else {
-		query = query.Where("status <> ?", "read")
-		updates["status"] = "read"
-	}
-
-	result := query.Updates(updates)
-
-	if result.Error != nil {
+	updated, err := db.MarkAllInAppNotificationsRead(applicationID, userID)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to mark notifications as read",
 		})
 	}
+	if updated > 0 {
+		inapp.PublishReadState(c.Context(), db.GetRedisClient(), applicationID, userID, nil)
+	}
 
 	return c.JSON(fiber.Map{
 		"success": true,
 		"message": "All notifications marked as read",
-		"count":   result.RowsAffected,
+		"count":   updated,
 	})
 }
 
@@ -188,21 +167,8 @@ func GetUnreadCount(c *fiber.Ctx) error {
 	}
 
 	// Count unread notifications
-	mySQLDB := db.GetMySQLDB()
-	var count int64
-	query := mySQLDB.Model(&db.Notification{}).
-		Where("application_id = ? AND recipient = ? AND channel = ?",
-			applicationID, userID, "InApp")
-
-	if supportsReadStateColumns(mySQLDB) {
-		query = query.Where(map[string]interface{}{"read": false})
-	} else {
-		query = query.Where("status <> ?", "read")
-	}
-
-	err := query.Count(&count).Error
+	count, err := db.CountUnreadInAppNotifications(applicationID, userID)
 	if err != nil {
-		println(err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get unread count",
 		})
@@ -213,3 +179,195 @@ func GetUnreadCount(c *fiber.Ctx) error {
 		"unread_count": count,
 	})
 }
+
+// updateInAppNotification applies an inbox change to one notification of the authenticated user
+// and syncs it to the user's connected devices
+func updateInAppNotification(c *fiber.Ctx, action string, snoozedUntil *time.Time, update func(applicationID, userID string, id uuid.UUID) (int64, error)) error {
+	applicationID := c.Locals("application_id").(string)
+
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid notification ID",
+		})
+	}
+
+	updated, err := update(applicationID, userID, id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notification",
+		})
+	}
+	if updated == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Notification not found",
+		})
+	}
+
+	rdb := db.GetRedisClient()
+	switch action {
+	case inapp.InboxSnoozed, inapp.InboxUnsnoozed, inapp.InboxDeleted:
+		// the in-app service tells the devices when the snooze ends
+		inapp.ScheduleSnoozeEnd(c.Context(), rdb, applicationID, userID, id.String(), snoozedUntil)
+	}
+	inapp.PublishInboxChange(c.Context(), rdb, applicationID, userID, id.String(), action, snoozedUntil)
+
+	return c.JSON(fiber.Map{
+		"success":         true,
+		"notification_id": id.String(),
+		"action":          action,
+	})
+}
+
+// ArchiveNotification moves a notification out of the inbox
+// PUT /api/inapp/notifications/:id/archive
+func ArchiveNotification(c *fiber.Ctx) error {
+	return updateInAppNotification(c, inapp.InboxArchived, nil, func(applicationID, userID string, id uuid.UUID) (int64, error) {
+		return db.ArchiveInAppNotification(applicationID, userID, id, true)
+	})
+}
+
+// UnarchiveNotification moves an archived notification back into the inbox
+// DELETE /api/inapp/notifications/:id/archive
+func UnarchiveNotification(c *fiber.Ctx) error {
+	return updateInAppNotification(c, inapp.InboxUnarchived, nil, func(applicationID, userID string, id uuid.UUID) (int64, error) {
+		return db.ArchiveInAppNotification(applicationID, userID, id, false)
+	})
+}
+
+// PinNotification pins a notification
+// PUT /api/inapp/notifications/:id/pin
+func PinNotification(c *fiber.Ctx) error {
+	return updateInAppNotification(c, inapp.InboxPinned, nil, func(applicationID, userID string, id uuid.UUID) (int64, error) {
+		return db.PinInAppNotification(applicationID, userID, id, true)
+	})
+}
+
+// UnpinNotification unpins a notification
+// DELETE /api/inapp/notifications/:id/pin
+func UnpinNotification(c *fiber.Ctx) error {
+	return updateInAppNotification(c, inapp.InboxUnpinned, nil, func(applicationID, userID string, id uuid.UUID) (int64, error) {
+		return db.PinInAppNotification(applicationID, userID, id, false)
+	})
+}
+
+type SnoozeNotificationRequest struct {
+	Until time.Time `json:"until"`
+}
+
+// SnoozeNotification hides a notification from the inbox until the given time
+// PUT /api/inapp/notifications/:id/snooze
+func SnoozeNotification(c *fiber.Ctx) error {
+	var request SnoozeNotificationRequest
+	if err := c.BodyParser(&request); err != nil || request.Until.IsZero() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "until is required (RFC 3339)",
+		})
+	}
+	if !request.Until.After(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "until must be in the future",
+		})
+	}
+	until := request.Until
+	return updateInAppNotification(c, inapp.InboxSnoozed, &until, func(applicationID, userID string, id uuid.UUID) (int64, error) {
+		return db.SnoozeInAppNotification(applicationID, userID, id, &until)
+	})
+}
+
+// UnsnoozeNotification brings a snoozed notification back into the inbox
+// DELETE /api/inapp/notifications/:id/snooze
+func UnsnoozeNotification(c *fiber.Ctx) error {
+	return updateInAppNotification(c, inapp.InboxUnsnoozed, nil, func(applicationID, userID string, id uuid.UUID) (int64, error) {
+		return db.SnoozeInAppNotification(applicationID, userID, id, nil)
+	})
+}
+
+// DeleteNotification removes a notification from the user's inbox and archive
+// DELETE /api/inapp/notifications/:id
+func DeleteNotification(c *fiber.Ctx) error {
+	return updateInAppNotification(c, inapp.InboxDeleted, nil, db.DeleteInAppNotification)
+}
+
+const maxTopicSubscribersPerRequest = 1000
+
+type TopicSubscribersRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// parseTopicSubscribers reads the topic and the user ids of a subscribers request
+func parseTopicSubscribers(c *fiber.Ctx) (string, []string, error) {
+	topic := c.Params("topic")
+	if err := notification.ValidateInAppTopic(topic); err != nil {
+		return "", nil, err
+	}
+	var request TopicSubscribersRequest
+	if err := c.BodyParser(&request); err != nil || len(request.UserIDs) == 0 {
+		return "", nil, errors.New("user_ids is required")
+	}
+	if len(request.UserIDs) > maxTopicSubscribersPerRequest {
+		return "", nil, fmt.Errorf("at most %d user_ids per request", maxTopicSubscribersPerRequest)
+	}
+	return topic, request.UserIDs, nil
+}
+
+// SubscribeUsersToTopic adds users to a topic that in-app broadcasts can target
+// PUT /api/topics/:topic/subscribers
+func SubscribeUsersToTopic(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid application context",
+		})
+	}
+	topic, userIDs, err := parseTopicSubscribers(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := db.SubscribeInAppTopic(app.ID, topic, userIDs); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to subscribe users",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"topic":   topic,
+	})
+}
+
+// UnsubscribeUsersFromTopic removes users from a topic
+// DELETE /api/topics/:topic/subscribers
+func UnsubscribeUsersFromTopic(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid application context",
+		})
+	}
+	topic, userIDs, err := parseTopicSubscribers(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	removed, err := db.UnsubscribeInAppTopic(app.ID, topic, userIDs)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unsubscribe users",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"topic":   topic,
+		"count":   removed,
+	})
+}
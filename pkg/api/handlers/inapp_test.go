@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/inapp"
+	"github.com/redis/go-redis/v9"
+)
+
+// inboxApp serves the inbox routes to one authenticated user and returns the events published
+// to the user's devices
+func inboxApp(t *testing.T) (*fiber.App, uuid.UUID, <-chan inapp.Response) {
+	t.Helper()
+	testStores(t)
+	applicationID := uuid.New()
+	locals := map[string]interface{}{"application_id": applicationID.String(), "user_id": "user"}
+
+	app := fiber.New()
+	app.Get("/notifications", withLocals(locals, GetInAppNotifications))
+	app.Put("/notifications/:id/archive", withLocals(locals, ArchiveNotification))
+	app.Delete("/notifications/:id/archive", withLocals(locals, UnarchiveNotification))
+	app.Put("/notifications/:id/pin", withLocals(locals, PinNotification))
+	app.Delete("/notifications/:id/pin", withLocals(locals, UnpinNotification))
+	app.Put("/notifications/:id/snooze", withLocals(locals, SnoozeNotification))
+	app.Delete("/notifications/:id/snooze", withLocals(locals, UnsnoozeNotification))
+	app.Delete("/notifications/:id", withLocals(locals, DeleteNotification))
+
+	sub := db.RedisClient.Subscribe(context.Background(), inapp.BroadcastChannel(applicationID.String()+":user"))
+	t.Cleanup(func() { sub.Close() })
+	if _, err := sub.Receive(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan inapp.Response, 16)
+	go func() {
+		for msg := range sub.Channel() {
+			var event inapp.Response
+			json.Unmarshal([]byte(msg.Payload), &event)
+			events <- event
+		}
+	}()
+	return app, applicationID, events
+}
+
+func inboxNotification(t *testing.T, applicationID uuid.UUID, userID string) db.Notification {
+	t.Helper()
+	notification := db.Notification{ID: uuid.New(), ApplicationID: applicationID, QueueID: uuid.NewString(), Channel: "InApp", Recipient: userID, Status: "sent"}
+	if err := db.GetMySQLDB().Create(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+// listed returns the ids of the notifications the inbox query lists
+func listed(t *testing.T, app *fiber.App, query string) []string {
+	t.Helper()
+	status, body := do(t, app, http.MethodGet, "/notifications"+query, "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("listing answered %d %s", status, body)
+	}
+	var page struct {
+		Notifications []db.Notification `json:"notifications"`
+	}
+	json.Unmarshal([]byte(body), &page)
+	ids := make([]string, 0, len(page.Notifications))
+	for _, n := range page.Notifications {
+		ids = append(ids, n.ID.String())
+	}
+	return ids
+}
+
+// expectInboxChange waits for the updated event of the change and the unread count following it
+func expectInboxChange(t *testing.T, events <-chan inapp.Response, notificationID, action string, unread int) {
+	t.Helper()
+	for _, want := range []string{inapp.EventUpdated, inapp.EventUnreadCount} {
+		select {
+		case event := <-events:
+			data, _ := event.Data.(map[string]interface{})
+			if event.Type != want ||
+				(want == inapp.EventUpdated && (data["notification_id"] != notificationID || data["action"] != action)) ||
+				(want == inapp.EventUnreadCount && data["unread_count"] != float64(unread)) {
+				t.Fatalf("got %+v, want the %s event of %s %s", event, want, action, notificationID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event for %s", want, action)
+		}
+	}
+}
+
+func TestArchiveAndPinNotifications(t *testing.T) {
+	app, applicationID, events := inboxApp(t)
+	archived := inboxNotification(t, applicationID, "user").ID.String()
+	pinned := inboxNotification(t, applicationID, "user").ID.String()
+
+	if status, _ := do(t, app, http.MethodPut, "/notifications/"+archived+"/archive", "", nil); status != http.StatusOK {
+		t.Fatalf("archive answered %d", status)
+	}
+	expectInboxChange(t, events, archived, inapp.InboxArchived, 1)
+	if ids := listed(t, app, ""); len(ids) != 1 || ids[0] != pinned {
+		t.Fatalf("the inbox lists %v", ids)
+	}
+	if ids := listed(t, app, "?archived=true"); len(ids) != 1 || ids[0] != archived {
+		t.Fatalf("the archive lists %v", ids)
+	}
+
+	if status, _ := do(t, app, http.MethodPut, "/notifications/"+pinned+"/pin", "", nil); status != http.StatusOK {
+		t.Fatalf("pin answered %d", status)
+	}
+	expectInboxChange(t, events, pinned, inapp.InboxPinned, 1)
+	if ids := listed(t, app, "?pinned=true"); len(ids) != 1 || ids[0] != pinned {
+		t.Fatalf("the pinned notifications are %v", ids)
+	}
+	if ids := listed(t, app, "?pinned=false"); len(ids) != 0 {
+		t.Fatalf("the unpinned notifications are %v", ids)
+	}
+
+	do(t, app, http.MethodDelete, "/notifications/"+pinned+"/pin", "", nil)
+	expectInboxChange(t, events, pinned, inapp.InboxUnpinned, 1)
+	do(t, app, http.MethodDelete, "/notifications/"+archived+"/archive", "", nil)
+	expectInboxChange(t, events, archived, inapp.InboxUnarchived, 2)
+	if ids := listed(t, app, "?pinned=false"); len(ids) != 2 {
+		t.Fatalf("the inbox lists %v", ids)
+	}
+}
+
+func TestSnoozeSchedulesTheEnd(t *testing.T) {
+	app, applicationID, events := inboxApp(t)
+	id := inboxNotification(t, applicationID, "user").ID.String()
+	member := fmt.Sprintf("%s:user:%s", applicationID, id)
+
+	for _, body := range []string{`{}`, `{"until": "tomorrow"}`, fmt.Sprintf(`{"until": %q}`, time.Now().Add(-time.Minute).Format(time.RFC3339))} {
+		if status, _ := do(t, app, http.MethodPut, "/notifications/"+id+"/snooze", body, nil); status != http.StatusBadRequest {
+			t.Fatalf("%s answered %d", body, status)
+		}
+	}
+
+	until := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	body := fmt.Sprintf(`{"until": %q}`, until.Format(time.RFC3339Nano))
+	if status, _ := do(t, app, http.MethodPut, "/notifications/"+id+"/snooze", body, nil); status != http.StatusOK {
+		t.Fatalf("snooze answered %d", status)
+	}
+	expectInboxChange(t, events, id, inapp.InboxSnoozed, 0)
+	if ids := listed(t, app, ""); len(ids) != 0 {
+		t.Fatalf("the snoozed notification is listed: %v", ids)
+	}
+	score, err := db.RedisClient.ZScore(context.Background(), inapp.SnoozesKey, member).Result()
+	if err != nil || int64(score) != until.UnixMilli() {
+		t.Fatalf("the snooze end is scheduled at %v (%v), want %d", score, err, until.UnixMilli())
+	}
+
+	// unsnoozing by hand cancels the scheduled end, WatchSnoozes has nothing left to publish
+	if status, _ := do(t, app, http.MethodDelete, "/notifications/"+id+"/snooze", "", nil); status != http.StatusOK {
+		t.Fatalf("unsnooze answered %d", status)
+	}
+	expectInboxChange(t, events, id, inapp.InboxUnsnoozed, 1)
+	if err := db.RedisClient.ZScore(context.Background(), inapp.SnoozesKey, member).Err(); err != redis.Nil {
+		t.Fatalf("the snooze end is still scheduled: %v", err)
+	}
+	if ids := listed(t, app, ""); len(ids) != 1 {
+		t.Fatalf("the inbox lists %v", ids)
+	}
+}
+
+func TestDeleteNotification(t *testing.T) {
+	app, applicationID, events := inboxApp(t)
+	id := inboxNotification(t, applicationID, "user").ID.String()
+	someoneElses := inboxNotification(t, applicationID, "someone-else").ID.String()
+
+	until := time.Now().Add(time.Hour).Format(time.RFC3339)
+	do(t, app, http.MethodPut, "/notifications/"+id+"/snooze", fmt.Sprintf(`{"until": %q}`, until), nil)
+	expectInboxChange(t, events, id, inapp.InboxSnoozed, 0)
+
+	if status, _ := do(t, app, http.MethodDelete, "/notifications/"+id, "", nil); status != http.StatusOK {
+		t.Fatalf("delete answered %d", status)
+	}
+	expectInboxChange(t, events, id, inapp.InboxDeleted, 0)
+	if ids := append(listed(t, app, ""), listed(t, app, "?archived=true")...); len(ids) != 0 {
+		t.Fatalf("the deleted notification is listed: %v", ids)
+	}
+	if n := db.RedisClient.ZCard(context.Background(), inapp.SnoozesKey).Val(); n != 0 {
+		t.Fatalf("the snooze of the deleted notification is still scheduled")
+	}
+
+	for _, target := range []string{"/notifications/" + id, "/notifications/" + someoneElses, "/notifications/" + uuid.NewString() + "/archive"} {
+		if status, _ := do(t, app, http.MethodDelete, target, "", nil); status != http.StatusNotFound {
+			t.Errorf("DELETE %s answered %d", target, status)
+		}
+	}
+	if status, _ := do(t, app, http.MethodPut, "/notifications/42/pin", "", nil); status != http.StatusBadRequest {
+		t.Errorf("an invalid id answered %d", status)
+	}
+}
+
+func TestListingPagesThroughTheInbox(t *testing.T) {
+	app, applicationID, _ := inboxApp(t)
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < db.MaxInAppPageSize+1; i++ {
+		notification := db.Notification{ID: uuid.New(), ApplicationID: applicationID, QueueID: uuid.NewString(), Channel: "InApp", Recipient: "user", Status: "sent", CreatedAt: createdAt}
+		if err := db.GetMySQLDB().Create(&notification).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type page struct {
+		Notifications []db.Notification `json:"notifications"`
+		NextCursor    string            `json:"next_cursor"`
+		HasMore       bool              `json:"has_more"`
+		Total         *int64            `json:"total"`
+	}
+	get := func(query string) page {
+		t.Helper()
+		status, body := do(t, app, http.MethodGet, "/notifications"+query, "", nil)
+		if status != http.StatusOK {
+			t.Fatalf("%s answered %d %s", query, status, body)
+		}
+		var p page
+		json.Unmarshal([]byte(body), &p)
+		return p
+	}
+
+	// all created at the same time, the cursor still moves past every page
+	first := get("?limit=1000&include_total=true")
+	if len(first.Notifications) != db.MaxInAppPageSize || !first.HasMore || first.NextCursor == "" || first.Total == nil || *first.Total != db.MaxInAppPageSize+1 {
+		t.Fatalf("unexpected first page: %d notifications, has_more %v, total %v", len(first.Notifications), first.HasMore, first.Total)
+	}
+	last := get("?limit=1000&cursor=" + first.NextCursor)
+	if len(last.Notifications) != 1 || last.HasMore || last.NextCursor != "" || last.Total != nil {
+		t.Fatalf("unexpected last page: %d notifications, has_more %v, next_cursor %q", len(last.Notifications), last.HasMore, last.NextCursor)
+	}
+	for _, n := range first.Notifications {
+		if n.ID == last.Notifications[0].ID {
+			t.Fatalf("%s is listed on both pages", n.ID)
+		}
+	}
+
+	if status, body := do(t, app, http.MethodGet, "/notifications?cursor=garbage", "", nil); status != http.StatusBadRequest {
+		t.Fatalf("an invalid cursor answered %d %s", status, body)
+	}
+}
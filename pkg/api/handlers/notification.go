@@ -18,6 +18,9 @@ type NotificationRequest struct {
 	Message            string                           `json:"message"`
 	MessageContentType string                           `json:"message_content_type,omitempty"`
 	TemplateID         string                           `json:"template_id,omitempty"`
+	Email              *notification.EmailOptions       `json:"email,omitempty"`
+	WebPush            *notification.WebPushOptions     `json:"webpush,omitempty"`
+	InApp              *notification.InAppOptions       `json:"in_app,omitempty"`
 }
 
 func EnqueueNotification(c *fiber.Ctx) error {
@@ -36,6 +39,17 @@ func EnqueueNotification(c *fiber.Ctx) error {
 	if err := c.BodyParser(&request); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
 	}
+	if request.WebPush != nil {
+		if err := request.WebPush.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	if request.InApp != nil {
+		if err := request.InApp.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
 
 	// Use the application data
 	notification := notification.Notification{
@@ -47,6 +61,10 @@ func EnqueueNotification(c *fiber.Ctx) error {
 		Message:            request.Message,
 		MessageContentType: request.MessageContentType,
 		Subject:            request.Subject,
+		TemplateID:         request.TemplateID,
+		Email:              request.Email,
+		WebPush:            request.WebPush,
+		InApp:              request.InApp,
 		Status:             "queued",
 		CreatedAt:          time.Now(),
 	}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/inapp/presence"
+)
+
+// presenceEventsHeartbeat keeps proxies from closing an idle events stream
+const presenceEventsHeartbeat = 15 * time.Second
+
+// GetUserPresence tells whether a user of the application is connected to the in-app service
+// GET /api/presence/:user_id
+func GetUserPresence(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid application context",
+		})
+	}
+
+	userPresence, err := presence.Get(c.Context(), db.GetRedisClient(), app.ID.String(), c.Params("user_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get presence",
+		})
+	}
+	return c.JSON(userPresence)
+}
+
+// StreamPresenceEvents streams the presence of the application's users as server-sent events
+// when they come online or leave a node, e.g. {"user_id": "42", "online": false, "devices": 0}
+// GET /api/presence/events
+func StreamPresenceEvents(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid application context",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	channel := presence.EventsChannel(app.ID.String())
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		pubsub := db.GetRedisClient().Subscribe(context.Background(), channel)
+		defer pubsub.Close()
+
+		ticker := time.NewTicker(presenceEventsHeartbeat)
+		defer ticker.Stop()
+		events := pubsub.Channel()
+		w.WriteString(": connected\n\n")
+		for {
+			// fails once the client is gone
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			select {
+			case msg, ok := <-events:
+				if !ok {
+					return
+				}
+				fmt.Fprintf(w, "event: presence\ndata: %s\n\n", msg.Payload)
+			case <-ticker.C:
+				w.WriteString(": ping\n\n")
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+)
+
+type DeviceTokenRequest struct {
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+	Provider string `json:"provider,omitempty"`
+	Device   string `json:"device,omitempty"`
+}
+
+// RegisterDeviceToken stores a mobile push token for the authenticated user
+// POST /api/push/devices
+// Body: { "token": "...", "platform": "ios|android", "provider": "fcm|apns" (optional) }
+func RegisterDeviceToken(c *fiber.Ctx) error {
+	applicationID := c.Locals("application_id").(string)
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var req DeviceTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request"})
+	}
+	if req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	// iOS apps can use either APNs directly or FCM, android always uses FCM
+	provider := req.Provider
+	switch req.Platform {
+	case "ios":
+		if provider == "" {
+			provider = "apns"
+		}
+	case "android":
+		if provider == "" {
+			provider = "fcm"
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "platform must be one of: ios, android"})
+	}
+	if provider != "fcm" && provider != "apns" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "provider must be one of: fcm, apns"})
+	}
+
+	deviceToken := &db.DeviceToken{
+		ApplicationID: uuid.MustParse(applicationID),
+		UserID:        userID,
+		Token:         req.Token,
+		Platform:      req.Platform,
+		Provider:      provider,
+		Device:        req.Device,
+	}
+	if err := db.SaveDeviceToken(deviceToken); err != nil {
+		log.Printf("Error saving device token: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save device token"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Device registered successfully"})
+}
+
+// UnregisterDeviceToken removes a mobile push token of the authenticated user
+// DELETE /api/push/devices
+// Body: { "token": "..." }
+func UnregisterDeviceToken(c *fiber.Ctx) error {
+	applicationID := c.Locals("application_id").(string)
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var req DeviceTokenRequest
+	if err := c.BodyParser(&req); err != nil || req.Token == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "token is required"})
+	}
+
+	deleted, err := db.DeleteDeviceToken(applicationID, userID, req.Token)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to remove device token"})
+	}
+	if deleted == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Device token not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Device unregistered successfully"})
+}
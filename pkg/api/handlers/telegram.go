@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/config"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/channels/telegram"
+	"github.com/r1i2t3/agni/pkg/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	telegramLinkKeyPrefix = "telegram:link:"
+	telegramLinkCodeTTL   = 10 * time.Minute
+)
+
+// TelegramUpdate is the part of a Telegram webhook update we care about
+type TelegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID   int64  `json:"id"`
+			Type string `json:"type"`
+		} `json:"chat"`
+		From struct {
+			Username string `json:"username"`
+		} `json:"from"`
+	} `json:"message"`
+}
+
+// CreateTelegramLinkCode issues a one-time code the user sends to the bot as /start <code>
+// POST /api/telegram/link-code
+func CreateTelegramLinkCode(c *fiber.Ctx) error {
+	applicationID := c.Locals("application_id").(string)
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	code, err := utils.GenerateRandomHex(12)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to generate link code",
+		})
+	}
+
+	rdb := db.GetRedisClient()
+	value := fmt.Sprintf("%s:%s", applicationID, userID)
+	if err := rdb.Set(c.Context(), telegramLinkKeyPrefix+code, value, telegramLinkCodeTTL).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to store link code",
+		})
+	}
+
+	response := fiber.Map{
+		"code":       code,
+		"expires_in": int(telegramLinkCodeTTL.Seconds()),
+	}
+	if botUsername := config.GetEnvConfig().TelegramEnvConfig.BotUsername; botUsername != "" {
+		response["link"] = fmt.Sprintf("https://t.me/%s?start=%s", strings.TrimPrefix(botUsername, "@"), code)
+	}
+	return c.JSON(response)
+}
+
+// HandleTelegramWebhook receives bot updates and links chats sending /start <code>
+// POST /api/telegram/webhook
+// Telegram retries non-2xx responses, so anything but a bad secret is answered with 200.
+func HandleTelegramWebhook(c *fiber.Ctx) error {
+	secret := config.GetEnvConfig().TelegramEnvConfig.WebhookSecret
+	if secret == "" || subtle.ConstantTimeCompare([]byte(c.Get("X-Telegram-Bot-Api-Secret-Token")), []byte(secret)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var update TelegramUpdate
+	if err := c.BodyParser(&update); err != nil {
+		log.Printf("Invalid telegram update: %v", err)
+		return c.SendStatus(fiber.StatusOK)
+	}
+	if update.Message == nil {
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "/start") {
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	chatID := update.Message.Chat.ID
+	reply := "✅ Your account is now linked. You will receive notifications here."
+	if err := linkTelegramChat(c.Context(), fields[1], chatID, update.Message.From.Username); err != nil {
+		log.Printf("Failed to link telegram chat %d: %v", chatID, err)
+		reply = "❌ This link code is invalid or has expired. Please request a new one."
+	}
+
+	if telegram.TelegramChannel != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := telegram.TelegramChannel.SendMessage(ctx, fmt.Sprintf("%d", chatID), reply, ""); err != nil {
+			log.Printf("Failed to send telegram link confirmation: %v", err)
+		}
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// linkTelegramChat consumes the one-time code and stores the chat for its user
+func linkTelegramChat(ctx context.Context, code string, chatID int64, username string) error {
+	value, err := db.GetRedisClient().GetDel(ctx, telegramLinkKeyPrefix+code).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return fmt.Errorf("unknown link code")
+		}
+		return err
+	}
+
+	applicationIDStr, userID, found := strings.Cut(value, ":")
+	if !found {
+		return fmt.Errorf("invalid link code value")
+	}
+	applicationID, err := uuid.Parse(applicationIDStr)
+	if err != nil {
+		return fmt.Errorf("invalid application id: %w", err)
+	}
+
+	return db.UpsertTelegramChatLink(applicationID, userID, chatID, username)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/channels/telegram"
+)
+
+const testTelegramSecret = "webhook-secret"
+
+// telegramApp serves the link code and webhook routes and records the replies of the bot
+func telegramApp(t *testing.T, applicationID, userID string) (*fiber.App, *[]string) {
+	t.Setenv("TELEGRAM_WEBHOOK_SECRET", testTelegramSecret)
+	var replies []string
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&message)
+		replies = append(replies, message.Text)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	t.Cleanup(bot.Close)
+	if err := telegram.NewTelegramNotifier(bot.URL, "bot-token"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { telegram.TelegramChannel = nil })
+
+	app := fiber.New()
+	app.Post("/api/telegram/link-code", withLocals(map[string]interface{}{
+		"application_id": applicationID,
+		"user_id":        userID,
+	}, CreateTelegramLinkCode))
+	app.Post("/api/telegram/webhook", HandleTelegramWebhook)
+	return app, &replies
+}
+
+func createLinkCode(t *testing.T, app *fiber.App) string {
+	status, body := do(t, app, http.MethodPost, "/api/telegram/link-code", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("link code: %d %s", status, body)
+	}
+	var response struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal([]byte(body), &response)
+	return response.Code
+}
+
+func sendStart(t *testing.T, app *fiber.App, secret, code string, chatID int64) int {
+	update := fmt.Sprintf(`{"update_id": 1, "message": {"text": "/start %s", "chat": {"id": %d, "type": "private"}}}`, code, chatID)
+	status, _ := do(t, app, http.MethodPost, "/api/telegram/webhook", update, map[string]string{
+		"X-Telegram-Bot-Api-Secret-Token": secret,
+	})
+	return status
+}
+
+func TestTelegramLinkCodesWorkOnce(t *testing.T) {
+	testStores(t)
+	applicationID := uuid.New().String()
+	app, replies := telegramApp(t, applicationID, "user-1")
+
+	code := createLinkCode(t, app)
+	if status := sendStart(t, app, testTelegramSecret, code, 1001); status != http.StatusOK {
+		t.Fatalf("webhook answered %d", status)
+	}
+	if chatID, err := db.GetTelegramChatID(applicationID, "user-1"); err != nil || chatID != 1001 {
+		t.Fatalf("expected chat 1001 to be linked, got %d %v", chatID, err)
+	}
+
+	// the code was consumed, another chat can't take over the user with it
+	sendStart(t, app, testTelegramSecret, code, 2002)
+	if chatID, _ := db.GetTelegramChatID(applicationID, "user-1"); chatID != 1001 {
+		t.Fatalf("a reused code linked chat %d", chatID)
+	}
+	if len(*replies) != 2 || !strings.Contains((*replies)[1], "invalid or has expired") {
+		t.Fatalf("unexpected replies %q", *replies)
+	}
+}
+
+func TestTelegramLinkCodesExpire(t *testing.T) {
+	redisServer := testStores(t)
+	applicationID := uuid.New().String()
+	app, replies := telegramApp(t, applicationID, "user-1")
+
+	code := createLinkCode(t, app)
+	redisServer.FastForward(telegramLinkCodeTTL + time.Second)
+	sendStart(t, app, testTelegramSecret, code, 1001)
+
+	if _, err := db.GetTelegramChatID(applicationID, "user-1"); err == nil {
+		t.Fatal("an expired code linked the chat")
+	}
+	if len(*replies) != 1 || !strings.Contains((*replies)[0], "invalid or has expired") {
+		t.Fatalf("unexpected replies %q", *replies)
+	}
+}
+
+func TestTelegramWebhookChecksTheSecretToken(t *testing.T) {
+	testStores(t)
+	applicationID := uuid.New().String()
+	app, replies := telegramApp(t, applicationID, "user-1")
+
+	code := createLinkCode(t, app)
+	for _, secret := range []string{"", "wrong-secret"} {
+		if status := sendStart(t, app, secret, code, 1001); status != http.StatusUnauthorized {
+			t.Fatalf("secret %q: expected 401, got %d", secret, status)
+		}
+	}
+	if _, err := db.GetTelegramChatID(applicationID, "user-1"); err == nil || len(*replies) != 0 {
+		t.Fatal("an update with a wrong secret was handled")
+	}
+
+	// the code is still there for the real update
+	if sendStart(t, app, testTelegramSecret, code, 1001); len(*replies) != 1 {
+		t.Fatalf("unexpected replies %q", *replies)
+	}
+	if chatID, _ := db.GetTelegramChatID(applicationID, "user-1"); chatID != 1001 {
+		t.Fatalf("expected chat 1001 to be linked, got %d", chatID)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/tracking"
+)
+
+// transparentGIF is a 1x1 transparent GIF
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// TrackEmailOpen records an open and serves the tracking pixel, the pixel is served
+// even when the signature is invalid so the email doesn't show a broken image
+// GET /t/o/:id?s=<signature>
+func TrackEmailOpen(c *fiber.Ctx) error {
+	if tracking.Tracker != nil && tracking.Tracker.VerifyOpen(c.Params("id"), c.Query("s")) {
+		recordEmailEvent(c, "open", "")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "no-store, no-cache, must-revalidate, max-age=0")
+	c.Set(fiber.HeaderContentType, "image/gif")
+	return c.Send(transparentGIF)
+}
+
+// TrackEmailClick records a click and redirects to the original link
+// GET /t/c/:id?u=<url>&s=<signature>
+func TrackEmailClick(c *fiber.Ctx) error {
+	target := c.Query("u")
+	if tracking.Tracker == nil || target == "" || !tracking.Tracker.VerifyClick(c.Params("id"), target, c.Query("s")) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid tracking link"})
+	}
+
+	recordEmailEvent(c, "click", target)
+	return c.Redirect(target, fiber.StatusFound)
+}
+
+// recordEmailEvent stores the event, tracking never fails the request of the email client
+func recordEmailEvent(c *fiber.Ctx, eventType string, url string) {
+	notification, err := db.GetNotificationByID(c.Params("id"))
+	if err != nil {
+		return
+	}
+
+	err = db.CreateEmailEvent(&db.EmailEvent{
+		NotificationID: notification.ID,
+		ApplicationID:  notification.ApplicationID,
+		TemplateID:     notification.TemplateID,
+		Type:           eventType,
+		URL:            url,
+		UserAgent:      c.Get(fiber.HeaderUserAgent),
+		IP:             c.IP(),
+	})
+	if err != nil {
+		log.Printf("Error recording email %s for %s: %v", eventType, notification.ID, err)
+	}
+}
+
+// GetNotificationEmailStats returns the opens and clicks of one email notification
+// GET /api/email/stats/notifications/:id
+func GetNotificationEmailStats(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid application context"})
+	}
+
+	notification, err := db.GetNotificationByID(c.Params("id"))
+	if err != nil || notification.ApplicationID != app.ID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Notification not found"})
+	}
+
+	stats, err := db.GetNotificationEmailStats(notification.ID.String())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch email stats"})
+	}
+	return c.JSON(stats)
+}
+
+// GetTemplateEmailStats returns open and click rates of all emails sent with a template
+// GET /api/email/stats/templates/:template_id
+func GetTemplateEmailStats(c *fiber.Ctx) error {
+	app, ok := c.Locals("app").(*db.Application)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid application context"})
+	}
+
+	stats, err := db.GetTemplateEmailStats(app.ID.String(), c.Params("template_id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch email stats"})
+	}
+	return c.JSON(stats)
+}
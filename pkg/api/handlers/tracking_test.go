@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/tracking"
+)
+
+func trackingApp(t *testing.T) (*fiber.App, db.Notification) {
+	t.Helper()
+	testStores(t)
+	if err := tracking.NewLinkTracker("https://agni.example.com", "link secret"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tracking.Tracker = nil })
+
+	notification := db.Notification{ID: uuid.New(), ApplicationID: uuid.New(), QueueID: uuid.NewString(), Channel: "email", TemplateID: "welcome"}
+	if err := db.GetMySQLDB().Create(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Get("/t/o/:id", TrackEmailOpen)
+	app.Get("/t/c/:id", TrackEmailClick)
+	return app, notification
+}
+
+// localPath strips the public base URL so the request can go to the test app
+func localPath(t *testing.T, trackingURL string) string {
+	t.Helper()
+	parsed, err := url.Parse(trackingURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.RequestURI()
+}
+
+func emailStats(t *testing.T, notification db.Notification) *db.NotificationEmailStats {
+	t.Helper()
+	stats, err := db.GetNotificationEmailStats(notification.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestTrackEmailOpenOnlyRecordsSignedPixels(t *testing.T) {
+	app, notification := trackingApp(t)
+	id := notification.ID.String()
+
+	for _, target := range []string{
+		"/t/o/" + id,
+		"/t/o/" + id + "?s=forged",
+		localPath(t, tracking.Tracker.OpenURL(uuid.NewString())),
+	} {
+		status, body := do(t, app, http.MethodGet, target, "", nil)
+		if status != http.StatusOK || body != string(transparentGIF) {
+			t.Fatalf("%s: the pixel wasn't served (%d)", target, status)
+		}
+	}
+	if stats := emailStats(t, notification); stats.Opens != 0 {
+		t.Fatalf("unsigned pixels recorded %d opens", stats.Opens)
+	}
+
+	if status, _ := do(t, app, http.MethodGet, localPath(t, tracking.Tracker.OpenURL(id)), "", nil); status != http.StatusOK {
+		t.Fatalf("the signed pixel answered %d", status)
+	}
+	if stats := emailStats(t, notification); stats.Opens != 1 || stats.FirstOpenedAt == nil {
+		t.Fatalf("expected the signed open recorded, got %+v", stats)
+	}
+}
+
+func TestTrackEmailClickRedirectsOnlySignedLinks(t *testing.T) {
+	app, notification := trackingApp(t)
+	id := notification.ID.String()
+	link := localPath(t, tracking.Tracker.ClickURL(id, "https://example.com/offer?a=1&b=2"))
+
+	req, _ := http.NewRequest(http.MethodGet, link, nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "https://example.com/offer?a=1&b=2" {
+		t.Fatalf("got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	forged := "/t/c/" + id + "?" + url.Values{"u": {"https://evil.example"}, "s": {"forged"}}.Encode()
+	if status, _ := do(t, app, http.MethodGet, forged, "", nil); status != http.StatusBadRequest {
+		t.Fatalf("a forged link answered %d", status)
+	}
+	if status, _ := do(t, app, http.MethodGet, "/t/c/"+id, "", nil); status != http.StatusBadRequest {
+		t.Fatalf("a link without target answered %d", status)
+	}
+
+	stats := emailStats(t, notification)
+	if stats.Clicks != 1 || len(stats.Links) != 1 || stats.Links[0].URL != "https://example.com/offer?a=1&b=2" {
+		t.Fatalf("expected the one signed click recorded, got %+v", stats)
+	}
+}
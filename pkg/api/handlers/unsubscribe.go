@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/unsubscribe"
+)
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Unsubscribe</title></head>
+<body style="font-family:sans-serif;max-width:480px;margin:48px auto;text-align:center">
+{{if .Done}}
+<h2>You have been unsubscribed</h2>
+<p>{{.Email}} will no longer receive {{if .Category}}"{{.Category}}" {{end}}emails.</p>
+{{else}}
+<h2>Unsubscribe</h2>
+<p>Stop sending {{if .Category}}"{{.Category}}" {{end}}emails to {{.Email}}?</p>
+<form method="post"><button type="submit">Unsubscribe</button></form>
+{{end}}
+</body>
+</html>`))
+
+type unsubscribePageData struct {
+	Email    string
+	Category string
+	Done     bool
+}
+
+// ShowUnsubscribePage asks the recipient to confirm, a GET never unsubscribes because
+// link scanners and prefetchers open every link of an email
+// GET /u/:token
+func ShowUnsubscribePage(c *fiber.Ctx) error {
+	claims, err := parseUnsubscribeToken(c.Params("token"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid unsubscribe link")
+	}
+
+	c.Type("html", "utf-8")
+	return unsubscribePage.Execute(c.Response().BodyWriter(), unsubscribePageData{
+		Email:    claims.Email,
+		Category: claims.Category,
+	})
+}
+
+// Unsubscribe records the opt-out, used both by the confirmation page and by mail
+// clients doing an RFC 8058 one-click POST with "List-Unsubscribe=One-Click"
+// POST /u/:token
+func Unsubscribe(c *fiber.Ctx) error {
+	claims, err := parseUnsubscribeToken(c.Params("token"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid unsubscribe link")
+	}
+	applicationID, err := uuid.Parse(claims.ApplicationID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid unsubscribe link")
+	}
+
+	if err := db.UnsubscribeEmail(applicationID, claims.Email, claims.Category); err != nil {
+		log.Printf("Error unsubscribing %s: %v", claims.Email, err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to unsubscribe, please try again")
+	}
+
+	// one-click requests come from the mail provider, not a browser
+	if c.FormValue("List-Unsubscribe") == "One-Click" {
+		return c.SendStatus(fiber.StatusOK)
+	}
+	c.Type("html", "utf-8")
+	return unsubscribePage.Execute(c.Response().BodyWriter(), unsubscribePageData{
+		Email:    claims.Email,
+		Category: claims.Category,
+		Done:     true,
+	})
+}
+
+func parseUnsubscribeToken(token string) (*unsubscribe.Claims, error) {
+	if unsubscribe.Links == nil {
+		return nil, fiber.ErrNotFound
+	}
+	return unsubscribe.Links.Parse(token)
+}
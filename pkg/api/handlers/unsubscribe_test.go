@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/unsubscribe"
+)
+
+func unsubscribeApp(t *testing.T) *fiber.App {
+	t.Helper()
+	testStores(t)
+	if err := unsubscribe.NewUnsubscriber("https://agni.example.com", "link secret"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { unsubscribe.Links = nil })
+
+	app := fiber.New()
+	app.Get("/u/:token", ShowUnsubscribePage)
+	app.Post("/u/:token", Unsubscribe)
+	return app
+}
+
+func isUnsubscribed(t *testing.T, applicationID uuid.UUID, email, category string) bool {
+	t.Helper()
+	unsubscribed, err := db.IsEmailUnsubscribed(applicationID.String(), email, category)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsubscribed
+}
+
+func TestUnsubscribePageOnlyOptsOutOnPost(t *testing.T) {
+	app := unsubscribeApp(t)
+	applicationID := uuid.New()
+	path := "/u/" + unsubscribe.Links.Token(unsubscribe.Claims{ApplicationID: applicationID.String(), Email: "User@Example.com", Category: "news"})
+
+	// link scanners open every link, the GET only asks for confirmation
+	status, body := do(t, app, http.MethodGet, path, "", nil)
+	if status != http.StatusOK || !strings.Contains(body, `<form method="post">`) || !strings.Contains(body, "User@Example.com") {
+		t.Fatalf("got %d %s", status, body)
+	}
+	if isUnsubscribed(t, applicationID, "user@example.com", "news") {
+		t.Fatal("the GET unsubscribed the recipient")
+	}
+
+	status, body = do(t, app, http.MethodPost, path, "", nil)
+	if status != http.StatusOK || !strings.Contains(body, "You have been unsubscribed") {
+		t.Fatalf("got %d %s", status, body)
+	}
+	if !isUnsubscribed(t, applicationID, "user@example.com", "news") {
+		t.Fatal("the POST didn't unsubscribe the recipient")
+	}
+	if isUnsubscribed(t, applicationID, "user@example.com", "") || isUnsubscribed(t, uuid.New(), "user@example.com", "news") {
+		t.Fatal("the opt-out leaked to another category or application")
+	}
+
+	// confirming twice is fine
+	if status, _ := do(t, app, http.MethodPost, path, "", nil); status != http.StatusOK {
+		t.Fatalf("the second POST answered %d", status)
+	}
+}
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	app := unsubscribeApp(t)
+	applicationID := uuid.New()
+	path := "/u/" + unsubscribe.Links.Token(unsubscribe.Claims{ApplicationID: applicationID.String(), Email: "user@example.com"})
+
+	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader("List-Unsubscribe=One-Click"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength > 2 {
+		t.Fatalf("expected a bare 200, got %d with %d bytes", resp.StatusCode, resp.ContentLength)
+	}
+	if !isUnsubscribed(t, applicationID, "user@example.com", "") {
+		t.Fatal("the one-click POST didn't unsubscribe the recipient")
+	}
+}
+
+func TestInvalidUnsubscribeLinks(t *testing.T) {
+	app := unsubscribeApp(t)
+	token := unsubscribe.Links.Token(unsubscribe.Claims{ApplicationID: uuid.NewString(), Email: "user@example.com"})
+	tampered := token[:len(token)-2] + "xx"
+	notUUID := unsubscribe.Links.Token(unsubscribe.Claims{ApplicationID: "app-1", Email: "user@example.com"})
+
+	for _, path := range []string{"/u/" + tampered, "/u/garbage"} {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			if status, _ := do(t, app, method, path, "", nil); status != http.StatusBadRequest {
+				t.Errorf("%s %s answered %d", method, path, status)
+			}
+		}
+	}
+	if status, _ := do(t, app, http.MethodPost, "/u/"+notUUID, "", nil); status != http.StatusBadRequest {
+		t.Errorf("a token without a valid application answered %d", status)
+	}
+}
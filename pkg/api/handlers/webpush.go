@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"log"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/channels/webpush"
 )
 
 type Subscription struct {
@@ -14,10 +17,37 @@ type Subscription struct {
 		P256dh string `json:"p256dh"`
 	} `json:"keys"`
 	Device string `json:"device,omitempty"`
-	UserID string `json:"user_id,omitempty"`
 }
 
+type WebPushSubscriptionResponse struct {
+	ID        string    `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	Device    string    `json:"device,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetVAPIDPublicKey serves the key browsers pass as applicationServerKey to pushManager.subscribe
+// GET /api/webpush/vapid-public-key
+func GetVAPIDPublicKey(c *fiber.Ctx) error {
+	publicKey := webpush.VAPIDPublicKey()
+	if publicKey == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Web push is not configured"})
+	}
+	return c.JSON(fiber.Map{"public_key": publicKey})
+}
+
+// HandleWebPushSubscription stores the browser's push subscription for the authenticated user
+// POST /api/webpush/subscribe
+// Body: the PushSubscription JSON { "endpoint": "...", "keys": { "p256dh": "...", "auth": "..." } }, "device" (optional)
 func HandleWebPushSubscription(c *fiber.Ctx) error {
+	applicationID := c.Locals("application_id").(string)
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
 	var sub Subscription
 	if err := c.BodyParser(&sub); err != nil {
 		log.Printf("Error parsing body: %v", err)
@@ -29,26 +59,78 @@ func HandleWebPushSubscription(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Endpoint, Auth key, and P256dh key are required"})
 	}
 
-	var subScriptionModel = &db.WebPushSubscription{
-		Endpoint: sub.Endpoint,
-		Auth:     sub.Keys.Auth,
-		P256dh:   sub.Keys.P256dh,
-		Device:   sub.Device,
-		UserID:   sub.UserID,
+	subscription := &db.WebPushSubscription{
+		ApplicationID: uuid.MustParse(applicationID),
+		UserID:        userID,
+		Endpoint:      sub.Endpoint,
+		Auth:          sub.Keys.Auth,
+		P256dh:        sub.Keys.P256dh,
+		Device:        sub.Device,
+	}
+	if err := db.SaveWebPushSubscription(subscription); err != nil {
+		log.Printf("Error saving subscription to DB: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save subscription"})
 	}
-	dbClient := db.GetMySQLDB()
 
-	// Check if subscription with endpoint already exists (prevent 500 error on duplicate unique key index conflict)
-	var existing db.WebPushSubscription
-	if err := dbClient.Where("endpoint = ?", sub.Endpoint).First(&existing).Error; err == nil {
-		log.Printf("WebPush subscription already registered for endpoint: %s", sub.Endpoint)
-		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Subscription created successfully"})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"message": "Subscription created successfully",
+		"id":      subscription.ID.String(),
+	})
+}
+
+// GetWebPushSubscriptions lists the authenticated user's push subscriptions
+// GET /api/webpush/subscriptions
+func GetWebPushSubscriptions(c *fiber.Ctx) error {
+	applicationID := c.Locals("application_id").(string)
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
 	}
 
-	if err := dbClient.Create(subScriptionModel).Error; err != nil {
-		log.Printf("Error saving subscription to DB: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save subscription"})
+	subscriptions, err := db.GetSubscriptionByUserId(applicationID, userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch subscriptions"})
+	}
+
+	response := make([]WebPushSubscriptionResponse, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		response = append(response, WebPushSubscriptionResponse{
+			ID:        sub.ID.String(),
+			Endpoint:  sub.Endpoint,
+			Device:    sub.Device,
+			CreatedAt: sub.CreatedAt,
+		})
+	}
+
+	return c.JSON(fiber.Map{"subscriptions": response})
+}
+
+// HandleWebPushUnsubscribe removes a push subscription of the authenticated user
+// DELETE /api/webpush/subscribe
+// Body: { "endpoint": "..." }
+func HandleWebPushUnsubscribe(c *fiber.Ctx) error {
+	applicationID := c.Locals("application_id").(string)
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+
+	var sub Subscription
+	if err := c.BodyParser(&sub); err != nil || sub.Endpoint == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "endpoint is required"})
+	}
+
+	deleted, err := db.DeleteWebPushSubscription(applicationID, userID, sub.Endpoint)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to remove subscription"})
+	}
+	if deleted == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Subscription not found"})
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "Subscription created successfully"})
+	return c.JSON(fiber.Map{"message": "Subscription removed successfully"})
 }
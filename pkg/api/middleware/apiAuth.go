@@ -5,18 +5,19 @@ import (
 	"github.com/r1i2t3/agni/pkg/db"
 )
 
+// APIKeyAuth authenticates an application from the X-API-Key and X-API-Secret headers,
+// for requests like GETs that can't carry the credentials in a JSON body
 func APIKeyAuth(c *fiber.Ctx) error {
 	apiKey := c.Get("X-API-Key")
-	if apiKey == "" {
+	apiSecret := c.Get("X-API-Secret")
+	if apiKey == "" || apiSecret == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "API key required",
+			"error": "API key and secret required",
 		})
 	}
 
 	// Validate API key against applications table
-	var app db.Application
-	mySQLDB := db.GetMySQLDB()
-	err := mySQLDB.Where("APIToken = ?", apiKey).First(&app).Error
+	app, err := db.GetApplicationByTokenAndSecret(apiKey, apiSecret)
 	if err != nil {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid API key",
@@ -24,6 +25,6 @@ func APIKeyAuth(c *fiber.Ctx) error {
 	}
 
 	// Store application in context
-	c.Locals("application", &app)
+	c.Locals("app", app)
 	return c.Next()
 }
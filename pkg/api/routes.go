@@ -32,17 +32,64 @@ func SetupRoutes(app *fiber.App) {
 	app.Get("/api/admin/applications", middleware.RequireAdmin, handlers.GetAllApplication)
 	app.Put("/api/admin/regenerate-token", middleware.RequireAdmin, handlers.RegenerateToken)
 	app.Put("/api/admin/delete-application", middleware.RequireAdmin, handlers.DeleteApplication)
+	app.Put("/api/admin/dkim-key", middleware.RequireAdmin, handlers.SetDKIMKey)
+	app.Delete("/api/admin/dkim-key", middleware.RequireAdmin, handlers.DeleteDKIMKey)
+	app.Get("/api/admin/email-suppressions", middleware.RequireAdmin, handlers.GetEmailSuppressions)
+	app.Delete("/api/admin/email-suppressions", middleware.RequireAdmin, handlers.DeleteEmailSuppression)
 
 	// ============ Notification Routes ============
 	app.Post("/api/notification/send", middleware.ApplicationAuth, handlers.EnqueueNotification)
 
+	// ============ Email Bounce Routes ============
+	app.Post("/api/email/webhooks/resend", handlers.HandleResendWebhook)
+	app.Post("/api/email/bounces", middleware.ApplicationAuth, handlers.IngestBounces)
+
+	// ============ Email Tracking and Unsubscribe Routes ============
+	app.Get("/t/o/:id", handlers.TrackEmailOpen)
+	app.Get("/t/c/:id", handlers.TrackEmailClick)
+	app.Get("/u/:token", handlers.ShowUnsubscribePage)
+	app.Post("/u/:token", handlers.Unsubscribe)
+	stats := app.Group("/api/email/stats", middleware.APIKeyAuth)
+	stats.Get("/notifications/:id", handlers.GetNotificationEmailStats)
+	stats.Get("/templates/:template_id", handlers.GetTemplateEmailStats)
+
 	// ============ In-App Notification Routes (JWT Protected) ============
 	inapp := app.Group("/api/inapp", middleware.ClientApplicationAuth)
 	inapp.Get("/notifications", handlers.GetInAppNotifications)
 	inapp.Get("/notifications/unread-count", handlers.GetUnreadCount)
 	inapp.Put("/notifications/:id/read", handlers.MarkNotificationAsRead)
 	inapp.Put("/notifications/read-all", handlers.MarkAllNotificationsAsRead)
+	inapp.Put("/notifications/:id/archive", handlers.ArchiveNotification)
+	inapp.Delete("/notifications/:id/archive", handlers.UnarchiveNotification)
+	inapp.Put("/notifications/:id/pin", handlers.PinNotification)
+	inapp.Delete("/notifications/:id/pin", handlers.UnpinNotification)
+	inapp.Put("/notifications/:id/snooze", handlers.SnoozeNotification)
+	inapp.Delete("/notifications/:id/snooze", handlers.UnsnoozeNotification)
+	inapp.Delete("/notifications/:id", handlers.DeleteNotification)
+
+	// In-app broadcast topics, managed by the application's backend
+	topics := app.Group("/api/topics", middleware.APIKeyAuth)
+	topics.Put("/:topic/subscribers", handlers.SubscribeUsersToTopic)
+	topics.Delete("/:topic/subscribers", handlers.UnsubscribeUsersFromTopic)
+
+	// ============ In-App Presence Routes ============
+	presence := app.Group("/api/presence", middleware.APIKeyAuth)
+	presence.Get("/events", handlers.StreamPresenceEvents)
+	presence.Get("/:user_id", handlers.GetUserPresence)
+
+	// ============ Mobile Push Routes (JWT Protected) ============
+	push := app.Group("/api/push", middleware.ClientApplicationAuth)
+	push.Post("/devices", handlers.RegisterDeviceToken)
+	push.Delete("/devices", handlers.UnregisterDeviceToken)
+
+	// ============ Telegram Routes ============
+	app.Post("/api/telegram/webhook", handlers.HandleTelegramWebhook)
+	app.Post("/api/telegram/link-code", middleware.ClientApplicationAuth, handlers.CreateTelegramLinkCode)
 
 	// ============ WebPush Routes ============
-	app.Post("/api/webpush/subscribe", handlers.HandleWebPushSubscription)
+	app.Get("/api/webpush/vapid-public-key", handlers.GetVAPIDPublicKey)
+	webpush := app.Group("/api/webpush", middleware.ClientApplicationAuth)
+	webpush.Post("/subscribe", handlers.HandleWebPushSubscription)
+	webpush.Delete("/subscribe", handlers.HandleWebPushUnsubscribe)
+	webpush.Get("/subscriptions", handlers.GetWebPushSubscriptions)
 }
@@ -2,13 +2,26 @@ package config
 
 import (
 	"log"
+	"os"
+	"strings"
 
 	//import email channel package
 	//import resend provider
+	"github.com/r1i2t3/agni/pkg/notification/channels/discord"
 	"github.com/r1i2t3/agni/pkg/notification/channels/email"
 	"github.com/r1i2t3/agni/pkg/notification/channels/email/EmailProviders"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/compose"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/dkim"
 	inapp "github.com/r1i2t3/agni/pkg/notification/channels/in-app"
+	"github.com/r1i2t3/agni/pkg/notification/channels/slack"
+	"github.com/r1i2t3/agni/pkg/notification/channels/teams"
+	"github.com/r1i2t3/agni/pkg/notification/channels/telegram"
 	"github.com/r1i2t3/agni/pkg/notification/channels/webpush"
+	"github.com/r1i2t3/agni/pkg/notification/tracking"
+	"github.com/r1i2t3/agni/pkg/notification/unsubscribe"
+
+	// Mobile push providers
+	pushproviders "github.com/r1i2t3/agni/pkg/notification/channels/push/PushProviders"
 
 	// SMS provider
 	smsproviders "github.com/r1i2t3/agni/pkg/notification/channels/sms/SMSProviders"
@@ -18,13 +31,45 @@ func InitializeEmailChannel(EmailEnvConfig *EmailEnvConfig) {
 	if EmailEnvConfig == nil {
 		log.Fatal("Email configuration is required")
 	}
-	log.Printf("Initializing email channel with config: %+v", EmailEnvConfig)
-	err := email.NewEmailNotifier(
-		EmailEnvConfig.SMTPHost,
-		EmailEnvConfig.SMTPPort,
-		EmailEnvConfig.SMTPUsername,
-		EmailEnvConfig.SMTPPassword,
-	)
+	log.Printf("Initializing email channel with host %s:%s", EmailEnvConfig.SMTPHost, EmailEnvConfig.SMTPPort)
+	// EMAIL_USE_TLS=false without an explicit mode keeps the old plain connection behaviour
+	tlsMode := EmailEnvConfig.TLSMode
+	if tlsMode == "" && !EmailEnvConfig.UseTLS {
+		tlsMode = email.TLSModeNone
+	}
+	var dkimSigner *dkim.Signer
+	if EmailEnvConfig.DKIMDomain != "" {
+		keyPEM := []byte(EmailEnvConfig.DKIMPrivateKey)
+		if EmailEnvConfig.DKIMPrivateKeyFile != "" {
+			var err error
+			if keyPEM, err = os.ReadFile(EmailEnvConfig.DKIMPrivateKeyFile); err != nil {
+				log.Printf("Failed to read DKIM private key: %v", err)
+			}
+		}
+		signer, err := dkim.NewSigner(EmailEnvConfig.DKIMDomain, EmailEnvConfig.DKIMSelector, keyPEM)
+		if err != nil {
+			log.Printf("Failed to initialize DKIM signing, emails will be sent unsigned: %v", err)
+		} else {
+			dkimSigner = signer
+			log.Printf("✅ DKIM signing enabled for %s (selector %s)", signer.Domain, signer.Selector)
+		}
+	}
+	if EmailEnvConfig.AttachmentAllowedHosts != "" {
+		compose.AllowAttachmentHosts(strings.Split(EmailEnvConfig.AttachmentAllowedHosts, ","))
+	}
+	err := email.NewEmailNotifier(email.SMTPConfig{
+		Host:         EmailEnvConfig.SMTPHost,
+		Port:         EmailEnvConfig.SMTPPort,
+		Username:     EmailEnvConfig.SMTPUsername,
+		Password:     EmailEnvConfig.SMTPPassword,
+		From:         EmailEnvConfig.FromAddress,
+		EnvelopeFrom: EmailEnvConfig.EnvelopeFrom,
+		TLSMode:      tlsMode,
+		AuthMethod:   EmailEnvConfig.AuthMethod,
+		PoolSize:     EmailEnvConfig.PoolSize,
+		IdleTimeout:  EmailEnvConfig.IdleTimeout,
+		DKIM:         dkimSigner,
+	})
 	if err != nil {
 		log.Printf("Failed to initialize email notifier: %v", err)
 	}
@@ -32,6 +77,13 @@ func InitializeEmailChannel(EmailEnvConfig *EmailEnvConfig) {
 	log.Println("✅ Email channel initialized successfully")
 }
 
+// CloseEmailChannel quits the pooled SMTP sessions, called on shutdown
+func CloseEmailChannel() {
+	if email.EmailChannel != nil {
+		email.EmailChannel.Close()
+	}
+}
+
 func InitializeResendProvider(ResendEnvConfig *ResendEnvConfig) {
 	if ResendEnvConfig == nil {
 		log.Fatal("Resend configuration is required")
@@ -65,6 +117,62 @@ func InitializeTwilioProvider(TwilioEnvConfig *TwilioEnvConfig) {
 	log.Println("✅ Twilio channel initialized successfully")
 }
 
+func InitializeVonageProvider(VonageEnvConfig *VonageEnvConfig) {
+	if VonageEnvConfig == nil {
+		log.Fatal("Vonage configuration is required")
+	}
+	_, err := smsproviders.NewVonageSender(
+		VonageEnvConfig.FromNumber,
+		VonageEnvConfig.APIKey,
+		VonageEnvConfig.APISecret,
+		VonageEnvConfig.BaseURL,
+	)
+	if err != nil {
+		log.Printf("Failed to initialize Vonage notifier: %v", err)
+	}
+
+	log.Println("✅ Vonage channel initialized successfully")
+}
+
+func InitializeMessageBirdProvider(MessageBirdConfig *MessageBirdConfig) {
+	if MessageBirdConfig == nil {
+		log.Fatal("MessageBird configuration is required")
+	}
+	_, err := smsproviders.NewMessageBirdSender(
+		MessageBirdConfig.Originator,
+		MessageBirdConfig.AccessKey,
+		MessageBirdConfig.BaseURL,
+	)
+	if err != nil {
+		log.Printf("Failed to initialize MessageBird notifier: %v", err)
+	}
+
+	log.Println("✅ MessageBird channel initialized successfully")
+}
+
+func InitializeSMSGatewayProvider(SMSGatewayConfig *SMSGatewayConfig) {
+	if SMSGatewayConfig == nil {
+		log.Fatal("SMS gateway configuration is required")
+	}
+	_, err := smsproviders.NewHTTPGatewaySender(smsproviders.HTTPGatewayConfig{
+		URLTemplate:        SMSGatewayConfig.URLTemplate,
+		Method:             SMSGatewayConfig.Method,
+		AuthHeader:         SMSGatewayConfig.AuthHeader,
+		AuthValue:          SMSGatewayConfig.AuthValue,
+		ContentType:        SMSGatewayConfig.ContentType,
+		BodyTemplate:       SMSGatewayConfig.BodyTemplate,
+		From:               SMSGatewayConfig.From,
+		SuccessStatus:      SMSGatewayConfig.SuccessStatus,
+		SuccessBodyPattern: SMSGatewayConfig.SuccessBodyPattern,
+		MessageIDPattern:   SMSGatewayConfig.MessageIDPattern,
+	})
+	if err != nil {
+		log.Printf("Failed to initialize SMS gateway notifier: %v", err)
+	}
+
+	log.Println("✅ SMS gateway channel initialized successfully")
+}
+
 func InitializeWebPushProvider(WebPushEnvConfig *WebPushEnvConfig) {
 	if WebPushEnvConfig == nil {
 		log.Fatal("WebPush configuration is required")
@@ -86,6 +194,128 @@ func InitializeInAppProvider(InAppConfig *InAppConfig) {
 	if InAppConfig == nil {
 		log.Fatal("InApp configuration is required")
 	}
-	inapp.NewInAppNotifier(InAppConfig.stream)
+	inapp.NewInAppNotifier(InAppConfig.stream, InAppConfig.maxLen)
 	log.Println("✅ InApp channel initialized successfully")
 }
+
+func InitializeSlackProvider(SlackEnvConfig *SlackEnvConfig) {
+	if SlackEnvConfig == nil {
+		log.Fatal("Slack configuration is required")
+	}
+	err := slack.NewSlackNotifier(
+		SlackEnvConfig.APIBaseURL,
+		SlackEnvConfig.WebhookBaseURL,
+		SlackEnvConfig.BotToken,
+	)
+	if err != nil {
+		log.Printf("Failed to initialize Slack notifier: %v", err)
+	}
+
+	log.Println("✅ Slack channel initialized successfully")
+}
+
+func InitializeDiscordProvider(DiscordEnvConfig *DiscordEnvConfig) {
+	if DiscordEnvConfig == nil {
+		log.Fatal("Discord configuration is required")
+	}
+	err := discord.NewDiscordNotifier(DiscordEnvConfig.WebhookBaseURL)
+	if err != nil {
+		log.Printf("Failed to initialize Discord notifier: %v", err)
+	}
+
+	log.Println("✅ Discord channel initialized successfully")
+}
+
+func InitializeTeamsProvider(TeamsEnvConfig *TeamsEnvConfig) {
+	if TeamsEnvConfig == nil {
+		log.Fatal("Teams configuration is required")
+	}
+	var allowedHosts []string
+	if TeamsEnvConfig.AllowedHosts != "" {
+		allowedHosts = strings.Split(TeamsEnvConfig.AllowedHosts, ",")
+	}
+	err := teams.NewTeamsNotifier(TeamsEnvConfig.WebhookBaseURL, allowedHosts)
+	if err != nil {
+		log.Printf("Failed to initialize Teams notifier: %v", err)
+	}
+
+	log.Println("✅ Teams channel initialized successfully")
+}
+
+func InitializeTelegramProvider(TelegramEnvConfig *TelegramEnvConfig) {
+	if TelegramEnvConfig == nil {
+		log.Fatal("Telegram configuration is required")
+	}
+	err := telegram.NewTelegramNotifier(
+		TelegramEnvConfig.APIBaseURL,
+		TelegramEnvConfig.BotToken,
+	)
+	if err != nil {
+		log.Printf("Failed to initialize Telegram notifier: %v", err)
+	}
+
+	log.Println("✅ Telegram channel initialized successfully")
+}
+
+func InitializePushProviders(PushEnvConfig *PushEnvConfig) {
+	if PushEnvConfig == nil {
+		log.Fatal("Push configuration is required")
+	}
+
+	credentials := []byte(PushEnvConfig.FCMServiceAccountJSON)
+	if len(credentials) == 0 && PushEnvConfig.FCMServiceAccountFile != "" {
+		var err error
+		if credentials, err = os.ReadFile(PushEnvConfig.FCMServiceAccountFile); err != nil {
+			log.Printf("Failed to read FCM service account file: %v", err)
+		}
+	}
+	_, err := pushproviders.NewFCMSender(credentials, PushEnvConfig.FCMBaseURL, PushEnvConfig.FCMTokenURL)
+	if err != nil {
+		log.Printf("Failed to initialize FCM sender: %v", err)
+	}
+
+	var apnsKey []byte
+	if PushEnvConfig.APNsKeyFile != "" {
+		if apnsKey, err = os.ReadFile(PushEnvConfig.APNsKeyFile); err != nil {
+			log.Printf("Failed to read APNs key file: %v", err)
+		}
+	}
+	_, err = pushproviders.NewAPNsSender(
+		apnsKey,
+		PushEnvConfig.APNsKeyID,
+		PushEnvConfig.APNsTeamID,
+		PushEnvConfig.APNsTopic,
+		PushEnvConfig.APNsBaseURL,
+	)
+	if err != nil {
+		log.Printf("Failed to initialize APNs sender: %v", err)
+	}
+
+	log.Println("✅ Push channel initialized successfully")
+}
+
+func InitializeEmailTracking(ServerEnvConfig *ServerEnvConfig) {
+	if ServerEnvConfig == nil {
+		log.Fatal("Server configuration is required")
+	}
+	err := tracking.NewLinkTracker(ServerEnvConfig.PublicURL, ServerEnvConfig.LinkSecret)
+	if err != nil {
+		log.Printf("Failed to initialize email tracking: %v", err)
+		return
+	}
+
+	log.Println("✅ Email tracking initialized successfully")
+}
+
+func InitializeEmailUnsubscribe(ServerEnvConfig *ServerEnvConfig) {
+	if ServerEnvConfig == nil {
+		log.Fatal("Server configuration is required")
+	}
+	err := unsubscribe.NewUnsubscriber(ServerEnvConfig.PublicURL, ServerEnvConfig.LinkSecret)
+	if err != nil {
+		log.Printf("Failed to initialize email unsubscribe links: %v", err)
+		return
+	}
+
+	log.Println("✅ Email unsubscribe links initialized successfully")
+}
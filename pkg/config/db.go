@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/r1i2t3/agni/pkg/db"
@@ -21,12 +22,47 @@ func InitializeRedis(redisConfig db.RedisConfig) {
 	}
 }
 
+// MySQLConnectionConfig builds the connection settings from the environment, local mode uses SQLite
+func MySQLConnectionConfig(envConfig EnvConfig) db.MySQLConfig {
+	var mySQLConfig db.MySQLConfig
+	if envConfig.ENV_MODE != "local" {
+		mySQLConfig.DSN = fmt.Sprintf("%s:%s@tcp(%s:3306)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+			envConfig.MySQLConfig.MYSQL_USER,
+			envConfig.MySQLConfig.MYSQL_ROOT_PASSWORD,
+			envConfig.MySQLConfig.DB_HOST,
+			envConfig.MySQLConfig.MYSQL_DATABASE,
+		)
+	}
+	return mySQLConfig
+}
+
+// ConnectMySQL opens the database without migrating it, for services that share the schema
+// the API server migrates
+func ConnectMySQL(mySQLConfig db.MySQLConfig) {
+	envConfig := GetEnvConfig()
+	if err := db.InitMySQL(envConfig.ENV_MODE, mySQLConfig); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	dbHealth := db.MySQLHealthCheck()
+	log.Printf("Database Health Status: %+v", dbHealth)
+}
+
 func InitializeMySQL(mySQLConfig db.MySQLConfig) {
 	envConfig := GetEnvConfig()
 	allModel := []interface{}{
 		&db.Application{},
 		&db.Notification{},
 		&db.WebPushSubscription{},
+		&db.TelegramChatLink{},
+		&db.DeviceToken{},
+		&db.DKIMKey{},
+		&db.EmailSuppression{},
+		&db.EmailEvent{},
+		&db.EmailUnsubscribe{},
+		&db.WebPushDelivery{},
+		&db.InAppBroadcast{},
+		&db.InAppTopicSubscription{},
+		&db.InAppBroadcastWatermark{},
 	}
 	if err := db.InitMySQL(envConfig.ENV_MODE, mySQLConfig, allModel...); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
@@ -22,6 +22,14 @@ type EnvConfig struct {
 	WebPushEnvConfig   WebPushEnvConfig
 	InAppConfig        InAppConfig
 	InAppServiceConfig InAppServiceConfig
+	SlackEnvConfig     SlackEnvConfig
+	DiscordEnvConfig   DiscordEnvConfig
+	TeamsEnvConfig     TeamsEnvConfig
+	TelegramEnvConfig  TelegramEnvConfig
+	PushEnvConfig      PushEnvConfig
+	VonageEnvConfig    VonageEnvConfig
+	MessageBirdConfig  MessageBirdConfig
+	SMSGatewayConfig   SMSGatewayConfig
 }
 
 func GetEnvConfig() EnvConfig {
@@ -39,16 +47,34 @@ func GetEnvConfig() EnvConfig {
 		WebPushEnvConfig:   GetWebPushEnvConfig(),
 		InAppConfig:        GetInAppConfig(),
 		InAppServiceConfig: GetInAppServiceConfig(),
+		SlackEnvConfig:     GetSlackEnvConfig(),
+		DiscordEnvConfig:   GetDiscordEnvConfig(),
+		TeamsEnvConfig:     GetTeamsEnvConfig(),
+		TelegramEnvConfig:  GetTelegramEnvConfig(),
+		PushEnvConfig:      GetPushEnvConfig(),
+		VonageEnvConfig:    GetVonageEnvConfig(),
+		MessageBirdConfig:  GetMessageBirdConfig(),
+		SMSGatewayConfig:   GetSMSGatewayConfig(),
 	}
 }
 
 type ServerEnvConfig struct {
 	Port string
+	// PublicURL is where clients reach this server, used in tracking and unsubscribe links
+	PublicURL string
+	// LinkSecret signs tracking and unsubscribe links, defaults to JWT_SECRET
+	LinkSecret string
 }
 
 func GetServerEnvConfig() ServerEnvConfig {
+	linkSecret := GetEnv("LINK_SIGNING_SECRET", "")
+	if linkSecret == "" {
+		linkSecret = GetEnv("JWT_SECRET", "")
+	}
 	return ServerEnvConfig{
-		Port: GetEnv("SERVER_PORT", "8080"),
+		Port:       GetEnv("SERVER_PORT", "8080"),
+		PublicURL:  GetEnv("PUBLIC_BASE_URL", ""),
+		LinkSecret: linkSecret,
 	}
 }
 
@@ -57,13 +83,18 @@ type InAppServiceConfig struct {
 	GroupName    string
 	ConsumerName string
 	Port         string
+	// ShutdownTimeout bounds the drain of the connections and the consumer on SIGTERM
+	ShutdownTimeout time.Duration
 }
 
 func GetInAppServiceConfig() InAppServiceConfig {
 	return InAppServiceConfig{GroupName: GetEnv("GroupName", "inapp-group"),
-		ConsumerName: GetEnv("CunsumerName", "inapp-consumer-1"),
+		// empty means one name per process, see inapp.DefaultConsumerName
+		ConsumerName: GetEnv("CunsumerName", ""),
 		StreamName:   GetEnv("StreamName", "inapp:stream"),
 		Port:         GetEnv("Port", "4000"),
+		// below the orchestrator's grace period, e.g. the 30s of Kubernetes
+		ShutdownTimeout: GetEnvAsDuration("INAPP_SHUTDOWN_TIMEOUT", 20*time.Second),
 	}
 }
 
@@ -154,6 +185,18 @@ type EmailEnvConfig struct {
 	SMTPPassword string
 	FromAddress  string
 	UseTLS       bool
+	EnvelopeFrom string
+	TLSMode      string
+	AuthMethod   string
+	PoolSize     int
+	IdleTimeout  time.Duration
+	// global DKIM key, applications can override it with their own
+	DKIMDomain         string
+	DKIMSelector       string
+	DKIMPrivateKey     string
+	DKIMPrivateKeyFile string
+	// comma separated hosts attachment URLs may point to, e.g. "cdn.example.com,*.s3.amazonaws.com"
+	AttachmentAllowedHosts string
 }
 
 func GetEmailEnvConfig() EmailEnvConfig {
@@ -162,20 +205,34 @@ func GetEmailEnvConfig() EmailEnvConfig {
 		SMTPPort:     GetEnv("EMAIL_SMTP_PORT", "587"),
 		SMTPUsername: GetEnv("EMAIL_SMTP_USERNAME", ""),
 		SMTPPassword: GetEnv("EMAIL_SMTP_PASSWORD", ""),
-		FromAddress:  GetEnv("EMAIL_FROM_ADDRESS", "noreply@example.com"),
+		FromAddress:  GetEnv("EMAIL_FROM_ADDRESS", ""),
 		UseTLS:       GetEnvAsBool("EMAIL_USE_TLS", true),
+		EnvelopeFrom: GetEnv("EMAIL_ENVELOPE_FROM", ""),
+		TLSMode:      GetEnv("EMAIL_SMTP_TLS_MODE", ""),
+		AuthMethod:   GetEnv("EMAIL_SMTP_AUTH", "plain"),
+		PoolSize:     GetEnvAsInt("EMAIL_SMTP_POOL_SIZE", 5),
+		IdleTimeout:  GetEnvAsDuration("EMAIL_SMTP_IDLE_TIMEOUT", 30*time.Second),
+
+		DKIMDomain:         GetEnv("EMAIL_DKIM_DOMAIN", ""),
+		DKIMSelector:       GetEnv("EMAIL_DKIM_SELECTOR", ""),
+		DKIMPrivateKey:     GetEnv("EMAIL_DKIM_PRIVATE_KEY", ""),
+		DKIMPrivateKeyFile: GetEnv("EMAIL_DKIM_PRIVATE_KEY_FILE", ""),
+
+		AttachmentAllowedHosts: GetEnv("EMAIL_ATTACHMENT_ALLOWED_HOSTS", ""),
 	}
 }
 
 type ResendEnvConfig struct {
-	APIKey      string
-	FromAddress string
+	APIKey        string
+	FromAddress   string
+	WebhookSecret string
 }
 
 func GetResendEnvConfig() ResendEnvConfig {
 	return ResendEnvConfig{
-		APIKey:      GetEnv("RESEND_API_KEY", ""),
-		FromAddress: GetEnv("RESEND_FROM_ADDRESS", ""),
+		APIKey:        GetEnv("RESEND_API_KEY", ""),
+		FromAddress:   GetEnv("RESEND_FROM_ADDRESS", ""),
+		WebhookSecret: GetEnv("RESEND_WEBHOOK_SECRET", ""),
 	}
 }
 
@@ -193,6 +250,65 @@ func GetTwilioEnvConfig() TwilioEnvConfig {
 	}
 }
 
+type VonageEnvConfig struct {
+	FromNumber string
+	APIKey     string
+	APISecret  string
+	BaseURL    string
+}
+
+func GetVonageEnvConfig() VonageEnvConfig {
+	return VonageEnvConfig{
+		FromNumber: GetEnv("VONAGE_FROM_NUMBER", ""),
+		APIKey:     GetEnv("VONAGE_API_KEY", ""),
+		APISecret:  GetEnv("VONAGE_API_SECRET", ""),
+		BaseURL:    GetEnv("VONAGE_BASE_URL", "https://rest.nexmo.com"),
+	}
+}
+
+type MessageBirdConfig struct {
+	Originator string
+	AccessKey  string
+	BaseURL    string
+}
+
+func GetMessageBirdConfig() MessageBirdConfig {
+	return MessageBirdConfig{
+		Originator: GetEnv("MESSAGEBIRD_ORIGINATOR", ""),
+		AccessKey:  GetEnv("MESSAGEBIRD_ACCESS_KEY", ""),
+		BaseURL:    GetEnv("MESSAGEBIRD_BASE_URL", "https://rest.messagebird.com"),
+	}
+}
+
+// SMSGatewayConfig configures the generic HTTP SMS gateway provider ("http")
+type SMSGatewayConfig struct {
+	URLTemplate        string
+	Method             string
+	AuthHeader         string
+	AuthValue          string
+	ContentType        string
+	BodyTemplate       string
+	From               string
+	SuccessStatus      string
+	SuccessBodyPattern string
+	MessageIDPattern   string
+}
+
+func GetSMSGatewayConfig() SMSGatewayConfig {
+	return SMSGatewayConfig{
+		URLTemplate:        GetEnv("SMS_GATEWAY_URL_TEMPLATE", ""),
+		Method:             GetEnv("SMS_GATEWAY_METHOD", "POST"),
+		AuthHeader:         GetEnv("SMS_GATEWAY_AUTH_HEADER", "Authorization"),
+		AuthValue:          GetEnv("SMS_GATEWAY_AUTH_VALUE", ""),
+		ContentType:        GetEnv("SMS_GATEWAY_CONTENT_TYPE", "application/json"),
+		BodyTemplate:       GetEnv("SMS_GATEWAY_BODY_TEMPLATE", ""),
+		From:               GetEnv("SMS_GATEWAY_FROM", ""),
+		SuccessStatus:      GetEnv("SMS_GATEWAY_SUCCESS_STATUS", "200,201,202"),
+		SuccessBodyPattern: GetEnv("SMS_GATEWAY_SUCCESS_BODY_PATTERN", ""),
+		MessageIDPattern:   GetEnv("SMS_GATEWAY_MESSAGE_ID_PATTERN", ""),
+	}
+}
+
 type WebPushEnvConfig struct {
 	VAPID_PUBLIC_KEY  string
 	VAPID_PRIVATE_KEY string
@@ -207,13 +323,96 @@ func GetWebPushEnvConfig() WebPushEnvConfig {
 	}
 }
 
+type SlackEnvConfig struct {
+	APIBaseURL     string
+	WebhookBaseURL string
+	BotToken       string
+}
+
+func GetSlackEnvConfig() SlackEnvConfig {
+	return SlackEnvConfig{
+		APIBaseURL:     GetEnv("SLACK_API_BASE_URL", "https://slack.com/api"),
+		WebhookBaseURL: GetEnv("SLACK_WEBHOOK_BASE_URL", "https://hooks.slack.com"),
+		BotToken:       GetEnv("SLACK_BOT_TOKEN", ""),
+	}
+}
+
+type DiscordEnvConfig struct {
+	WebhookBaseURL string
+}
+
+func GetDiscordEnvConfig() DiscordEnvConfig {
+	return DiscordEnvConfig{
+		WebhookBaseURL: GetEnv("DISCORD_WEBHOOK_BASE_URL", "https://discord.com/api/webhooks"),
+	}
+}
+
+type TeamsEnvConfig struct {
+	WebhookBaseURL string
+	AllowedHosts   string
+}
+
+func GetTeamsEnvConfig() TeamsEnvConfig {
+	return TeamsEnvConfig{
+		// empty accepts https webhook URLs on the allowed hosts, since Teams hosts are tenant specific
+		WebhookBaseURL: GetEnv("TEAMS_WEBHOOK_BASE_URL", ""),
+		// incoming webhook connectors and Workflows "post to a channel" URLs
+		AllowedHosts: GetEnv("TEAMS_WEBHOOK_ALLOWED_HOSTS", "*.webhook.office.com,*.logic.azure.com,*.powerplatform.com"),
+	}
+}
+
+type TelegramEnvConfig struct {
+	BotToken      string
+	BotUsername   string
+	APIBaseURL    string
+	WebhookSecret string
+}
+
+func GetTelegramEnvConfig() TelegramEnvConfig {
+	return TelegramEnvConfig{
+		BotToken:      GetEnv("TELEGRAM_BOT_TOKEN", ""),
+		BotUsername:   GetEnv("TELEGRAM_BOT_USERNAME", ""),
+		APIBaseURL:    GetEnv("TELEGRAM_API_BASE_URL", "https://api.telegram.org"),
+		WebhookSecret: GetEnv("TELEGRAM_WEBHOOK_SECRET", ""),
+	}
+}
+
+type PushEnvConfig struct {
+	FCMServiceAccountFile string
+	FCMServiceAccountJSON string
+	FCMBaseURL            string
+	FCMTokenURL           string
+	APNsKeyFile           string
+	APNsKeyID             string
+	APNsTeamID            string
+	APNsTopic             string
+	APNsBaseURL           string
+}
+
+func GetPushEnvConfig() PushEnvConfig {
+	return PushEnvConfig{
+		FCMServiceAccountFile: GetEnv("FCM_SERVICE_ACCOUNT_FILE", ""),
+		FCMServiceAccountJSON: GetEnv("FCM_SERVICE_ACCOUNT_JSON", ""),
+		FCMBaseURL:            GetEnv("FCM_BASE_URL", "https://fcm.googleapis.com"),
+		FCMTokenURL:           GetEnv("FCM_TOKEN_URL", ""), // defaults to token_uri of the service account
+		APNsKeyFile:           GetEnv("APNS_KEY_FILE", ""),
+		APNsKeyID:             GetEnv("APNS_KEY_ID", ""),
+		APNsTeamID:            GetEnv("APNS_TEAM_ID", ""),
+		APNsTopic:             GetEnv("APNS_TOPIC", ""),
+		APNsBaseURL:           GetEnv("APNS_BASE_URL", "https://api.push.apple.com"),
+	}
+}
+
 type InAppConfig struct {
 	stream string
+	// maxLen caps the stream, entries are kept after delivery and trimmed approximately
+	maxLen int64
 }
 
 func GetInAppConfig() InAppConfig {
 	return InAppConfig{
 		stream: GetEnv("Redis_InApp_streamName", "inapp:stream"),
+		maxLen: int64(GetEnvAsInt("Redis_InApp_streamMaxLen", 100000)),
 	}
 }
 
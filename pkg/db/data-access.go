@@ -1,6 +1,17 @@
 package db
 
-import "fmt"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
 
 type ApplicationResponse struct {
 	Name      string `json:"name"`
@@ -61,6 +72,15 @@ func DeleteApplication(name string) error {
 	return nil
 }
 
+func GetApplicationByName(name string) (*Application, error) {
+	var app Application
+	if err := GetMySQLDB().Where("name = ?", name).First(&app).Error; err != nil {
+		return nil, err
+	}
+
+	return &app, nil
+}
+
 func GetApplicationByTokenAndSecret(token string, secret string) (*Application, error) {
 	var app Application
 	something := GetMySQLDB()
@@ -71,12 +91,757 @@ func GetApplicationByTokenAndSecret(token string, secret string) (*Application,
 	return &app, nil
 }
 
-func GetSubscriptionByUserId(userID string) ([]WebPushSubscription, error) {
+func GetSubscriptionByUserId(applicationID string, userID string) ([]WebPushSubscription, error) {
 	var subscriptions []WebPushSubscription
 	dbClient := GetMySQLDB()
-	if err := dbClient.Where("user_id = ?", userID).Find(&subscriptions).Error; err != nil {
+	if err := dbClient.Where("application_id = ? AND user_id = ?", applicationID, userID).Find(&subscriptions).Error; err != nil {
 		return nil, err
 	}
 
 	return subscriptions, nil
 }
+
+// GetSubscriptionsByIDs returns the user's subscriptions among ids, used to retry only the failed devices
+func GetSubscriptionsByIDs(applicationID string, userID string, ids []string) ([]WebPushSubscription, error) {
+	var subscriptions []WebPushSubscription
+	if len(ids) == 0 {
+		return subscriptions, nil
+	}
+	if err := GetMySQLDB().Where("application_id = ? AND user_id = ? AND id IN ?", applicationID, userID, ids).Find(&subscriptions).Error; err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// SaveWebPushSubscription stores a subscription, a browser endpoint that is already known
+// moves to the new application and user since a browser has one endpoint per service worker
+func SaveWebPushSubscription(subscription *WebPushSubscription) error {
+	dbClient := GetMySQLDB()
+	var existing WebPushSubscription
+	if err := dbClient.Where("endpoint = ?", subscription.Endpoint).First(&existing).Error; err == nil {
+		subscription.ID = existing.ID
+		return dbClient.Model(&existing).Updates(map[string]interface{}{
+			"application_id": subscription.ApplicationID,
+			"user_id":        subscription.UserID,
+			"p256dh":         subscription.P256dh,
+			"auth":           subscription.Auth,
+			"device":         subscription.Device,
+		}).Error
+	}
+	return dbClient.Create(subscription).Error
+}
+
+func DeleteWebPushSubscription(applicationID string, userID string, endpoint string) (int64, error) {
+	result := GetMySQLDB().Where("application_id = ? AND user_id = ? AND endpoint = ?", applicationID, userID, endpoint).
+		Delete(&WebPushSubscription{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteWebPushSubscriptions removes subscriptions the push service reported as expired
+func DeleteWebPushSubscriptions(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return GetMySQLDB().Where("id IN ?", ids).Delete(&WebPushSubscription{}).Error
+}
+
+func CreateWebPushDeliveries(deliveries []WebPushDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return GetMySQLDB().Create(&deliveries).Error
+}
+
+func GetTelegramChatID(applicationID string, userID string) (int64, error) {
+	var link TelegramChatLink
+	dbClient := GetMySQLDB()
+	if err := dbClient.Where("application_id = ? AND user_id = ?", applicationID, userID).First(&link).Error; err != nil {
+		return 0, err
+	}
+
+	return link.ChatID, nil
+}
+
+// UpsertTelegramChatLink links the user to chatID, replacing any previous chat for that user
+func UpsertTelegramChatLink(applicationID uuid.UUID, userID string, chatID int64, username string) error {
+	dbClient := GetMySQLDB()
+	var link TelegramChatLink
+	err := dbClient.Where("application_id = ? AND user_id = ?", applicationID, userID).First(&link).Error
+	if err == nil {
+		return dbClient.Model(&link).Updates(map[string]interface{}{
+			"chat_id":  chatID,
+			"username": username,
+		}).Error
+	}
+
+	link = TelegramChatLink{
+		ApplicationID: applicationID,
+		UserID:        userID,
+		ChatID:        chatID,
+		Username:      username,
+	}
+	return dbClient.Create(&link).Error
+}
+
+// GetDeviceTokens returns the user's device tokens, optionally only those of one provider
+func GetDeviceTokens(applicationID string, userID string, provider string) ([]DeviceToken, error) {
+	var tokens []DeviceToken
+	query := GetMySQLDB().Where("application_id = ? AND user_id = ?", applicationID, userID)
+	if provider != "" {
+		query = query.Where("provider = ?", provider)
+	}
+	if err := query.Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// GetDeviceTokensByIDs returns the user's device tokens with the given ids, for retries
+func GetDeviceTokensByIDs(applicationID string, userID string, ids []string) ([]DeviceToken, error) {
+	var tokens []DeviceToken
+	if len(ids) == 0 {
+		return tokens, nil
+	}
+	if err := GetMySQLDB().Where("application_id = ? AND user_id = ? AND id IN ?", applicationID, userID, ids).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// SaveDeviceToken registers a token, moving it to the given user if the application registered it before
+func SaveDeviceToken(token *DeviceToken) error {
+	dbClient := GetMySQLDB()
+	var existing DeviceToken
+	if err := dbClient.Where("application_id = ? AND token = ?", token.ApplicationID, token.Token).First(&existing).Error; err == nil {
+		return dbClient.Model(&existing).Updates(map[string]interface{}{
+			"user_id":  token.UserID,
+			"platform": token.Platform,
+			"provider": token.Provider,
+			"device":   token.Device,
+		}).Error
+	}
+	return dbClient.Create(token).Error
+}
+
+func DeleteDeviceToken(applicationID string, userID string, token string) (int64, error) {
+	result := GetMySQLDB().Where("application_id = ? AND user_id = ? AND token = ?", applicationID, userID, token).
+		Delete(&DeviceToken{})
+	return result.RowsAffected, result.Error
+}
+
+// DeleteDeviceTokens removes the application's tokens reported as unregistered by the push providers
+func DeleteDeviceTokens(applicationID string, tokens []string) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return GetMySQLDB().Where("application_id = ? AND token IN ?", applicationID, tokens).Delete(&DeviceToken{}).Error
+}
+
+func GetDKIMKey(applicationID string) (*DKIMKey, error) {
+	var key DKIMKey
+	if err := GetMySQLDB().Where("application_id = ?", applicationID).First(&key).Error; err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// UpsertDKIMKey sets the application's DKIM key, replacing the previous one
+func UpsertDKIMKey(key *DKIMKey) error {
+	dbClient := GetMySQLDB()
+	var existing DKIMKey
+	if err := dbClient.Where("application_id = ?", key.ApplicationID).First(&existing).Error; err == nil {
+		return dbClient.Model(&existing).Updates(map[string]interface{}{
+			"domain":      key.Domain,
+			"selector":    key.Selector,
+			"private_key": key.PrivateKey,
+		}).Error
+	}
+	return dbClient.Create(key).Error
+}
+
+func DeleteDKIMKey(applicationID string) (int64, error) {
+	result := GetMySQLDB().Where("application_id = ?", applicationID).Delete(&DKIMKey{})
+	return result.RowsAffected, result.Error
+}
+
+// SuppressEmail adds the address to the application's suppression list, updating the reason if already there
+func SuppressEmail(suppression *EmailSuppression) error {
+	suppression.Email = strings.ToLower(strings.TrimSpace(suppression.Email))
+	dbClient := GetMySQLDB()
+	var existing EmailSuppression
+	if err := dbClient.Where("application_id = ? AND email = ?", suppression.ApplicationID, suppression.Email).First(&existing).Error; err == nil {
+		return dbClient.Model(&existing).Updates(map[string]interface{}{
+			"reason": suppression.Reason,
+			"source": suppression.Source,
+			"detail": suppression.Detail,
+		}).Error
+	}
+	return dbClient.Create(suppression).Error
+}
+
+// GetSuppressedEmails returns which of the given addresses are suppressed for the application
+func GetSuppressedEmails(applicationID string, emails []string) (map[string]bool, error) {
+	normalized := make([]string, len(emails))
+	for i, email := range emails {
+		normalized[i] = strings.ToLower(strings.TrimSpace(email))
+	}
+
+	var found []string
+	if err := GetMySQLDB().Model(&EmailSuppression{}).
+		Where("application_id = ? AND email IN ?", applicationID, normalized).
+		Pluck("email", &found).Error; err != nil {
+		return nil, err
+	}
+
+	suppressed := make(map[string]bool, len(found))
+	for _, email := range found {
+		suppressed[email] = true
+	}
+	return suppressed, nil
+}
+
+func GetEmailSuppressions(applicationID string, limit int, offset int) ([]EmailSuppression, int64, error) {
+	var suppressions []EmailSuppression
+	var total int64
+	query := GetMySQLDB().Model(&EmailSuppression{}).Where("application_id = ?", applicationID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&suppressions).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return suppressions, total, nil
+}
+
+func DeleteEmailSuppression(applicationID string, email string) (int64, error) {
+	result := GetMySQLDB().Where("application_id = ? AND email = ?", applicationID, strings.ToLower(strings.TrimSpace(email))).
+		Delete(&EmailSuppression{})
+	return result.RowsAffected, result.Error
+}
+
+func GetNotificationByID(id string) (*Notification, error) {
+	var notification Notification
+	if err := GetMySQLDB().Where("id = ?", id).First(&notification).Error; err != nil {
+		return nil, err
+	}
+
+	return &notification, nil
+}
+
+func CreateEmailEvent(event *EmailEvent) error {
+	return GetMySQLDB().Create(event).Error
+}
+
+type LinkClicks struct {
+	URL    string `json:"url"`
+	Clicks int64  `json:"clicks"`
+}
+
+type NotificationEmailStats struct {
+	NotificationID string       `json:"notification_id"`
+	Opens          int64        `json:"opens"`
+	Clicks         int64        `json:"clicks"`
+	FirstOpenedAt  *time.Time   `json:"first_opened_at,omitempty"`
+	Links          []LinkClicks `json:"links"`
+}
+
+func GetNotificationEmailStats(notificationID string) (*NotificationEmailStats, error) {
+	dbClient := GetMySQLDB()
+	stats := &NotificationEmailStats{NotificationID: notificationID, Links: []LinkClicks{}}
+
+	events := dbClient.Model(&EmailEvent{}).Where("notification_id = ?", notificationID)
+	if err := events.Session(&gorm.Session{}).Where("type = ?", "open").Count(&stats.Opens).Error; err != nil {
+		return nil, err
+	}
+	if err := events.Session(&gorm.Session{}).Where("type = ?", "click").Count(&stats.Clicks).Error; err != nil {
+		return nil, err
+	}
+	if stats.Opens > 0 {
+		var first EmailEvent
+		if err := events.Session(&gorm.Session{}).Where("type = ?", "open").Order("created_at ASC").First(&first).Error; err != nil {
+			return nil, err
+		}
+		stats.FirstOpenedAt = &first.CreatedAt
+	}
+	if err := events.Session(&gorm.Session{}).Select("url, COUNT(*) AS clicks").Where("type = ?", "click").
+		Group("url").Order("clicks DESC").Scan(&stats.Links).Error; err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+type TemplateEmailStats struct {
+	TemplateID string  `json:"template_id"`
+	Sent       int64   `json:"sent"`
+	Opened     int64   `json:"opened"`  // notifications opened at least once
+	Clicked    int64   `json:"clicked"` // notifications clicked at least once
+	Opens      int64   `json:"opens"`
+	Clicks     int64   `json:"clicks"`
+	OpenRate   float64 `json:"open_rate"`
+	ClickRate  float64 `json:"click_rate"`
+}
+
+func GetTemplateEmailStats(applicationID string, templateID string) (*TemplateEmailStats, error) {
+	dbClient := GetMySQLDB()
+	stats := &TemplateEmailStats{TemplateID: templateID}
+
+	if err := dbClient.Model(&Notification{}).
+		Where("application_id = ? AND template_id = ? AND channel = ? AND status = ?", applicationID, templateID, "email", "sent").
+		Count(&stats.Sent).Error; err != nil {
+		return nil, err
+	}
+
+	type eventCounts struct {
+		Type          string
+		Total         int64
+		Notifications int64
+	}
+	var counts []eventCounts
+	if err := dbClient.Model(&EmailEvent{}).
+		Select("type, COUNT(*) AS total, COUNT(DISTINCT notification_id) AS notifications").
+		Where("application_id = ? AND template_id = ?", applicationID, templateID).
+		Group("type").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		switch c.Type {
+		case "open":
+			stats.Opens, stats.Opened = c.Total, c.Notifications
+		case "click":
+			stats.Clicks, stats.Clicked = c.Total, c.Notifications
+		}
+	}
+	if stats.Sent > 0 {
+		stats.OpenRate = float64(stats.Opened) / float64(stats.Sent)
+		stats.ClickRate = float64(stats.Clicked) / float64(stats.Sent)
+	}
+
+	return stats, nil
+}
+
+// UnsubscribeEmail records the opt-out, unsubscribing twice is not an error
+func UnsubscribeEmail(applicationID uuid.UUID, email string, category string) error {
+	unsubscribe := EmailUnsubscribe{
+		ApplicationID: applicationID,
+		Email:         strings.ToLower(strings.TrimSpace(email)),
+		Category:      category,
+	}
+	return GetMySQLDB().
+		Where("application_id = ? AND email = ? AND category = ?", unsubscribe.ApplicationID, unsubscribe.Email, unsubscribe.Category).
+		FirstOrCreate(&unsubscribe).Error
+}
+
+func IsEmailUnsubscribed(applicationID string, email string, category string) (bool, error) {
+	var count int64
+	err := GetMySQLDB().Model(&EmailUnsubscribe{}).
+		Where("application_id = ? AND email = ? AND category = ?", applicationID, strings.ToLower(strings.TrimSpace(email)), category).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// notificationsHaveReadColumn tells whether the notifications table has the in-app read state
+// columns, older schemas track it in status instead. InitMySQL resolves it once the schema is migrated.
+var notificationsHaveReadColumn bool
+
+// inAppNotifications selects the user's in-app notifications that haven't expired or been deleted
+func inAppNotifications(dbClient *gorm.DB, applicationID string, userID string) *gorm.DB {
+	return dbClient.Model(&Notification{}).
+		Where("application_id = ? AND recipient = ? AND channel = ?", applicationID, userID, "InApp").
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("deleted_at IS NULL")
+}
+
+// inbox leaves out the archived notifications and those snoozed for now
+func inbox(query *gorm.DB) *gorm.DB {
+	return query.Where("archived_at IS NULL").
+		Where("snoozed_until IS NULL OR snoozed_until <= ?", time.Now())
+}
+
+func unread(query *gorm.DB) *gorm.DB {
+	if notificationsHaveReadColumn {
+		return query.Where(map[string]interface{}{"read": false})
+	}
+	return query.Where("status <> ?", "read")
+}
+
+// InAppNotificationFilter narrows the notifications GetInAppNotifications returns
+type InAppNotificationFilter struct {
+	UnreadOnly bool
+	Category   string
+	Archived   bool // the archived notifications instead of the inbox
+	// Pinned only selects the pinned notifications, or with false the others, pinning doesn't
+	// change the order. Inboxes show the pinned ones on top by listing them separately.
+	Pinned *bool
+}
+
+// Page sizes of the in-app inbox
+const (
+	DefaultInAppPageSize = 50
+	MaxInAppPageSize     = 100
+)
+
+// ErrInvalidCursor is returned for a cursor that wasn't handed out by GetInAppNotifications
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// InAppPageRequest selects a page of the inbox, the first one when Cursor is empty
+type InAppPageRequest struct {
+	Limit        int
+	Cursor       string // NextCursor of the previous page
+	IncludeTotal bool   // counting is a full scan of the user's notifications, only done on request
+}
+
+// InAppNotificationPage is a page of the inbox, NextCursor is empty on the last page
+type InAppNotificationPage struct {
+	Notifications []Notification
+	NextCursor    string
+	Total         *int64
+}
+
+// inAppCursor is the position after the last notification of a page, notifications are
+// ordered by (created_at, id) so rows arriving between pages don't shift the next page
+type inAppCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func encodeInAppCursor(n Notification) string {
+	data, _ := json.Marshal(inAppCursor{CreatedAt: n.CreatedAt, ID: n.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeInAppCursor(cursor string) (inAppCursor, error) {
+	var decoded inAppCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(data, &decoded) != nil || decoded.ID == uuid.Nil {
+		return decoded, ErrInvalidCursor
+	}
+	return decoded, nil
+}
+
+// GetInAppNotifications returns a page of the user's in-app notifications, newest first
+func GetInAppNotifications(applicationID string, userID string, filter InAppNotificationFilter, page InAppPageRequest) (InAppNotificationPage, error) {
+	dbClient := GetMySQLDB()
+	var result InAppNotificationPage
+	if err := MaterializeInAppBroadcasts(applicationID, userID); err != nil {
+		return result, err
+	}
+	query := inAppNotifications(dbClient, applicationID, userID)
+	if filter.Archived {
+		query = query.Where("archived_at IS NOT NULL")
+	} else {
+		query = inbox(query)
+	}
+	if filter.Pinned != nil {
+		if *filter.Pinned {
+			query = query.Where("pinned_at IS NOT NULL")
+		} else {
+			query = query.Where("pinned_at IS NULL")
+		}
+	}
+	if filter.UnreadOnly {
+		query = unread(query)
+	}
+	if filter.Category != "" {
+		query = query.Where("category = ?", filter.Category)
+	}
+
+	if page.IncludeTotal {
+		var total int64
+		if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+			return result, err
+		}
+		result.Total = &total
+	}
+
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultInAppPageSize
+	}
+	if limit > MaxInAppPageSize {
+		limit = MaxInAppPageSize
+	}
+	if page.Cursor != "" {
+		after, err := decodeInAppCursor(page.Cursor)
+		if err != nil {
+			return result, err
+		}
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", after.CreatedAt, after.CreatedAt, after.ID)
+	}
+
+	// one extra row tells whether there is a next page
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&result.Notifications).Error; err != nil {
+		return result, err
+	}
+	if len(result.Notifications) > limit {
+		result.Notifications = result.Notifications[:limit]
+		result.NextCursor = encodeInAppCursor(result.Notifications[limit-1])
+	}
+	return result, nil
+}
+
+func readUpdates() map[string]interface{} {
+	if notificationsHaveReadColumn {
+		return map[string]interface{}{"read": true, "read_at": time.Now()}
+	}
+	return map[string]interface{}{"status": "read"}
+}
+
+// MarkInAppNotificationRead marks one notification of the user as read, it returns the rows updated
+func MarkInAppNotificationRead(applicationID string, userID string, id uuid.UUID) (int64, error) {
+	dbClient := GetMySQLDB()
+	result := inAppNotifications(dbClient, applicationID, userID).
+		Where("id = ?", id).
+		Updates(readUpdates())
+	return result.RowsAffected, result.Error
+}
+
+// MarkAllInAppNotificationsRead marks all unread notifications of the user as read
+func MarkAllInAppNotificationsRead(applicationID string, userID string) (int64, error) {
+	dbClient := GetMySQLDB()
+	if err := MaterializeInAppBroadcasts(applicationID, userID); err != nil {
+		return 0, err
+	}
+	result := unread(inAppNotifications(dbClient, applicationID, userID)).
+		Updates(readUpdates())
+	return result.RowsAffected, result.Error
+}
+
+func CountUnreadInAppNotifications(applicationID string, userID string) (int64, error) {
+	dbClient := GetMySQLDB()
+	if err := MaterializeInAppBroadcasts(applicationID, userID); err != nil {
+		return 0, err
+	}
+	var count int64
+	err := unread(inbox(inAppNotifications(dbClient, applicationID, userID))).Count(&count).Error
+	return count, err
+}
+
+// updateInAppNotification returns 1 when the notification exists, also when it already was in
+// that state: MySQL only counts the rows that changed
+func updateInAppNotification(applicationID string, userID string, id uuid.UUID, updates map[string]interface{}) (int64, error) {
+	dbClient := GetMySQLDB()
+	result := inAppNotifications(dbClient, applicationID, userID).
+		Where("id = ?", id).
+		Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.RowsAffected, result.Error
+	}
+	var found int64
+	err := inAppNotifications(dbClient, applicationID, userID).Where("id = ?", id).Count(&found).Error
+	return found, err
+}
+
+// ArchiveInAppNotification moves a notification of the user out of the inbox, or back into it
+func ArchiveInAppNotification(applicationID string, userID string, id uuid.UUID, archived bool) (int64, error) {
+	var archivedAt *time.Time
+	if archived {
+		now := time.Now()
+		archivedAt = &now
+	}
+	return updateInAppNotification(applicationID, userID, id, map[string]interface{}{"archived_at": archivedAt})
+}
+
+// PinInAppNotification pins or unpins a notification of the user
+func PinInAppNotification(applicationID string, userID string, id uuid.UUID, pinned bool) (int64, error) {
+	var pinnedAt *time.Time
+	if pinned {
+		now := time.Now()
+		pinnedAt = &now
+	}
+	return updateInAppNotification(applicationID, userID, id, map[string]interface{}{"pinned_at": pinnedAt})
+}
+
+// SnoozeInAppNotification hides a notification of the user from the inbox until the given time,
+// nil brings it back
+func SnoozeInAppNotification(applicationID string, userID string, id uuid.UUID, until *time.Time) (int64, error) {
+	return updateInAppNotification(applicationID, userID, id, map[string]interface{}{"snoozed_until": until})
+}
+
+// DeleteInAppNotification removes a notification from the user's inbox, the row is kept
+func DeleteInAppNotification(applicationID string, userID string, id uuid.UUID) (int64, error) {
+	return updateInAppNotification(applicationID, userID, id, map[string]interface{}{"deleted_at": time.Now()})
+}
+
+// AckInAppNotifications records that the notifications reached one of the user's devices
+func AckInAppNotifications(applicationID string, userID string, ids []uuid.UUID) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := inAppNotifications(GetMySQLDB(), applicationID, userID).
+		Where("id IN ? AND status NOT IN ?", ids, []string{"delivered", "read"}).
+		Update("status", "delivered")
+	return result.RowsAffected, result.Error
+}
+
+// InAppBroadcastWindow is how far back the broadcasts reach a user seen for the first time
+const InAppBroadcastWindow = 30 * 24 * time.Hour
+
+// maxMaterializedBroadcasts bounds the inbox rows created for a user at once
+const maxMaterializedBroadcasts = 500
+
+// BroadcastNotificationID is the id of a user's inbox row for a broadcast, the same on every node
+func BroadcastNotificationID(broadcastID uuid.UUID, userID string) uuid.UUID {
+	return uuid.NewSHA1(broadcastID, []byte(userID))
+}
+
+// CreateInAppBroadcast stores a broadcast, a retry of the same broadcast is a no-op
+func CreateInAppBroadcast(broadcast *InAppBroadcast) error {
+	return GetMySQLDB().Clauses(clause.OnConflict{DoNothing: true}).Create(broadcast).Error
+}
+
+// broadcastWatermarkLag keeps the watermark behind the broadcasts being stored: another node
+// may store a broadcast a little later than it set its created_at
+const broadcastWatermarkLag = time.Minute
+
+// materializeBatchSize bounds the rows of one insert, MySQL limits the placeholders of a statement
+const materializeBatchSize = 500
+
+// MaterializeInAppBroadcasts creates the user's inbox rows for the recent broadcasts to all users
+// of the application and to the topics the user was subscribed to when they were sent. Only the
+// broadcasts newer than the user's watermark are looked at, usually none.
+func MaterializeInAppBroadcasts(applicationID string, userID string) error {
+	dbClient := GetMySQLDB()
+	now := time.Now()
+	since := now.Add(-InAppBroadcastWindow)
+
+	var watermark InAppBroadcastWatermark
+	err := dbClient.Where("application_id = ? AND user_id = ?", applicationID, userID).Limit(1).Find(&watermark).Error
+	if err != nil {
+		return err
+	}
+	if watermark.MaterializedUntil.After(since) {
+		since = watermark.MaterializedUntil
+	}
+	var newer []string
+	err = dbClient.Model(&InAppBroadcast{}).Where("application_id = ? AND created_at >= ?", applicationID, since).
+		Limit(1).Pluck("id", &newer).Error
+	if err != nil || len(newer) == 0 {
+		return err
+	}
+
+	subscribed := dbClient.Model(&InAppTopicSubscription{}).Select("1").
+		Where("in_app_topic_subscriptions.application_id = in_app_broadcasts.application_id").
+		Where("in_app_topic_subscriptions.topic = in_app_broadcasts.topic").
+		Where("in_app_topic_subscriptions.user_id = ? AND in_app_topic_subscriptions.created_at <= in_app_broadcasts.created_at", userID)
+	materialized := dbClient.Model(&Notification{}).Select("broadcast_id").
+		Where("application_id = ? AND recipient = ? AND broadcast_id IS NOT NULL", applicationID, userID)
+
+	var broadcasts []InAppBroadcast
+	err = dbClient.Where("application_id = ? AND created_at >= ?", applicationID, since).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("topic = '' OR EXISTS (?)", subscribed).
+		Where("id NOT IN (?)", materialized).
+		Order("created_at").
+		Limit(maxMaterializedBroadcasts).
+		Find(&broadcasts).Error
+	if err != nil {
+		return err
+	}
+
+	if len(broadcasts) > 0 {
+		rows := make([]Notification, 0, len(broadcasts))
+		for _, broadcast := range broadcasts {
+			rows = append(rows, broadcastNotification(broadcast, userID))
+		}
+		// another request or node may have created some of them meanwhile
+		if err := dbClient.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, materializeBatchSize).Error; err != nil {
+			return err
+		}
+		if len(broadcasts) == maxMaterializedBroadcasts {
+			// the next lookup creates the rest
+			return nil
+		}
+	}
+
+	// moved in steps of the lag, not on every lookup in the minute after a broadcast
+	until := now.Add(-broadcastWatermarkLag)
+	appID, err := uuid.Parse(applicationID)
+	if err != nil || until.Sub(since) < broadcastWatermarkLag {
+		return nil
+	}
+	return dbClient.Clauses(clause.OnConflict{UpdateAll: true}).Create(&InAppBroadcastWatermark{
+		ApplicationID:     appID,
+		UserID:            userID,
+		MaterializedUntil: until,
+	}).Error
+}
+
+// MaterializeInAppBroadcast creates the inbox rows of one broadcast for the given users at once,
+// the users already having one are skipped
+func MaterializeInAppBroadcast(broadcastID uuid.UUID, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	dbClient := GetMySQLDB()
+	var broadcast InAppBroadcast
+	if err := dbClient.Where("id = ?", broadcastID).First(&broadcast).Error; err != nil {
+		return err
+	}
+	rows := make([]Notification, 0, len(userIDs))
+	for _, userID := range userIDs {
+		rows = append(rows, broadcastNotification(broadcast, userID))
+	}
+	return dbClient.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&rows, materializeBatchSize).Error
+}
+
+// broadcastNotification is the inbox row of a broadcast for the user
+func broadcastNotification(broadcast InAppBroadcast, userID string) Notification {
+	broadcastID := broadcast.ID
+	id := BroadcastNotificationID(broadcastID, userID)
+	return Notification{
+		ID:                 id,
+		ApplicationID:      broadcast.ApplicationID,
+		QueueID:            id.String(),
+		Channel:            "InApp",
+		Provider:           "InApp",
+		Recipient:          userID,
+		Subject:            broadcast.Subject,
+		Message:            broadcast.Message,
+		MessageContentType: broadcast.MessageContentType,
+		Status:             "sent",
+		BroadcastID:        &broadcastID,
+		InAppContent:       broadcast.InAppContent,
+		CreatedAt:          broadcast.CreatedAt,
+	}
+}
+
+// SubscribeInAppTopic adds users to a topic, users already subscribed are left as they are
+func SubscribeInAppTopic(applicationID uuid.UUID, topic string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	subscriptions := make([]InAppTopicSubscription, 0, len(userIDs))
+	for _, userID := range userIDs {
+		subscriptions = append(subscriptions, InAppTopicSubscription{ApplicationID: applicationID, Topic: topic, UserID: userID})
+	}
+	return GetMySQLDB().Clauses(clause.OnConflict{DoNothing: true}).Create(&subscriptions).Error
+}
+
+// UnsubscribeInAppTopic removes users from a topic and returns how many were subscribed
+func UnsubscribeInAppTopic(applicationID uuid.UUID, topic string, userIDs []string) (int64, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+	result := GetMySQLDB().
+		Where("application_id = ? AND topic = ? AND user_id IN ?", applicationID, topic, userIDs).
+		Delete(&InAppTopicSubscription{})
+	return result.RowsAffected, result.Error
+}
+
+// FilterInAppTopicSubscribers returns the users among userIDs that are subscribed to the topic
+func FilterInAppTopicSubscribers(applicationID string, topic string, userIDs []string) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	var subscribers []string
+	err := GetMySQLDB().Model(&InAppTopicSubscription{}).
+		Where("application_id = ? AND topic = ? AND user_id IN ?", applicationID, topic, userIDs).
+		Pluck("user_id", &subscribers).Error
+	return subscribers, err
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/logger"
+)
+
+func testDB(t *testing.T) {
+	t.Helper()
+	config := MySQLConfig{DSN: filepath.Join(t.TempDir(), "agni.db"), LogLevel: logger.Silent}
+	if err := InitMySQL("local", config, &Notification{}, &InAppBroadcast{}, &InAppTopicSubscription{}, &InAppBroadcastWatermark{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseMySQL() })
+}
+
+// createInbox stores in-app notifications of the user created at the given times
+func createInbox(t *testing.T, applicationID uuid.UUID, createdAt ...time.Time) {
+	t.Helper()
+	notifications := make([]Notification, 0, len(createdAt))
+	for _, at := range createdAt {
+		notifications = append(notifications, Notification{ID: uuid.New(), ApplicationID: applicationID, QueueID: uuid.NewString(), Channel: "InApp", Recipient: "user", Status: "sent", CreatedAt: at})
+	}
+	if err := GetMySQLDB().CreateInBatches(notifications, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInAppPagesDontSkipOrRepeatNotificationsCreatedAtTheSameTime(t *testing.T) {
+	testDB(t)
+	applicationID := uuid.New()
+	base := time.Now().UTC().Truncate(time.Second)
+	// a page boundary falls inside the notifications sharing a timestamp
+	same := base.Add(-time.Minute)
+	createInbox(t, applicationID, base, same, same, same, same, base.Add(-2*time.Minute), base.Add(-3*time.Minute))
+
+	var listed []Notification
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 4 {
+			t.Fatal("paging doesn't end")
+		}
+		page, err := GetInAppNotifications(applicationID.String(), "user", InAppNotificationFilter{}, InAppPageRequest{Limit: 2, Cursor: cursor, IncludeTotal: pages == 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pages == 0 && (page.Total == nil || *page.Total != 7) {
+			t.Fatalf("total is %v", page.Total)
+		}
+		listed = append(listed, page.Notifications...)
+		if page.NextCursor == "" {
+			if len(page.Notifications) != 1 {
+				t.Fatalf("the last page has %d notifications", len(page.Notifications))
+			}
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for i, n := range listed {
+		if seen[n.ID] {
+			t.Fatalf("%s is listed twice", n.ID)
+		}
+		seen[n.ID] = true
+		if i > 0 {
+			previous := listed[i-1]
+			if n.CreatedAt.After(previous.CreatedAt) || (n.CreatedAt.Equal(previous.CreatedAt) && n.ID.String() > previous.ID.String()) {
+				t.Fatalf("%s is listed after %s out of order", n.ID, previous.ID)
+			}
+		}
+	}
+	if len(listed) != 7 {
+		t.Fatalf("listed %d of 7 notifications", len(listed))
+	}
+}
+
+func TestInAppPageSizeIsClamped(t *testing.T) {
+	testDB(t)
+	applicationID := uuid.New()
+	createdAt := make([]time.Time, MaxInAppPageSize+5)
+	for i := range createdAt {
+		createdAt[i] = time.Now().Add(-time.Duration(i) * time.Second)
+	}
+	createInbox(t, applicationID, createdAt...)
+
+	for limit, want := range map[int]int{0: DefaultInAppPageSize, -1: DefaultInAppPageSize, 1000: MaxInAppPageSize, 10: 10} {
+		page, err := GetInAppNotifications(applicationID.String(), "user", InAppNotificationFilter{}, InAppPageRequest{Limit: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Notifications) != want || page.NextCursor == "" {
+			t.Errorf("limit %d returned %d notifications, want %d and a next page", limit, len(page.Notifications), want)
+		}
+	}
+}
+
+func TestInvalidInAppCursors(t *testing.T) {
+	testDB(t)
+	for _, cursor := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"t": "2024-01-01T00:00:00Z"}`)),
+	} {
+		_, err := GetInAppNotifications(uuid.NewString(), "user", InAppNotificationFilter{}, InAppPageRequest{Cursor: cursor})
+		if err != ErrInvalidCursor {
+			t.Errorf("cursor %q: got %v", cursor, err)
+		}
+	}
+}
+
+func TestReadStateUsesTheReadColumnOnceMigrated(t *testing.T) {
+	testDB(t)
+	if !notificationsHaveReadColumn {
+		t.Fatal("the read column wasn't detected")
+	}
+	applicationID := uuid.New()
+	createInbox(t, applicationID, time.Now(), time.Now())
+	page, _ := GetInAppNotifications(applicationID.String(), "user", InAppNotificationFilter{}, InAppPageRequest{})
+
+	if updated, err := MarkInAppNotificationRead(applicationID.String(), "user", page.Notifications[0].ID); err != nil || updated != 1 {
+		t.Fatalf("marked %d: %v", updated, err)
+	}
+	if count, _ := CountUnreadInAppNotifications(applicationID.String(), "user"); count != 1 {
+		t.Fatalf("%d unread notifications, want 1", count)
+	}
+	unread, _ := GetInAppNotifications(applicationID.String(), "user", InAppNotificationFilter{UnreadOnly: true}, InAppPageRequest{})
+	if len(unread.Notifications) != 1 || unread.Notifications[0].ID != page.Notifications[1].ID {
+		t.Fatalf("unexpected unread notifications %v", unread.Notifications)
+	}
+	var read Notification
+	GetMySQLDB().First(&read, "id = ?", page.Notifications[0].ID)
+	if !read.Read || read.ReadAt == nil || read.Status != "sent" {
+		t.Fatalf("the read state wasn't stored in the read columns: %+v", read)
+	}
+}
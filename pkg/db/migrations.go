@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SchemaMigration records a migration that was applied
+type SchemaMigration struct {
+	ID        string `gorm:"size:100;primaryKey"`
+	AppliedAt time.Time
+}
+
+// migration is a schema change AutoMigrate can't express, e.g. dropping an index or an index on
+// a column prefix. Migrations run once, in order, after AutoMigrate.
+type migration struct {
+	ID string
+	Up func(dbClient *gorm.DB) error
+}
+
+var migrations = []migration{
+	{
+		// device tokens were unique across applications, registering a token in a second
+		// application moved it out of the first one
+		ID: "device_tokens_unique_per_application",
+		Up: func(dbClient *gorm.DB) error {
+			if dbClient.Migrator().HasIndex(&DeviceToken{}, "idx_device_tokens_token") {
+				return dbClient.Migrator().DropIndex(&DeviceToken{}, "idx_device_tokens_token")
+			}
+			return nil
+		},
+	},
+	{
+		// the in-app inbox pages newest first by (created_at, id). Recipient holds webhook URLs
+		// longer than an index key can be, MySQL indexes a prefix of it and of the channel.
+		ID: "notifications_inbox_index",
+		Up: func(dbClient *gorm.DB) error {
+			if dbClient.Migrator().HasIndex(&Notification{}, "idx_notification_inbox") {
+				return nil
+			}
+			columns := "application_id, recipient, channel, created_at, id"
+			if dbClient.Dialector.Name() == "mysql" {
+				columns = "application_id, recipient(191), channel(20), created_at, id"
+			}
+			return dbClient.Exec("CREATE INDEX idx_notification_inbox ON notifications (" + columns + ")").Error
+		},
+	},
+}
+
+func runMigrations(dbClient *gorm.DB) error {
+	if err := dbClient.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		var applied int64
+		if err := dbClient.Model(&SchemaMigration{}).Where("id = ?", m.ID).Count(&applied).Error; err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+		if err := m.Up(dbClient); err != nil {
+			return fmt.Errorf("migration %s: %w", m.ID, err)
+		}
+		if err := dbClient.Create(&SchemaMigration{ID: m.ID, AppliedAt: time.Now()}).Error; err != nil {
+			return err
+		}
+		log.Printf("✅ Applied migration %s", m.ID)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +29,7 @@ func (app *Application) BeforeCreate(tx *gorm.DB) (err error) {
 
 type Notification struct {
 	// Change `type:uuid` to `type:varchar(36)`
+	// idx_notification_inbox serves the in-app inbox pages, it is created by a migration (see migrations.go)
 	ID                 uuid.UUID `gorm:"type:varchar(36);primaryKey"`
 	ApplicationID      uuid.UUID `gorm:"type:varchar(36);index"` // FK
 	QueueID            string    `gorm:"type:varchar(100);uniqueIndex"`
@@ -42,6 +46,14 @@ type Notification struct {
 	// In-app read state (only used for InApp channel)
 	Read   bool       `gorm:"default:false;index" json:"read"`
 	ReadAt *time.Time `json:"read_at,omitempty"`
+	// In-app inbox organisation, deleted notifications are kept but never listed
+	ArchivedAt   *time.Time `gorm:"index" json:"archived_at,omitempty"`
+	PinnedAt     *time.Time `json:"pinned_at,omitempty"`
+	SnoozedUntil *time.Time `json:"snoozed_until,omitempty"`
+	DeletedAt    *time.Time `gorm:"index" json:"-"`
+	// BroadcastID is the in-app broadcast this inbox row was created from
+	BroadcastID *uuid.UUID `gorm:"type:varchar(36);index" json:"broadcast_id,omitempty"`
+	InAppContent
 
 	CreatedAt   time.Time
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -49,6 +61,63 @@ type Notification struct {
 	ProcessedAt *time.Time
 }
 
+// InAppContent is the structured part of an in-app notification, next to its subject and message
+type InAppContent struct {
+	Category  string     `gorm:"size:100;index" json:"category,omitempty"`
+	Icon      string     `gorm:"type:text" json:"icon,omitempty"`
+	Image     string     `gorm:"type:text" json:"image,omitempty"`
+	URL       string     `gorm:"type:text" json:"url,omitempty"`
+	Actions   JSONText   `gorm:"type:text" json:"actions,omitempty"`
+	Data      JSONText   `gorm:"type:text" json:"data,omitempty"`
+	Priority  string     `gorm:"size:10" json:"priority,omitempty"`
+	ExpiresAt *time.Time `gorm:"index" json:"expires_at,omitempty"`
+}
+
+// JSONText is a JSON document kept in a text column and serialized as is
+type JSONText []byte
+
+// NewJSONText encodes v, nothing is stored for a nil or empty value
+func NewJSONText(v interface{}) (JSONText, error) {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" || string(data) == "[]" || string(data) == "{}" {
+		return nil, err
+	}
+	return JSONText(data), nil
+}
+
+func (j JSONText) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+func (j *JSONText) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case string:
+		*j = JSONText(v)
+	case []byte:
+		*j = append(JSONText(nil), v...)
+	default:
+		return fmt.Errorf("unsupported type %T for JSONText", value)
+	}
+	return nil
+}
+
+func (j JSONText) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSONText) UnmarshalJSON(data []byte) error {
+	*j = append(JSONText(nil), data...)
+	return nil
+}
+
 // BeforeCreate hook is correct and needs no changes
 func (n *Notification) BeforeCreate(tx *gorm.DB) (err error) {
 	if n.ID == uuid.Nil {
@@ -58,17 +127,192 @@ func (n *Notification) BeforeCreate(tx *gorm.DB) (err error) {
 }
 
 type WebPushSubscription struct {
-	ID        uuid.UUID `gorm:"type:varchar(36);primaryKey"`
-	UserID    string    `gorm:"index;size:36"`
-	Endpoint  string    `gorm:"size:500;uniqueIndex;not null"`
-	P256dh    string    `gorm:"size:255;not null"`
-	Auth      string    `gorm:"size:255;not null"`
-	Device    string    `gorm:"size:50"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);index:idx_webpush_app_user"`
+	UserID        string    `gorm:"size:255;index:idx_webpush_app_user"`
+	Endpoint      string    `gorm:"size:500;uniqueIndex;not null"`
+	P256dh        string    `gorm:"size:255;not null"`
+	Auth          string    `gorm:"size:255;not null"`
+	Device        string    `gorm:"size:50"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 func (wps *WebPushSubscription) BeforeCreate(tx *gorm.DB) (err error) {
 	wps.ID = uuid.New()
 	return
 }
+
+// DeviceToken is a native mobile push registration (FCM or APNs)
+type DeviceToken struct {
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);index:idx_device_app_user;uniqueIndex:idx_device_app_token,priority:1"`
+	UserID        string    `gorm:"size:255;index:idx_device_app_user"`
+	Token         string    `gorm:"size:500;uniqueIndex:idx_device_app_token,priority:2;not null"`
+	Platform      string    `gorm:"size:20"` // ios, android
+	Provider      string    `gorm:"size:20"` // fcm, apns
+	Device        string    `gorm:"size:50"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (dt *DeviceToken) BeforeCreate(tx *gorm.DB) (err error) {
+	if dt.ID == uuid.Nil {
+		dt.ID = uuid.New()
+	}
+	return
+}
+
+// TelegramChatLink maps an application user to the Telegram chat that linked it with /start <code>
+type TelegramChatLink struct {
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);uniqueIndex:idx_telegram_app_user"`
+	UserID        string    `gorm:"size:255;uniqueIndex:idx_telegram_app_user"`
+	ChatID        int64     `gorm:"index"`
+	Username      string    `gorm:"size:255"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (t *TelegramChatLink) BeforeCreate(tx *gorm.DB) (err error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return
+}
+
+// DKIMKey is the key an application's email is DKIM signed with when sent over SMTP
+type DKIMKey struct {
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);uniqueIndex"`
+	Domain        string    `gorm:"size:255;not null"`
+	Selector      string    `gorm:"size:63;not null"`
+	PrivateKey    string    `gorm:"type:text;not null"` // PEM encoded RSA or Ed25519 key
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (k *DKIMKey) BeforeCreate(tx *gorm.DB) (err error) {
+	if k.ID == uuid.Nil {
+		k.ID = uuid.New()
+	}
+	return
+}
+
+// EmailSuppression is an address that hard bounced or complained, email to it is not sent
+type EmailSuppression struct {
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);uniqueIndex:idx_suppression_app_email"`
+	Email         string    `gorm:"size:255;uniqueIndex:idx_suppression_app_email"`
+	Reason        string    `gorm:"size:20"` // bounce, complaint
+	Source        string    `gorm:"size:20"` // resend, webhook, dsn
+	Detail        string    `gorm:"type:text"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (s *EmailSuppression) BeforeCreate(tx *gorm.DB) (err error) {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return
+}
+
+// EmailEvent is an open or click recorded by the tracking endpoints
+type EmailEvent struct {
+	ID             uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	NotificationID uuid.UUID `gorm:"type:varchar(36);index"`
+	ApplicationID  uuid.UUID `gorm:"type:varchar(36);index:idx_email_event_app_template"`
+	TemplateID     string    `gorm:"size:255;index:idx_email_event_app_template"`
+	Type           string    `gorm:"size:10"` // open, click
+	URL            string    `gorm:"type:text"`
+	UserAgent      string    `gorm:"size:500"`
+	IP             string    `gorm:"size:45"`
+	CreatedAt      time.Time
+}
+
+func (e *EmailEvent) BeforeCreate(tx *gorm.DB) (err error) {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return
+}
+
+// EmailUnsubscribe is a recipient that opted out of one category of an application's email
+type EmailUnsubscribe struct {
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);uniqueIndex:idx_unsubscribe_app_email_category"`
+	Email         string    `gorm:"size:255;uniqueIndex:idx_unsubscribe_app_email_category"`
+	Category      string    `gorm:"size:100;uniqueIndex:idx_unsubscribe_app_email_category"`
+	CreatedAt     time.Time
+}
+
+func (u *EmailUnsubscribe) BeforeCreate(tx *gorm.DB) (err error) {
+	if u.ID == uuid.Nil {
+		u.ID = uuid.New()
+	}
+	return
+}
+
+// WebPushDelivery is the outcome of sending one web push notification to one subscription
+type WebPushDelivery struct {
+	ID             uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	NotificationID uuid.UUID `gorm:"type:varchar(36);index"`
+	SubscriptionID uuid.UUID `gorm:"type:varchar(36);index"`
+	Attempt        int
+	Status         string `gorm:"size:20"` // delivered, failed, rejected, expired
+	StatusCode     int
+	Error          string `gorm:"size:500"`
+	CreatedAt      time.Time
+}
+
+func (d *WebPushDelivery) BeforeCreate(tx *gorm.DB) (err error) {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return
+}
+
+// InAppBroadcast is an in-app notification for every user of an application, or for the users
+// subscribed to Topic. The inbox rows of the users are created when they are next seen.
+type InAppBroadcast struct {
+	ID                 uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID      uuid.UUID `gorm:"type:varchar(36);index:idx_inapp_broadcast_app_created"`
+	Topic              string    `gorm:"size:100"` // empty for all users
+	Subject            string    `gorm:"type:text"`
+	Message            string    `gorm:"type:text"`
+	MessageContentType string    `gorm:"size:50"`
+	InAppContent
+	CreatedAt time.Time `gorm:"index:idx_inapp_broadcast_app_created"`
+}
+
+func (b *InAppBroadcast) BeforeCreate(tx *gorm.DB) (err error) {
+	if b.ID == uuid.Nil {
+		b.ID = uuid.New()
+	}
+	return
+}
+
+// InAppBroadcastWatermark records that the inbox rows of every broadcast created before
+// MaterializedUntil exist for the user, later lookups only look at the newer broadcasts
+type InAppBroadcastWatermark struct {
+	ApplicationID     uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	UserID            string    `gorm:"size:255;primaryKey"`
+	MaterializedUntil time.Time
+}
+
+// InAppTopicSubscription puts a user in a segment that in-app broadcasts can target
+type InAppTopicSubscription struct {
+	ID            uuid.UUID `gorm:"type:varchar(36);primaryKey"`
+	ApplicationID uuid.UUID `gorm:"type:varchar(36);uniqueIndex:idx_inapp_topic_app_topic_user"`
+	Topic         string    `gorm:"size:100;uniqueIndex:idx_inapp_topic_app_topic_user"`
+	UserID        string    `gorm:"size:255;uniqueIndex:idx_inapp_topic_app_topic_user"`
+	CreatedAt     time.Time
+}
+
+func (s *InAppTopicSubscription) BeforeCreate(tx *gorm.DB) (err error) {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return
+}
@@ -15,15 +15,19 @@ var (
 )
 
 type MySQLConfig struct {
-	DSN      string
+	DSN      string // the SQLite file in local mode, agni.db when empty
 	LogLevel logger.LogLevel
 }
 
 func InitMySQL(mode string, config MySQLConfig, models ...interface{}) error {
 	var dialector gorm.Dialector
 	if mode == "local" {
-		log.Println("🔌 GORM Mode: LOCAL (Initializing SQLite connection: agni.db)")
-		dialector = sqlite.Open("agni.db")
+		path := config.DSN
+		if path == "" {
+			path = "agni.db"
+		}
+		log.Printf("🔌 GORM Mode: LOCAL (Initializing SQLite connection: %s)", path)
+		dialector = sqlite.Open(path)
 	} else {
 		log.Printf("🔌 GORM Mode: PROD (Initializing MySQL connection: %s)", config.DSN)
 		dialector = mysql.Open(config.DSN)
@@ -49,8 +53,12 @@ func InitMySQL(mode string, config MySQLConfig, models ...interface{}) error {
 		if err := MySQLDB.AutoMigrate(models...); err != nil {
 			return fmt.Errorf("failed to run auto migrations: %w", err)
 		}
+		if err := runMigrations(MySQLDB); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
 		log.Printf("✅ Auto migrations completed successfully")
 	}
+	notificationsHaveReadColumn = MySQLDB.Migrator().HasColumn(&Notification{}, "read")
 	return nil
 }
 
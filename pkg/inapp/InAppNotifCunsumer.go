@@ -5,20 +5,88 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
-const ProcessedSet = "inapp:processed_ids"
+// ProcessedKeyPrefix marks notifications already published, the keys expire after ProcessedTTL
+const ProcessedKeyPrefix = "inapp:processed:"
+const ProcessedTTL = 24 * time.Hour
 const BroadcastChannelPrefix = "inapp:broadcast:" // New constant
 
+// AppChannelPrefix carries the broadcasts to all users of an application, or to a topic's subscribers
+const AppChannelPrefix = "inapp:app:"
+
+// AppChannel is the pub/sub channel of the application's broadcasts
+func AppChannel(applicationID string) string {
+	return AppChannelPrefix + applicationID
+}
+
+// UserStreamPrefix keeps the recent notifications of each user so a reconnecting client can
+// replay what it missed, the entry ids are the event ids sent to the clients
+const UserStreamPrefix = "inapp:user:"
+
+// ReplayMaxLen and ReplayTTL bound the per-user streams
+const ReplayMaxLen = 100
+const ReplayTTL = 7 * 24 * time.Hour
+
+// ReplayedKeyPrefix maps an entry of the notification stream to the replay entry written for it,
+// the keys expire after ProcessedTTL
+const ReplayedKeyPrefix = "inapp:replayed:"
+
+// storeForReplay adds the notification to the user's replay stream once per entry of the
+// notification stream. An entry retried after a failed publish gets the event id of the first
+// attempt, so the replay stream doesn't hold it twice.
+var storeForReplay = redis.NewScript(`
+local id = redis.call('GET', KEYS[2])
+if id then
+	return id
+end
+id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[1], '*', 'payload', ARGV[2])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('SET', KEYS[2], id, 'EX', ARGV[4])
+return id`)
+
+// UserStream is the replay stream of a user, key is app_id:user_id
+func UserStream(key string) string {
+	return UserStreamPrefix + key
+}
+
+// ClaimMinIdle is how long an entry stays pending before another node takes it over,
+// e.g. when the node that read it crashed before acknowledging
+const ClaimMinIdle = time.Minute
+
+// DefaultConsumerName is unique per process, consumers of a group must not share a name
+// or they read each other's pending entries
+func DefaultConsumerName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "inapp"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// StartConsumer reads the stream as one consumer of the group and publishes every entry
+// to the broadcast channel of its recipient. Each entry is read by one node of the group,
+// pub/sub then routes it to the nodes holding the recipient's sockets.
+// It returns once ctx is cancelled, after the batch being handled is published and acknowledged.
 func StartConsumer(ctx context.Context, rdb *redis.Client, stream, group, consumer string) {
+	// the in-flight batch isn't cut short by the cancellation
+	handleCtx := context.WithoutCancel(ctx)
+	lastClaim := time.Now()
 	for {
 		if ctx.Err() != nil {
 			log.Printf("inapp consumer: stopping due to context cancellation: %v", ctx.Err())
 			return
 		}
+
+		if time.Since(lastClaim) >= ClaimMinIdle {
+			claimPending(ctx, handleCtx, rdb, stream, group, consumer)
+			lastClaim = time.Now()
+		}
+
 		entries, err := rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
 			Group:    group,
 			Consumer: consumer,
@@ -40,41 +108,114 @@ func StartConsumer(ctx context.Context, rdb *redis.Client, stream, group, consum
 		}
 		for _, st := range entries {
 			for _, msg := range st.Messages {
-				raw, _ := msg.Values["payload"].(string)
-				var payload map[string]interface{}
-				if err := json.Unmarshal([]byte(raw), &payload); err != nil {
-					log.Printf("inapp: invalid payload: %v", err)
-					_ = rdb.XAck(ctx, stream, group, msg.ID)
-					_, _ = rdb.XDel(ctx, stream, msg.ID).Result()
-					continue
-				}
-
-				id, _ := payload["id"].(string)
-				recipient, _ := payload["recipient"].(string)
-				applicationID, _ := payload["application_id"].(string)
-
-				// Idempotency check
-				already, _ := rdb.SIsMember(ctx, ProcessedSet, id).Result()
-				if already {
-					_ = rdb.XAck(ctx, stream, group, msg.ID)
-					_, _ = rdb.XDel(ctx, stream, msg.ID).Result()
-					continue
-				}
-
-				// Format: inapp:broadcast:app_id:user_id
-				broadcastChannel := fmt.Sprintf("%s%s:%s", BroadcastChannelPrefix, applicationID, recipient)
-				if err := rdb.Publish(ctx, broadcastChannel, raw).Err(); err != nil {
-					log.Printf("failed to publish broadcast for %s: %v", recipient, err)
-					continue
-				}
-
-				log.Printf("✓ Published notification %s for app %s user %s", id, applicationID, recipient)
-
-				// Mark processed and ack
-				_, _ = rdb.SAdd(ctx, ProcessedSet, id).Result()
-				_ = rdb.XAck(ctx, stream, group, msg.ID)
-				_, _ = rdb.XDel(ctx, stream, msg.ID).Result()
+				handleEntry(handleCtx, rdb, stream, group, msg)
 			}
 		}
 	}
 }
+
+// claimPending takes over the entries other consumers left unacknowledged for too long
+func claimPending(ctx, handleCtx context.Context, rdb *redis.Client, stream, group, consumer string) {
+	start := "0-0"
+	for {
+		messages, next, err := rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  ClaimMinIdle,
+			Start:    start,
+			Count:    10,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("inapp consumer claim err: %v", err)
+			}
+			return
+		}
+		for _, msg := range messages {
+			log.Printf("inapp consumer: claimed pending entry %s", msg.ID)
+			handleEntry(handleCtx, rdb, stream, group, msg)
+		}
+		if next == "0-0" || len(messages) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// handleEntry publishes one stream entry. Entries stay in the stream, which is capped on XADD,
+// and are only acknowledged once published so a failed publish is retried by claimPending.
+func handleEntry(ctx context.Context, rdb *redis.Client, stream, group string, msg redis.XMessage) {
+	raw, _ := msg.Values["payload"].(string)
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		log.Printf("inapp: invalid payload: %v", err)
+		_ = rdb.XAck(ctx, stream, group, msg.ID)
+		return
+	}
+
+	id, _ := payload["id"].(string)
+	recipient, _ := payload["recipient"].(string)
+	applicationID, _ := payload["application_id"].(string)
+
+	// Idempotency check
+	processedKey := ProcessedKeyPrefix + id
+	if already, _ := rdb.Exists(ctx, processedKey).Result(); already > 0 {
+		_ = rdb.XAck(ctx, stream, group, msg.ID)
+		return
+	}
+
+	if expired(payload, time.Now()) {
+		log.Printf("inapp: notification %s expired, not delivered", id)
+		_ = rdb.XAck(ctx, stream, group, msg.ID)
+		return
+	}
+
+	if isBroadcast(payload) {
+		// one message per application, every node delivers it to its connected users
+		if err := rdb.Publish(ctx, AppChannel(applicationID), raw).Err(); err != nil {
+			log.Printf("failed to publish broadcast %s for app %s: %v", id, applicationID, err)
+			return
+		}
+		log.Printf("✓ Published broadcast %s for app %s", id, applicationID)
+		_ = rdb.Set(ctx, processedKey, 1, ProcessedTTL).Err()
+		_ = rdb.XAck(ctx, stream, group, msg.ID)
+		return
+	}
+
+	// Keep it for replay first, the entry id becomes the event id the client resumes from
+	key := fmt.Sprintf("%s:%s", applicationID, recipient)
+	replayedKey := ReplayedKeyPrefix + stream + ":" + msg.ID
+	eventID, err := storeForReplay.Run(ctx, rdb, []string{UserStream(key), replayedKey},
+		ReplayMaxLen, raw, int(ReplayTTL.Seconds()), int(ProcessedTTL.Seconds())).Text()
+	if err != nil {
+		log.Printf("failed to store notification %s for replay: %v", id, err)
+		return
+	}
+
+	event := notificationEvent(payload, eventID)
+
+	// Format: inapp:broadcast:app_id:user_id
+	broadcastChannel := BroadcastChannel(key)
+	if err := rdb.Publish(ctx, broadcastChannel, event).Err(); err != nil {
+		log.Printf("failed to publish broadcast for %s: %v", recipient, err)
+		return
+	}
+
+	log.Printf("✓ Published notification %s for app %s user %s", id, applicationID, recipient)
+
+	// Mark processed and ack
+	_ = rdb.Set(ctx, processedKey, 1, ProcessedTTL).Err()
+	_ = rdb.XAck(ctx, stream, group, msg.ID)
+}
+
+// expired reports whether the in_app.expires_at of a payload passed
+func expired(payload map[string]interface{}, now time.Time) bool {
+	options, _ := payload["in_app"].(map[string]interface{})
+	raw, _ := options["expires_at"].(string)
+	if raw == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339Nano, raw)
+	return err == nil && !expiresAt.After(now)
+}
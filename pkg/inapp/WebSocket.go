@@ -3,8 +3,8 @@ package inapp
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,163 +13,279 @@ import (
 )
 
 type Client struct {
-	conn   *websocket.Conn
+	hub  *Hub
+	conn *websocket.Conn // nil for server-sent events clients
+	// Write is never closed, senders select on quit so they can't block on a client that is gone
 	Write  chan []byte
-	Read   chan []byte
 	userID string
+	// quit is closed when the client is unregistered, the pump flushes Write, sends the close frame and returns
+	quit chan struct{}
+	// done is closed when the pump writing to the connection returned, the connection is released once the handler returns
+	done chan struct{}
+	// closeFrame is sent when quit is closed, an empty close frame when nil
+	closeFrame []byte
+
+	// mu guards closed and the live events held back during replay, it is never held while blocking
+	mu        sync.Mutex
+	closed    bool
+	replaying bool
+	backlog   []liveEvent
 }
 
+// Hub holds the WebSocket clients connected to this node. Every node subscribes to the
+// broadcast channels of the users connected to it only, and to the channels of their
+// applications, over one shared Redis connection, so a notification published by any consumer
+// reaches whichever nodes hold the user's sockets.
 type Hub struct {
-	mu          sync.RWMutex
-	clients     map[string]map[*Client]bool
-	rdb         *redis.Client
-	ctx         context.Context
-	subscribers map[string]*redis.PubSub
-	cancelFuncs map[string]context.CancelFunc // Store cancel functions
-	subMutex    sync.RWMutex
+	mu      sync.RWMutex
+	clients map[string]map[*Client]bool
+	apps    map[string]int // clients per application, for the application broadcast channels
+	rdb     *redis.Client
+	ctx     context.Context
+	pubsub  *redis.PubSub
+	node    string // name the presence of the connected users is published under, see TrackPresence
+	// presenceMu orders the presence writes to Redis, which happen without holding mu
+	presenceMu sync.Mutex
+	// subscriptionsMu orders the Subscribe and Unsubscribe calls, which happen without holding mu
+	subscriptionsMu sync.Mutex
+	subscribed      map[string]bool // the channels pubsub is subscribed to, guarded by subscriptionsMu
+	closed          chan struct{}
+	// draining turns away new clients once Drain started, they reconnect to another node
+	draining bool
+	// broadcasts to applications wait here for deliverBroadcasts, see queueBroadcast
+	broadcasts chan appBroadcast
 }
 
+// closeReconnect is the close frame clients get when the node shuts down, 1012 (service restart)
+// tells them to reconnect, the load balancer sends them to another node
+var closeReconnect = websocket.FormatCloseMessage(websocket.CloseServiceRestart, "reconnect")
+
 var DefaultHub *Hub
 
+// NewHub creates a hub and starts delivering the broadcasts of its subscribed users
+func NewHub(ctx context.Context, rdb *redis.Client) *Hub {
+	h := &Hub{
+		clients:    make(map[string]map[*Client]bool),
+		apps:       make(map[string]int),
+		rdb:        rdb,
+		ctx:        ctx,
+		pubsub:     rdb.Subscribe(ctx),
+		subscribed: make(map[string]bool),
+		closed:     make(chan struct{}),
+		broadcasts: make(chan appBroadcast, maxPendingBroadcasts),
+	}
+	go h.listen()
+	go h.deliverBroadcasts()
+	return h
+}
+
 // InitializeHub sets up the hub with Redis client
 func InitializeHub(ctx context.Context, rdb *redis.Client) {
-	DefaultHub = &Hub{
-		clients:     make(map[string]map[*Client]bool),
-		rdb:         rdb,
-		ctx:         ctx,
-		subscribers: make(map[string]*redis.PubSub),
-		cancelFuncs: make(map[string]context.CancelFunc),
+	DefaultHub = NewHub(ctx, rdb)
+}
+
+// BroadcastChannel is the pub/sub channel of a user, key is app_id:user_id
+func BroadcastChannel(key string) string {
+	return BroadcastChannelPrefix + key
+}
+
+// Register adds the connection of a user. With a since cursor (an event id or a unix time in
+// milliseconds) the notifications the user missed are replayed before live delivery starts.
+func (h *Hub) Register(userID string, conn *websocket.Conn, since string) *Client {
+	c := h.add(userID, conn, since != "")
+	go c.writePump()
+
+	// subscribed before reading the replay stream, so nothing falls in between
+	if since != "" {
+		h.replay(c, since)
 	}
+	return c
 }
 
-func (h *Hub) Register(userID string, conn *websocket.Conn) *Client {
+// add registers a client of the user and subscribes to the user's broadcasts, the caller
+// starts the pump that writes to the connection
+func (h *Hub) add(userID string, conn *websocket.Conn, replaying bool) *Client {
 	c := &Client{
-		conn:   conn,
-		Write:  make(chan []byte, 32),
-		Read:   make(chan []byte, 32),
-		userID: userID,
+		hub:       h,
+		conn:      conn,
+		Write:     make(chan []byte, 32),
+		userID:    userID,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		replaying: replaying,
 	}
 
 	h.mu.Lock()
-	isFirstClient := false
+	if h.draining {
+		h.mu.Unlock()
+		// never registered, the pump closes the connection right away
+		c.closeWith(closeReconnect)
+		return c
+	}
 	if _, ok := h.clients[userID]; !ok {
 		h.clients[userID] = make(map[*Client]bool)
-		isFirstClient = true
 	}
 	h.clients[userID][c] = true
+	total := len(h.clients[userID])
+	applicationID, _, _ := strings.Cut(userID, ":")
+	h.apps[applicationID]++
+	firstOfApp := h.apps[applicationID] == 1
 	h.mu.Unlock()
-
-	// If this is the first client for this user, subscribe to their channel
-	if isFirstClient {
-		h.subscribeToUser(userID)
+	if total == 1 || firstOfApp {
+		h.subscriptionsChanged(userID)
 	}
+	h.presenceChanged(userID, total == 1)
 
-	go c.writePump()
-	log.Printf("✅ Client registered: %s (total clients for user: %d)", userID, len(h.clients[userID]))
+	log.Printf("✅ Client registered: %s (total clients for user: %d)", userID, total)
 	return c
 }
 
-// subscribeToUser creates a Redis subscription for this specific user
-func (h *Hub) subscribeToUser(userID string) {
-	h.subMutex.Lock()
-	defer h.subMutex.Unlock()
+// subscriptionsChanged subscribes to or unsubscribes from the channels of the user and of
+// their application, called after h.mu is released when the first client of either came or the
+// last one left. Like presenceChanged, the counts are read while holding subscriptionsMu, so
+// the last call leaves the subscriptions matching the connected clients.
+func (h *Hub) subscriptionsChanged(userID string) {
+	h.subscriptionsMu.Lock()
+	defer h.subscriptionsMu.Unlock()
 
-	// Check if already subscribed
-	if _, exists := h.subscribers[userID]; exists {
-		return
+	applicationID, _, _ := strings.Cut(userID, ":")
+	h.mu.RLock()
+	wanted := map[string]bool{
+		BroadcastChannel(userID):  len(h.clients[userID]) > 0,
+		AppChannel(applicationID): h.apps[applicationID] > 0,
 	}
+	h.mu.RUnlock()
 
-	// Subscribe to this user's specific channel: inapp:broadcast:app_id:user_id
-	channel := fmt.Sprintf("%s%s", BroadcastChannelPrefix, userID)
-	pubsub := h.rdb.Subscribe(h.ctx, channel)
-
-	// Create a cancellable context for this subscription
-	ctx, cancel := context.WithCancel(h.ctx)
-
-	h.subscribers[userID] = pubsub
-	h.cancelFuncs[userID] = cancel
-
-	log.Printf("📡 Subscribed to: %s", channel)
-
-	// Start listener with cancellable context
-	go h.listenToChannel(ctx, userID, pubsub)
-}
-
-func (h *Hub) listenToChannel(ctx context.Context, userID string, pubsub *redis.PubSub) {
-	ch := pubsub.Channel()
-
-	for {
-		select {
-		case <-ctx.Done():
-			// Context cancelled - stop this goroutine
-			log.Printf("🛑 Stopping listener for: %s", userID)
-			return
-
-		case msg, ok := <-ch:
-			if !ok {
-				// Channel closed
-				log.Printf("🔌 Channel closed for: %s", userID)
-				return
+	for channel, want := range wanted {
+		switch {
+		case want && !h.subscribed[channel]:
+			if err := h.pubsub.Subscribe(h.ctx, channel); err != nil {
+				log.Printf("❌ Failed to subscribe to %s: %v", channel, err)
+				continue
 			}
-
-			var payload map[string]interface{}
-			if err := json.Unmarshal([]byte(msg.Payload), &payload); err != nil {
-				log.Printf("❌ Invalid payload: %v", err)
+			h.subscribed[channel] = true
+			log.Printf("📡 Subscribed to: %s", channel)
+		case !want && h.subscribed[channel]:
+			if err := h.pubsub.Unsubscribe(h.ctx, channel); err != nil {
+				log.Printf("❌ Failed to unsubscribe from %s: %v", channel, err)
 				continue
 			}
-
-			h.BroadcastToUser(userID, payload)
+			delete(h.subscribed, channel)
+			log.Printf("🔕 Unsubscribed from: %s", channel)
 		}
 	}
 }
 
-// unsubscribeFromUser removes Redis subscription when no clients remain
-func (h *Hub) unsubscribeFromUser(userID string) {
-	h.subMutex.Lock()
-	defer h.subMutex.Unlock()
+// listen delivers the broadcasts of all users and applications subscribed on this node
+func (h *Hub) listen() {
+	for msg := range h.pubsub.Channel() {
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(msg.Payload), &payload); err != nil {
+			log.Printf("❌ Invalid payload: %v", err)
+			continue
+		}
+
+		if applicationID, ok := strings.CutPrefix(msg.Channel, AppChannelPrefix); ok {
+			// touches the database, kept off the subscription loop
+			h.queueBroadcast(applicationID, payload)
+			continue
+		}
+		userID := strings.TrimPrefix(msg.Channel, BroadcastChannelPrefix)
+		h.BroadcastToUser(userID, payload)
+	}
+	log.Println("⚠️  Broadcast subscriber stopped")
+}
 
-	if pubsub, exists := h.subscribers[userID]; exists {
-		pubsub.Close()
-		delete(h.subscribers, userID)
-		log.Printf("🔕 Unsubscribed from Redis channel for: %s", userID)
+// Drain closes the connections of all clients with a reconnect close frame, server-sent events
+// streams end and EventSource reconnects by itself. It waits until the connections are closed
+// or ctx is done, clients connecting in the meantime are turned away.
+func (h *Hub) Drain(ctx context.Context) error {
+	h.mu.Lock()
+	h.draining = true
+	var clients []*Client
+	for _, userClients := range h.clients {
+		for c := range userClients {
+			clients = append(clients, c)
+		}
 	}
+	h.mu.Unlock()
 
-	if cancel, exists := h.cancelFuncs[userID]; exists {
-		cancel()
-		delete(h.cancelFuncs, userID)
+	log.Printf("🚪 Draining %d clients", len(clients))
+	for _, c := range clients {
+		c.closeWith(closeReconnect)
+		h.Unregister(c)
+	}
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+	return nil
 }
 
+// Close stops the broadcast subscription and the presence of this node
+func (h *Hub) Close() error {
+	close(h.closed)
+	h.clearPresence()
+	return h.pubsub.Close()
+}
+
+// writeWait bounds every write, a client that stops reading can't hold the pump forever
+const writeWait = 10 * time.Second
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		close(c.done)
 	}()
 
 	for {
 		select {
-		case msg, ok := <-c.Write:
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		case msg := <-c.Write:
+			if err := c.write(websocket.TextMessage, msg, writeWait); err != nil {
 				log.Printf("❌ Write error: %v", err)
 				return
 			}
+		case <-c.quit:
+			for msg, ok := c.pending(); ok; msg, ok = c.pending() {
+				if err := c.write(websocket.TextMessage, msg, writeWait); err != nil {
+					return
+				}
+			}
+			c.write(websocket.CloseMessage, c.closeFrame, time.Second)
+			return
 		case <-ticker.C:
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.write(websocket.PingMessage, nil, writeWait); err != nil {
 				return
 			}
 		}
 	}
 }
 
+func (c *Client) write(messageType int, data []byte, timeout time.Duration) error {
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// pending returns a message left in Write once the client quit
+func (c *Client) pending() ([]byte, bool) {
+	select {
+	case msg := <-c.Write:
+		return msg, true
+	default:
+		return nil, false
+	}
+}
+
+// ReadPump answers the requests of the client (see Request) until the connection closes
 func (c *Client) ReadPump() {
 	const (
 		pongWait       = 60 * time.Second
-		maxMessageSize = 512
+		maxMessageSize = 4096
 	)
 
 	c.conn.SetReadLimit(maxMessageSize)
@@ -182,71 +298,110 @@ func (c *Client) ReadPump() {
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			DefaultHub.Unregister(c)
-			c.conn.Close()
+			c.hub.Unregister(c)
+			<-c.done
 			return
 		}
+		// any message shows the client is alive
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
 
-		log.Printf("📥 Message from client: %s", string(message))
-
-		select {
-		case c.Read <- message:
-		default:
-			DefaultHub.Unregister(c)
-			c.conn.Close()
+		// like live events, a client that doesn't read its responses is dropped rather than waited for
+		if !c.respond(c.handleRequest(message)) {
+			log.Printf("⚠️  Dropping client %s, it doesn't read its responses", c.userID)
+			c.hub.Unregister(c)
+			<-c.done
 			return
 		}
 	}
 }
 
+// respond queues the response to a request without blocking, it reports false when the buffer is full
+func (c *Client) respond(data []byte) bool {
+	select {
+	case c.Write <- data:
+		return true
+	default:
+		return false
+	}
+}
+
 func (h *Hub) Unregister(c *Client) {
 	h.mu.Lock()
-	clientsRemaining := 0
-	if clients, ok := h.clients[c.userID]; ok {
-		delete(clients, c)
-		clientsRemaining = len(clients)
-		if clientsRemaining == 0 {
-			delete(h.clients, c.userID)
-		}
+	clients, ok := h.clients[c.userID]
+	if !ok || !clients[c] {
+		// already unregistered by the other pump
+		h.mu.Unlock()
+		return
 	}
-	h.mu.Unlock()
-
-	close(c.Write)
-	c.conn.Close()
-
-	// If no more clients for this user, unsubscribe from Redis
+	delete(clients, c)
+	clientsRemaining := len(clients)
 	if clientsRemaining == 0 {
-		h.unsubscribeFromUser(c.userID)
+		delete(h.clients, c.userID)
+	}
+	applicationID, _, _ := strings.Cut(c.userID, ":")
+	h.apps[applicationID]--
+	lastOfApp := h.apps[applicationID] == 0
+	if lastOfApp {
+		delete(h.apps, applicationID)
+	}
+	// writePump sends the close frame and closes the connection
+	c.closeWith(nil)
+	h.mu.Unlock()
+	if clientsRemaining == 0 || lastOfApp {
+		h.subscriptionsChanged(c.userID)
 	}
+	h.presenceChanged(c.userID, clientsRemaining == 0)
 
 	log.Printf("❌ Client unregistered: %s (remaining: %d)", c.userID, clientsRemaining)
 }
 
-func (h *Hub) BroadcastToUser(userID string, payload interface{}) {
-	h.mu.RLock()
-	clients := h.clients[userID]
-	h.mu.RUnlock()
-
-	if clients == nil {
-		log.Printf("⚠️  No clients found for: %s", userID)
-		return
+// closeWith stops the pump, which sends frame as the close frame. Only the first call counts,
+// the pump reads the frame once quit is closed.
+func (c *Client) closeWith(frame []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.closeFrame = frame
+		close(c.quit)
 	}
+}
 
+func (h *Hub) BroadcastToUser(userID string, payload interface{}) {
 	b, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("❌ Marshal error: %v", err)
 		return
 	}
+	var eventID string
+	if fields, ok := payload.(map[string]interface{}); ok {
+		eventID, _ = fields["event_id"].(string)
+	}
 
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients[userID]))
+	for c := range h.clients[userID] {
+		clients = append(clients, c)
+	}
+	var slow []*Client
 	sent := 0
-	for c := range clients {
-		select {
-		case c.Write <- b:
+	for _, c := range clients {
+		if c.send(liveEvent{id: eventID, data: b}) {
 			sent++
-		default:
-			h.Unregister(c)
+		} else {
+			slow = append(slow, c)
 		}
 	}
+	h.mu.RUnlock()
+
+	if len(clients) == 0 {
+		log.Printf("⚠️  No clients found for: %s", userID)
+		return
+	}
+	// clients that can't keep up are dropped, they catch up from the API on reconnect
+	for _, c := range slow {
+		h.Unregister(c)
+	}
 
 	log.Printf("📨 Sent to %d/%d clients for user: %s", sent, len(clients), userID)
 }
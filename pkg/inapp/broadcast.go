@@ -0,0 +1,115 @@
+package inapp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+)
+
+// isBroadcast reports whether a stream entry goes to many users of the application
+func isBroadcast(payload map[string]interface{}) bool {
+	options, _ := payload["in_app"].(map[string]interface{})
+	broadcast, _ := options["broadcast"].(bool)
+	topic, _ := options["topic"].(string)
+	return broadcast || topic != ""
+}
+
+// maxPendingBroadcasts bounds the broadcasts waiting for delivery on a node, more are dropped:
+// the users find them in their inbox
+const maxPendingBroadcasts = 100
+
+// appBroadcast is a broadcast received on the channel of an application
+type appBroadcast struct {
+	applicationID string
+	payload       map[string]interface{}
+}
+
+// queueBroadcast hands a broadcast to deliverBroadcasts without blocking the subscription loop
+func (h *Hub) queueBroadcast(applicationID string, payload map[string]interface{}) {
+	select {
+	case h.broadcasts <- appBroadcast{applicationID: applicationID, payload: payload}:
+	default:
+		log.Printf("⚠️  Dropped broadcast %v to app %s, %d broadcasts are pending", payload["id"], applicationID, maxPendingBroadcasts)
+	}
+}
+
+// deliverBroadcasts delivers the queued broadcasts one at a time until the hub is closed
+func (h *Hub) deliverBroadcasts() {
+	for {
+		select {
+		case b := <-h.broadcasts:
+			h.deliverBroadcast(b.applicationID, b.payload)
+		case <-h.closed:
+			return
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// deliverBroadcast sends a broadcast to the users of the application connected to this node.
+// Their inbox rows are created right away, in one insert, so they can mark it read, the other
+// users get theirs the next time they list or count their notifications. Clients count the
+// notification as unread themselves, the unread count isn't sent again.
+func (h *Hub) deliverBroadcast(applicationID string, payload map[string]interface{}) {
+	broadcastID, err := uuid.Parse(fmt.Sprint(payload["id"]))
+	if err != nil {
+		log.Printf("❌ Invalid broadcast id: %v", payload["id"])
+		return
+	}
+	if expired(payload, time.Now()) {
+		return
+	}
+	options, _ := payload["in_app"].(map[string]interface{})
+	topic, _ := options["topic"].(string)
+	hasDB := db.GetMySQLDB() != nil
+
+	users := h.connectedUsers(applicationID)
+	if topic != "" && len(users) > 0 {
+		if !hasDB {
+			log.Printf("⚠️  Can't resolve the subscribers of topic %s without a database", topic)
+			return
+		}
+		users, err = db.FilterInAppTopicSubscribers(applicationID, topic, users)
+		if err != nil {
+			log.Printf("❌ Failed to resolve the subscribers of topic %s: %v", topic, err)
+			return
+		}
+	}
+	if hasDB {
+		if err := db.MaterializeInAppBroadcast(broadcastID, users); err != nil {
+			log.Printf("❌ Failed to create the inbox rows of broadcast %s: %v", broadcastID, err)
+		}
+	}
+
+	for _, userID := range users {
+		event := make(map[string]interface{}, len(payload)+2)
+		for field, value := range payload {
+			event[field] = value
+		}
+		event["id"] = db.BroadcastNotificationID(broadcastID, userID).String()
+		event["recipient"] = userID
+		event["broadcast_id"] = broadcastID.String()
+		event["type"] = MessageNotification
+		h.BroadcastToUser(fmt.Sprintf("%s:%s", applicationID, userID), event)
+	}
+	log.Printf("📢 Delivered broadcast %s to %d users of app %s", broadcastID, len(users), applicationID)
+}
+
+// connectedUsers lists the user ids of the application with a client on this node
+func (h *Hub) connectedUsers(applicationID string) []string {
+	prefix := applicationID + ":"
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	users := make([]string, 0, len(h.clients))
+	for key := range h.clients {
+		if userID, ok := strings.CutPrefix(key, prefix); ok {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
@@ -0,0 +1,77 @@
+package inapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/redis/go-redis/v9"
+)
+
+// State events keep the devices of a user in sync, they are broadcast live and not replayed
+const (
+	EventRead        = "read"
+	EventUnreadCount = MessageUnreadCount
+	EventUpdated     = "updated"
+)
+
+// Inbox changes an updated event carries as its action
+const (
+	InboxArchived   = "archived"
+	InboxUnarchived = "unarchived"
+	InboxPinned     = "pinned"
+	InboxUnpinned   = "unpinned"
+	InboxSnoozed    = "snoozed"
+	InboxUnsnoozed  = "unsnoozed"
+	InboxDeleted    = "deleted"
+)
+
+// PublishInboxChange tells every connected device of the user that a notification was archived,
+// pinned, snoozed or deleted, followed by the new unread count since the inbox changed
+func PublishInboxChange(ctx context.Context, rdb *redis.Client, applicationID, userID, notificationID, action string, snoozedUntil *time.Time) {
+	data := map[string]interface{}{"notification_id": notificationID, "action": action}
+	if snoozedUntil != nil {
+		data["snoozed_until"] = snoozedUntil
+	}
+	publishEvent(ctx, rdb, applicationID, userID, Response{Type: EventUpdated, Data: data})
+	PublishUnreadCount(ctx, rdb, applicationID, userID)
+}
+
+// PublishReadState tells every connected device of the user which notifications were read,
+// no ids means all of them, followed by the new unread count
+func PublishReadState(ctx context.Context, rdb *redis.Client, applicationID, userID string, ids []string) {
+	data := map[string]interface{}{"notification_ids": ids}
+	if len(ids) == 0 {
+		data = map[string]interface{}{"all": true}
+	}
+	publishEvent(ctx, rdb, applicationID, userID, Response{Type: EventRead, Data: data})
+	PublishUnreadCount(ctx, rdb, applicationID, userID)
+}
+
+// PublishUnreadCount sends the user's current unread count to every connected device
+func PublishUnreadCount(ctx context.Context, rdb *redis.Client, applicationID, userID string) {
+	if db.GetMySQLDB() == nil {
+		return
+	}
+	count, err := db.CountUnreadInAppNotifications(applicationID, userID)
+	if err != nil {
+		log.Printf("failed to count unread notifications for %s:%s: %v", applicationID, userID, err)
+		return
+	}
+	publishEvent(ctx, rdb, applicationID, userID, Response{
+		Type: EventUnreadCount,
+		Data: map[string]interface{}{"unread_count": count},
+	})
+}
+
+func publishEvent(ctx context.Context, rdb *redis.Client, applicationID, userID string, event Response) {
+	if rdb == nil {
+		return
+	}
+	channel := BroadcastChannel(fmt.Sprintf("%s:%s", applicationID, userID))
+	if err := rdb.Publish(ctx, channel, encodeResponse(event)).Err(); err != nil {
+		log.Printf("failed to publish %s event for %s: %v", event.Type, channel, err)
+	}
+}
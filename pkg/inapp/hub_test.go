@@ -0,0 +1,782 @@
+package inapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/inapp/presence"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm/logger"
+)
+
+// testRedis connects to the Redis at REDIS_ADDR, or to an in-process miniredis without one
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = miniredis.RunT(t).Addr()
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		t.Fatalf("redis not available at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// startNode runs one in-app service node: a hub, a consumer of the group and the WebSocket endpoint
+func startNode(t *testing.T, ctx context.Context, rdb *redis.Client, stream, group, consumer string) string {
+	t.Helper()
+	hub := NewHub(ctx, rdb)
+	hub.TrackPresence(stream + ":" + consumer)
+	t.Cleanup(func() { hub.Close() })
+	go StartConsumer(ctx, rdb, stream, group, consumer)
+	return serve(t, hub)
+}
+
+// serve runs the WebSocket endpoint of a hub
+func serve(t *testing.T, hub *Hub) string {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(conn *websocket.Conn) {
+		client := hub.Register(conn.Query("key"), conn, conn.Query("since"))
+		client.ReadPump()
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return ln.Addr().String()
+}
+
+func connect(t *testing.T, addr, key string) *fastws.Conn {
+	return connectSince(t, addr, key, "")
+}
+
+func connectSince(t *testing.T, addr, key, since string) *fastws.Conn {
+	t.Helper()
+	conn, _, err := fastws.DefaultDialer.Dial(fmt.Sprintf("ws://%s/ws?key=%s&since=%s", addr, key, since), nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", addr, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// waitSubscribers waits until n nodes subscribed to the user's broadcast channel
+func waitSubscribers(t *testing.T, rdb *redis.Client, key string, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		counts, err := rdb.PubSubNumSub(context.Background(), BroadcastChannel(key)).Result()
+		if err == nil && counts[BroadcastChannel(key)] == n {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expected %d subscribers for %s", n, key)
+}
+
+// testStream creates a stream and consumer group that are removed after the test
+func testStream(t *testing.T, rdb *redis.Client, group string) string {
+	t.Helper()
+	stream := fmt.Sprintf("inapp:test:stream:%d", time.Now().UnixNano())
+	if err := rdb.XGroupCreateMkStream(context.Background(), stream, group, "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdb.Del(context.Background(), stream) })
+	return stream
+}
+
+func publish(t *testing.T, rdb *redis.Client, stream, id, applicationID, recipient string) {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]string{"id": id, "application_id": applicationID, "recipient": recipient})
+	err := rdb.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{"payload": string(payload), "id": id},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readNotification(t *testing.T, conn *fastws.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// testDB opens a SQLite database with the in-app tables
+func testDB(t *testing.T) {
+	t.Helper()
+	config := db.MySQLConfig{DSN: filepath.Join(t.TempDir(), "agni.db"), LogLevel: logger.Silent}
+	err := db.InitMySQL("local", config, &db.Notification{}, &db.InAppBroadcast{}, &db.InAppTopicSubscription{}, &db.InAppBroadcastWatermark{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.CloseMySQL() })
+}
+
+// inboxNotification stores an unread in-app notification of the user
+func inboxNotification(t *testing.T, applicationID uuid.UUID, userID string, createdAt time.Time) db.Notification {
+	t.Helper()
+	notification := db.Notification{
+		ID:            uuid.New(),
+		ApplicationID: applicationID,
+		QueueID:       uuid.NewString(),
+		Channel:       "InApp",
+		Recipient:     userID,
+		Message:       "hello",
+		Status:        "sent",
+		CreatedAt:     createdAt,
+	}
+	if err := db.GetMySQLDB().Create(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+// request sends a request and returns the response carrying its id, skipping the events in between
+func request(t *testing.T, conn *fastws.Conn, req map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		response := readNotification(t, conn)
+		if response["request_id"] == req["id"] {
+			return response
+		}
+	}
+}
+
+func TestTwoNodesDeliverToWhicheverHoldsTheSocket(t *testing.T) {
+	rdb := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	group := "inapp-test-group"
+	stream := testStream(t, rdb, group)
+
+	nodeA := startNode(t, ctx, rdb, stream, group, "node-a")
+	nodeB := startNode(t, ctx, rdb, stream, group, "node-b")
+
+	applicationID := "app-" + suffix
+	onlyB := applicationID + ":user-b"
+	both := applicationID + ":user-ab"
+
+	connB := connect(t, nodeB, onlyB)
+	waitSubscribers(t, rdb, onlyB, 1)
+
+	connAB1 := connect(t, nodeA, both)
+	connAB2 := connect(t, nodeB, both)
+	waitSubscribers(t, rdb, both, 2)
+
+	// several entries so both consumers of the group read some of them
+	var ids []string
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("n-%s-%d", suffix, i)
+		ids = append(ids, id)
+		publish(t, rdb, stream, id, applicationID, "user-b")
+	}
+	// the two consumers publish concurrently, so the order isn't guaranteed
+	received := map[interface{}]bool{}
+	for range ids {
+		received[readNotification(t, connB)["id"]] = true
+	}
+	for _, id := range ids {
+		if !received[id] {
+			t.Fatalf("node B did not deliver %s, got %v", id, received)
+		}
+	}
+
+	id := "n-" + suffix + "-both"
+	publish(t, rdb, stream, id, applicationID, "user-ab")
+	for name, conn := range map[string]*fastws.Conn{"node A": connAB1, "node B": connAB2} {
+		if got := readNotification(t, conn)["id"]; got != id {
+			t.Fatalf("%s delivered %v, want %s", name, got, id)
+		}
+	}
+
+	// delivered entries are acknowledged, right after the publish, and kept in the stream
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, err := rdb.XPending(ctx, stream, group).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no pending entries, got %d", pending.Count)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if length := rdb.XLen(ctx, stream).Val(); length != 7 {
+		t.Fatalf("expected 7 entries in the stream, got %d", length)
+	}
+	for _, id := range append(ids, id) {
+		rdb.Del(context.Background(), ProcessedKeyPrefix+id)
+	}
+}
+
+func TestUnsubscribesWhenLastClientLeaves(t *testing.T) {
+	rdb := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	group := "inapp-test-group"
+	addr := startNode(t, ctx, rdb, testStream(t, rdb, group), group, "node-a")
+	key := fmt.Sprintf("app-%d:user", time.Now().UnixNano())
+
+	first := connect(t, addr, key)
+	second := connect(t, addr, key)
+	waitSubscribers(t, rdb, key, 1)
+
+	first.Close()
+	time.Sleep(100 * time.Millisecond)
+	waitSubscribers(t, rdb, key, 1)
+
+	second.Close()
+	waitSubscribers(t, rdb, key, 0)
+}
+
+func TestSubscriptionsFollowConcurrentConnects(t *testing.T) {
+	rdb := testRedis(t)
+	hub := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { hub.Close() })
+	key := fmt.Sprintf("app-%d:user", time.Now().UnixNano())
+
+	// the first and last clients race, whichever subscription call runs last sees the current clients
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hub.Unregister(hub.add(key, nil, false))
+		}()
+	}
+	wg.Wait()
+	waitSubscribers(t, rdb, key, 0)
+	hub.subscriptionsMu.Lock()
+	subscribed := len(hub.subscribed)
+	hub.subscriptionsMu.Unlock()
+	if subscribed != 0 {
+		t.Fatalf("%d channels are still subscribed", subscribed)
+	}
+
+	c := hub.add(key, nil, false)
+	waitSubscribers(t, rdb, key, 1)
+	hub.Unregister(c)
+	waitSubscribers(t, rdb, key, 0)
+}
+
+func TestReplaysNotificationsMissedWhileOffline(t *testing.T) {
+	rdb := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	group := "inapp-test-group"
+	stream := testStream(t, rdb, group)
+	addr := startNode(t, ctx, rdb, stream, group, "node-a")
+
+	applicationID := "app-" + suffix
+	key := applicationID + ":user"
+	t.Cleanup(func() { rdb.Del(context.Background(), UserStream(key)) })
+
+	conn := connect(t, addr, key)
+	waitSubscribers(t, rdb, key, 1)
+	publish(t, rdb, stream, "seen-"+suffix, applicationID, "user")
+	seen := readNotification(t, conn)
+	lastEventID, _ := seen["event_id"].(string)
+	if lastEventID == "" {
+		t.Fatalf("notification has no event_id: %v", seen)
+	}
+	conn.Close()
+	waitSubscribers(t, rdb, key, 0)
+
+	// sent while the user is offline
+	missed := []string{"missed-1-" + suffix, "missed-2-" + suffix}
+	for _, id := range missed {
+		publish(t, rdb, stream, id, applicationID, "user")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rdb.XLen(ctx, UserStream(key)).Val() < 3 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	conn = connectSince(t, addr, key, lastEventID)
+	for _, id := range missed {
+		if got := readNotification(t, conn)["id"]; got != id {
+			t.Fatalf("replayed %v, want %s", got, id)
+		}
+	}
+
+	// then live delivery continues
+	waitSubscribers(t, rdb, key, 1)
+	publish(t, rdb, stream, "live-"+suffix, applicationID, "user")
+	if got := readNotification(t, conn)["id"]; got != "live-"+suffix {
+		t.Fatalf("delivered %v, want live-%s", got, suffix)
+	}
+}
+
+func TestRetriedEntryIsStoredForReplayOnce(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	group := "inapp-test-group"
+	stream := testStream(t, rdb, group)
+	key := "app-" + suffix + ":user"
+	t.Cleanup(func() { rdb.Del(ctx, UserStream(key)) })
+
+	sub := rdb.Subscribe(ctx, BroadcastChannel(key))
+	t.Cleanup(func() { sub.Close() })
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	id := "n-" + suffix
+	payload, _ := json.Marshal(map[string]string{"id": id, "application_id": "app-" + suffix, "recipient": "user"})
+	entry := redis.XMessage{ID: "1-1", Values: map[string]interface{}{"payload": string(payload)}}
+	handleEntry(ctx, rdb, stream, group, entry)
+	// the publish of the first attempt failed after the replay entry was written, claimPending retries it
+	rdb.Del(ctx, ProcessedKeyPrefix+id)
+	handleEntry(ctx, rdb, stream, group, entry)
+
+	if n := rdb.XLen(ctx, UserStream(key)).Val(); n != 1 {
+		t.Fatalf("the replay stream holds %d entries", n)
+	}
+	var eventIDs []string
+	for i := 0; i < 2; i++ {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var event map[string]interface{}
+		json.Unmarshal([]byte(msg.Payload), &event)
+		eventID, _ := event["event_id"].(string)
+		eventIDs = append(eventIDs, eventID)
+	}
+	if eventIDs[0] == "" || eventIDs[0] != eventIDs[1] {
+		t.Fatalf("the attempts published the event ids %v", eventIDs)
+	}
+}
+
+func TestClientOverflowingDuringReplayIsDropped(t *testing.T) {
+	rdb := testRedis(t)
+	hub := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { hub.Close() })
+	key := fmt.Sprintf("app-%d:user", time.Now().UnixNano())
+
+	c := hub.add(key, nil, true)
+	// live events held back while the replay runs, more than the write buffer takes
+	for i := 0; i <= cap(c.Write); i++ {
+		c.send(liveEvent{id: fmt.Sprintf("%d-0", i+1), data: []byte("{}")})
+	}
+	hub.replay(c, "0")
+
+	select {
+	case <-c.quit:
+	default:
+		t.Fatal("the client wasn't dropped")
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if len(hub.clients[key]) != 0 {
+		t.Fatal("the client is still registered")
+	}
+}
+
+func TestBroadcastReachesTheConnectedUsersOfTheApplication(t *testing.T) {
+	rdb := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	group := "inapp-test-group"
+	stream := testStream(t, rdb, group)
+	nodeA := startNode(t, ctx, rdb, stream, group, "node-a")
+	nodeB := startNode(t, ctx, rdb, stream, group, "node-b")
+
+	suffix := fmt.Sprint(time.Now().UnixNano())
+	applicationID := "app-" + suffix
+	users := map[string]*fastws.Conn{
+		"user-1": connect(t, nodeA, applicationID+":user-1"),
+		"user-2": connect(t, nodeB, applicationID+":user-2"),
+	}
+	other := connect(t, nodeA, "other-"+suffix+":user-1")
+	waitSubscribers(t, rdb, applicationID+":user-1", 1)
+	waitSubscribers(t, rdb, applicationID+":user-2", 1)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		counts, _ := rdb.PubSubNumSub(ctx, AppChannel(applicationID)).Result()
+		if counts[AppChannel(applicationID)] == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected both nodes to subscribe to the application channel")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	broadcastID := uuid.New()
+	payload, _ := json.Marshal(map[string]interface{}{
+		"id":             broadcastID.String(),
+		"application_id": applicationID,
+		"in_app":         map[string]interface{}{"broadcast": true},
+	})
+	err := rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: map[string]interface{}{"payload": string(payload), "id": broadcastID.String()},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rdb.Del(context.Background(), ProcessedKeyPrefix+broadcastID.String()) })
+
+	// every user gets the broadcast under the id of their own inbox row
+	for userID, conn := range users {
+		got := readNotification(t, conn)
+		if got["id"] != db.BroadcastNotificationID(broadcastID, userID).String() || got["recipient"] != userID {
+			t.Fatalf("%s received %v", userID, got)
+		}
+	}
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Fatalf("user of another application received %s", data)
+	}
+}
+
+func TestPresenceAcrossNodes(t *testing.T) {
+	rdb := testRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	group := "inapp-test-group"
+	stream := testStream(t, rdb, group)
+	nodeA := startNode(t, ctx, rdb, stream, group, "node-a")
+	nodeB := startNode(t, ctx, rdb, stream, group, "node-b")
+
+	applicationID := fmt.Sprintf("app-%d", time.Now().UnixNano())
+	events := rdb.Subscribe(ctx, presence.EventsChannel(applicationID))
+	t.Cleanup(func() { events.Close() })
+	if _, err := events.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitPresence := func(want presence.Presence) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, err := presence.Get(ctx, rdb, applicationID, want.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("presence %+v, want %+v", got, want)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	nextEvent := func() presence.Presence {
+		t.Helper()
+		select {
+		case msg := <-events.Channel():
+			var got presence.Presence
+			if err := json.Unmarshal([]byte(msg.Payload), &got); err != nil {
+				t.Fatal(err)
+			}
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatal("no presence event")
+			return presence.Presence{}
+		}
+	}
+
+	waitPresence(presence.Presence{UserID: "user"})
+
+	laptop := connect(t, nodeA, applicationID+":user")
+	if got := nextEvent(); got != (presence.Presence{UserID: "user", Online: true, Devices: 1}) {
+		t.Fatalf("unexpected event %+v", got)
+	}
+	phone := connect(t, nodeB, applicationID+":user")
+	nextEvent()
+	waitPresence(presence.Presence{UserID: "user", Online: true, Devices: 2})
+
+	laptop.Close()
+	nextEvent()
+	waitPresence(presence.Presence{UserID: "user", Online: true, Devices: 1})
+
+	phone.Close()
+	if got := nextEvent(); got != (presence.Presence{UserID: "user"}) {
+		t.Fatalf("unexpected event %+v", got)
+	}
+}
+
+func TestClosingDoesNotWaitForABlockedDelivery(t *testing.T) {
+	c := &Client{Write: make(chan []byte, 1), quit: make(chan struct{}), done: make(chan struct{})}
+	c.Write <- []byte("buffered")
+
+	delivered := make(chan bool)
+	go func() { delivered <- c.deliver([]byte("replayed")) }()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		c.closeWith(nil)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("closing waited for the delivery to a client that doesn't read")
+	}
+	if <-delivered {
+		t.Fatal("expected the delivery to be abandoned")
+	}
+}
+
+func TestDrainAsksClientsToReconnect(t *testing.T) {
+	rdb := testRedis(t)
+	hub := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { hub.Close() })
+	addr := serve(t, hub)
+
+	applicationID := uuid.NewString()
+	conn := connect(t, addr, applicationID+":user")
+	waitSubscribers(t, rdb, applicationID+":user", 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectReconnect := func(conn *fastws.Conn) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _, err := conn.ReadMessage()
+		if !fastws.IsCloseError(err, fastws.CloseServiceRestart) {
+			t.Fatalf("expected a service restart close, got %v", err)
+		}
+	}
+	expectReconnect(conn)
+	waitSubscribers(t, rdb, applicationID+":user", 0)
+
+	// the node is going away, new connections are turned away too
+	expectReconnect(connect(t, addr, applicationID+":other"))
+}
+
+func TestRequestsAreAnsweredWithTheirID(t *testing.T) {
+	rdb := testRedis(t)
+	testDB(t)
+	hub := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { hub.Close() })
+	addr := serve(t, hub)
+
+	applicationID := uuid.New()
+	key := applicationID.String() + ":user"
+	now := time.Now()
+	first := inboxNotification(t, applicationID, "user", now.Add(-2*time.Minute))
+	second := inboxNotification(t, applicationID, "user", now.Add(-time.Minute))
+	conn := connect(t, addr, key)
+
+	response := request(t, conn, map[string]interface{}{"id": "1", "type": RequestUnreadCount})
+	if response["type"] != MessageUnreadCount || response["data"].(map[string]interface{})["unread_count"] != float64(2) {
+		t.Fatalf("unexpected response %v", response)
+	}
+
+	response = request(t, conn, map[string]interface{}{"id": "2", "type": RequestList, "limit": 1, "include_total": true})
+	data, _ := response["data"].(map[string]interface{})
+	notifications, _ := data["notifications"].([]interface{})
+	if response["type"] != MessageNotifications || len(notifications) != 1 || data["has_more"] != true || data["total"] != float64(2) {
+		t.Fatalf("unexpected response %v", response)
+	}
+	if id := notifications[0].(map[string]interface{})["ID"]; id != second.ID.String() {
+		t.Fatalf("listed %v first, want the newest %s", id, second.ID)
+	}
+	response = request(t, conn, map[string]interface{}{"id": "3", "type": RequestList, "cursor": data["next_cursor"]})
+	data, _ = response["data"].(map[string]interface{})
+	if notifications, _ := data["notifications"].([]interface{}); len(notifications) != 1 || data["has_more"] != false {
+		t.Fatalf("unexpected last page %v", response)
+	}
+
+	response = request(t, conn, map[string]interface{}{"id": "4", "type": RequestMarkRead, "notification_id": first.ID.String()})
+	if response["type"] != MessageMarkedRead || response["data"].(map[string]interface{})["notification_id"] != first.ID.String() {
+		t.Fatalf("unexpected response %v", response)
+	}
+	response = request(t, conn, map[string]interface{}{"id": "5", "type": RequestAck, "notification_ids": []string{second.ID.String()}})
+	if response["type"] != MessageAcked {
+		t.Fatalf("unexpected response %v", response)
+	}
+
+	for _, test := range []struct {
+		req   map[string]interface{}
+		error string
+	}{
+		{map[string]interface{}{"id": "6", "type": "subscribe"}, "unknown request type: subscribe"},
+		{map[string]interface{}{"id": "7", "type": RequestMarkRead, "notification_id": "42"}, "invalid notification_id"},
+		{map[string]interface{}{"id": "8", "type": RequestMarkRead, "notification_id": uuid.NewString()}, "notification not found"},
+		{map[string]interface{}{"id": "9", "type": RequestAck, "notification_ids": []string{"42"}}, "invalid notification_ids"},
+		{map[string]interface{}{"id": "10", "type": RequestList, "cursor": "not-a-cursor"}, db.ErrInvalidCursor.Error()},
+	} {
+		if response := request(t, conn, test.req); response["type"] != MessageError || response["error"] != test.error {
+			t.Errorf("%v: got %v, want the error %q", test.req, response, test.error)
+		}
+	}
+
+	// a message that isn't a request has no id to answer with
+	if err := conn.WriteMessage(fastws.TextMessage, []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		response := readNotification(t, conn)
+		if response["type"] == MessageError {
+			if response["error"] != "invalid message" || response["request_id"] != nil {
+				t.Fatalf("unexpected response %v", response)
+			}
+			break
+		}
+	}
+}
+
+func TestClientNotReadingItsResponsesIsDropped(t *testing.T) {
+	rdb := testRedis(t)
+	hub := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { hub.Close() })
+	key := fmt.Sprintf("app-%d:user", time.Now().UnixNano())
+	queued := make(chan int, 1)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/ws", websocket.New(func(conn *websocket.Conn) {
+		client := hub.add(key, conn, false)
+		queued <- cap(client.Write)
+		// the write buffer is full, as if the client stopped reading, and the pump only starts
+		// once the client quit so nothing drains it meanwhile
+		for len(client.Write) < cap(client.Write) {
+			client.Write <- []byte(`{"type": "filler"}`)
+		}
+		go func() {
+			<-client.quit
+			client.writePump()
+		}()
+		client.ReadPump()
+	}))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	conn := connect(t, ln.Addr().String(), key)
+	if err := conn.WriteJSON(map[string]interface{}{"id": "1", "type": RequestUnreadCount}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the queued messages are flushed, then the connection is closed instead of waiting for room
+	filled := <-queued
+	for i := 0; ; i++ {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if !fastws.IsCloseError(err, fastws.CloseNormalClosure, fastws.CloseNoStatusReceived) || i != filled {
+				t.Fatalf("after %d messages: %v", i, err)
+			}
+			break
+		}
+		if string(data) != `{"type": "filler"}` {
+			t.Fatalf("got %s, the response should have been dropped", data)
+		}
+	}
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	if len(hub.clients[key]) != 0 {
+		t.Fatal("the client is still registered")
+	}
+}
+
+func TestReadStateReachesTheUsersOtherDevices(t *testing.T) {
+	rdb := testRedis(t)
+	testDB(t)
+	nodeA := NewHub(context.Background(), rdb)
+	nodeB := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { nodeA.Close(); nodeB.Close() })
+
+	applicationID := uuid.New()
+	key := applicationID.String() + ":user"
+	read := inboxNotification(t, applicationID, "user", time.Now().Add(-time.Minute))
+	inboxNotification(t, applicationID, "user", time.Now())
+
+	// the phone is connected to another node than the laptop
+	laptop := connect(t, serve(t, nodeA), key)
+	phone := connect(t, serve(t, nodeB), key)
+	other := connect(t, serve(t, nodeB), applicationID.String()+":someone-else")
+	waitSubscribers(t, rdb, key, 2)
+
+	request(t, laptop, map[string]interface{}{"id": "1", "type": RequestMarkRead, "notification_id": read.ID.String()})
+	event := readNotification(t, phone)
+	ids, _ := event["data"].(map[string]interface{})["notification_ids"].([]interface{})
+	if event["type"] != EventRead || len(ids) != 1 || ids[0] != read.ID.String() {
+		t.Fatalf("unexpected read event %v", event)
+	}
+	event = readNotification(t, phone)
+	if event["type"] != EventUnreadCount || event["data"].(map[string]interface{})["unread_count"] != float64(1) {
+		t.Fatalf("unexpected unread count %v", event)
+	}
+
+	request(t, laptop, map[string]interface{}{"id": "2", "type": RequestMarkAllRead})
+	if event := readNotification(t, phone); event["type"] != EventRead || event["data"].(map[string]interface{})["all"] != true {
+		t.Fatalf("unexpected read event %v", event)
+	}
+	if event := readNotification(t, phone); event["data"].(map[string]interface{})["unread_count"] != float64(0) {
+		t.Fatalf("unexpected unread count %v", event)
+	}
+
+	// a count published by the API, e.g. after a new notification, reaches every device
+	inboxNotification(t, applicationID, "user", time.Now())
+	PublishUnreadCount(context.Background(), rdb, applicationID.String(), "user")
+	for _, conn := range []*fastws.Conn{laptop, phone} {
+		for {
+			event := readNotification(t, conn)
+			if event["type"] == EventUnreadCount && event["data"].(map[string]interface{})["unread_count"] == float64(1) {
+				break
+			}
+		}
+	}
+
+	// nothing reached the other user
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := other.ReadMessage(); err == nil {
+		t.Fatalf("another user received %s", data)
+	}
+}
@@ -0,0 +1,46 @@
+// pkg/inapp/middleware/sse-auth.go
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/r1i2t3/agni/pkg/utils"
+)
+
+// SSEJWTAuth validates JWT token for server-sent events streams
+// Accepts token from either:
+// - Query parameter: ?token=<jwt> (EventSource can't set headers)
+// - Authorization header: Bearer <jwt>
+// - Cookie: Agni-auth-token
+func SSEJWTAuth(c *fiber.Ctx) error {
+	token := c.Query("token")
+
+	if token == "" {
+		token = strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	}
+
+	if token == "" {
+		token = c.Cookies("Agni-auth-token")
+	}
+
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required: provide token via query parameter, Authorization header or cookie",
+		})
+	}
+
+	// Validate and extract claims from JWT
+	applicationID, userID, err := utils.ValidateApplicationJWT(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid token: " + err.Error(),
+		})
+	}
+
+	// Store in context for handler
+	c.Locals("application_id", applicationID.String())
+	c.Locals("user_id", userID)
+
+	return c.Next()
+}
@@ -0,0 +1,124 @@
+package inapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/inapp/presence"
+	"github.com/redis/go-redis/v9"
+)
+
+// TrackPresence publishes the users connected to this hub under the node name until the hub closes
+func (h *Hub) TrackPresence(node string) {
+	h.mu.Lock()
+	h.node = node
+	h.mu.Unlock()
+
+	h.heartbeat()
+	go func() {
+		ticker := time.NewTicker(presence.Heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.heartbeat()
+			case <-h.closed:
+				return
+			case <-h.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// heartbeat rewrites the presence of this node from the connected clients and renews it
+func (h *Hub) heartbeat() {
+	select {
+	case <-h.closed:
+		return
+	default:
+	}
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	h.mu.RLock()
+	node := h.node
+	devices := make(map[string]interface{}, len(h.clients))
+	for userID, clients := range h.clients {
+		devices[userID] = len(clients)
+	}
+	h.mu.RUnlock()
+	if node == "" {
+		return
+	}
+
+	key := presence.NodePrefix + node
+	_, err := h.rdb.TxPipelined(h.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(h.ctx, key)
+		if len(devices) > 0 {
+			pipe.HSet(h.ctx, key, devices)
+			pipe.Expire(h.ctx, key, presence.TTL)
+		}
+		pipe.ZAdd(h.ctx, presence.NodesKey, redis.Z{Score: float64(time.Now().UnixMilli()), Member: node})
+		// nodes gone for long are forgotten
+		pipe.ZRemRangeByScore(h.ctx, presence.NodesKey, "-inf", fmt.Sprint(time.Now().Add(-10*presence.TTL).UnixMilli()))
+		return nil
+	})
+	if err != nil && h.ctx.Err() == nil {
+		log.Printf("❌ Failed to publish presence of node %s: %v", node, err)
+	}
+}
+
+// presenceChanged records the devices of a user on this node, called after h.mu is released.
+// presenceMu orders the writes and the count is read while holding it, so the last write has
+// the current count. With announce, when the user came online or left the node, the presence
+// across the nodes is published to the application's presence events.
+func (h *Hub) presenceChanged(userID string, announce bool) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	h.mu.RLock()
+	node := h.node
+	devices := len(h.clients[userID])
+	h.mu.RUnlock()
+	if node == "" {
+		return
+	}
+
+	key := presence.NodePrefix + node
+	var err error
+	if devices > 0 {
+		_, err = h.rdb.Pipelined(h.ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(h.ctx, key, userID, devices)
+			pipe.Expire(h.ctx, key, presence.TTL)
+			return nil
+		})
+	} else {
+		err = h.rdb.HDel(h.ctx, key, userID).Err()
+	}
+	if err != nil {
+		log.Printf("❌ Failed to update presence of %s: %v", userID, err)
+		return
+	}
+
+	if announce {
+		applicationID, user, _ := strings.Cut(userID, ":")
+		go presence.Publish(h.ctx, h.rdb, applicationID, user)
+	}
+}
+
+// clearPresence removes this node's presence, the users still connected elsewhere stay online
+func (h *Hub) clearPresence() {
+	h.mu.RLock()
+	node := h.node
+	h.mu.RUnlock()
+	if node == "" {
+		return
+	}
+	ctx := context.Background()
+	_ = h.rdb.Del(ctx, presence.NodePrefix+node).Err()
+	_ = h.rdb.ZRem(ctx, presence.NodesKey, node).Err()
+}
@@ -0,0 +1,85 @@
+// Package presence reads which users are connected to the in-app service. It only needs
+// Redis, so channels like email can check presence without depending on the WebSocket hub.
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Presence is kept per node: a hash of the devices connected to the node per app_id:user_id,
+// refreshed by a heartbeat, and the nodes sorted by their last heartbeat. The hash of a node
+// that stops expires after TTL and its last heartbeat falls out of the window.
+const (
+	NodesKey     = "inapp:presence:nodes"
+	NodePrefix   = "inapp:presence:node:"
+	EventsPrefix = "inapp:presence:events:"
+
+	Heartbeat = 10 * time.Second
+	TTL       = 3 * Heartbeat
+)
+
+// Presence tells whether a user is connected and with how many devices, across all nodes
+type Presence struct {
+	UserID  string `json:"user_id"`
+	Online  bool   `json:"online"`
+	Devices int64  `json:"devices"`
+}
+
+// EventsChannel carries the Presence of the application's users when they come online or leave
+func EventsChannel(applicationID string) string {
+	return EventsPrefix + applicationID
+}
+
+// Get sums the devices of the user on the nodes that sent a heartbeat recently
+func Get(ctx context.Context, rdb *redis.Client, applicationID, userID string) (Presence, error) {
+	presence := Presence{UserID: userID}
+	nodes, err := rdb.ZRangeByScore(ctx, NodesKey, &redis.ZRangeBy{
+		Min: fmt.Sprint(time.Now().Add(-TTL).UnixMilli()),
+		Max: "+inf",
+	}).Result()
+	if err != nil || len(nodes) == 0 {
+		return presence, err
+	}
+
+	key := fmt.Sprintf("%s:%s", applicationID, userID)
+	pipe := rdb.Pipeline()
+	counts := make([]*redis.StringCmd, 0, len(nodes))
+	for _, node := range nodes {
+		counts = append(counts, pipe.HGet(ctx, NodePrefix+node, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return presence, err
+	}
+	for _, count := range counts {
+		if devices, err := count.Int64(); err == nil {
+			presence.Devices += devices
+		}
+	}
+	presence.Online = presence.Devices > 0
+	return presence, nil
+}
+
+// IsOnline reports whether the user has a device connected to any node
+func IsOnline(ctx context.Context, rdb *redis.Client, applicationID, userID string) (bool, error) {
+	presence, err := Get(ctx, rdb, applicationID, userID)
+	return presence.Online, err
+}
+
+// Publish sends the presence of the user across the nodes to the application's presence events
+func Publish(ctx context.Context, rdb *redis.Client, applicationID, userID string) {
+	presence, err := Get(ctx, rdb, applicationID, userID)
+	if err != nil {
+		log.Printf("❌ Failed to read presence of %s:%s: %v", applicationID, userID, err)
+		return
+	}
+	event, _ := json.Marshal(presence)
+	if err := rdb.Publish(ctx, EventsChannel(applicationID), event).Err(); err != nil {
+		log.Printf("❌ Failed to publish presence of %s:%s: %v", applicationID, userID, err)
+	}
+}
@@ -0,0 +1,174 @@
+package inapp
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+)
+
+// Requests a client sends over /ws
+const (
+	RequestList        = "list"
+	RequestUnreadCount = "unread_count"
+	RequestMarkRead    = "mark_read"
+	RequestMarkAllRead = "mark_all_read"
+	RequestAck         = "ack"
+)
+
+// Messages the server sends: notifications and the responses to requests
+const (
+	MessageNotification  = "notification"
+	MessageNotifications = "notifications"
+	MessageUnreadCount   = "unread_count"
+	MessageMarkedRead    = "marked_read"
+	MessageMarkedAllRead = "marked_all_read"
+	MessageAcked         = "acked"
+	MessageError         = "error"
+)
+
+// Request is a client message, e.g. {"id": "1", "type": "mark_read", "notification_id": "..."}
+type Request struct {
+	ID   string `json:"id"` // echoed as request_id in the response
+	Type string `json:"type"`
+
+	NotificationID  string   `json:"notification_id,omitempty"`  // mark_read
+	NotificationIDs []string `json:"notification_ids,omitempty"` // ack
+	Limit           int      `json:"limit,omitempty"`            // list
+	Cursor          string   `json:"cursor,omitempty"`           // list, next_cursor of the previous page
+	IncludeTotal    bool     `json:"include_total,omitempty"`    // list
+	UnreadOnly      bool     `json:"unread_only,omitempty"`      // list
+	Category        string   `json:"category,omitempty"`         // list
+	Archived        bool     `json:"archived,omitempty"`         // list
+	Pinned          *bool    `json:"pinned,omitempty"`           // list, true for the pinned ones, false for the others
+}
+
+// Response answers one Request, Type is MessageError when it failed
+type Response struct {
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// notificationEvent is the message a notification is delivered with, live or replayed.
+// Broadcasts have no event id, they aren't replayed but listed from the inbox, and no unread
+// count follows them.
+func notificationEvent(payload map[string]interface{}, eventID string) []byte {
+	payload["type"] = MessageNotification
+	if eventID != "" {
+		payload["event_id"] = eventID
+	}
+	data, _ := json.Marshal(payload)
+	return data
+}
+
+// handleRequest runs a client request against the user's notifications and returns the response
+func (c *Client) handleRequest(message []byte) []byte {
+	var req Request
+	if err := json.Unmarshal(message, &req); err != nil {
+		return encodeResponse(Response{Type: MessageError, Error: "invalid message"})
+	}
+
+	data, responseType, err := c.dispatch(req)
+	if err != nil {
+		return encodeResponse(Response{Type: MessageError, RequestID: req.ID, Error: err.Error()})
+	}
+	return encodeResponse(Response{Type: responseType, RequestID: req.ID, Data: data})
+}
+
+func (c *Client) dispatch(req Request) (interface{}, string, error) {
+	applicationID, userID, _ := strings.Cut(c.userID, ":")
+	if db.GetMySQLDB() == nil {
+		return nil, "", errors.New("notifications are unavailable")
+	}
+
+	switch req.Type {
+	case RequestList:
+		result, err := db.GetInAppNotifications(applicationID, userID, db.InAppNotificationFilter{
+			UnreadOnly: req.UnreadOnly,
+			Category:   req.Category,
+			Archived:   req.Archived,
+			Pinned:     req.Pinned,
+		}, db.InAppPageRequest{Limit: req.Limit, Cursor: req.Cursor, IncludeTotal: req.IncludeTotal})
+		if errors.Is(err, db.ErrInvalidCursor) {
+			return nil, "", err
+		}
+		if err != nil {
+			return nil, "", errors.New("failed to fetch notifications")
+		}
+		data := map[string]interface{}{
+			"notifications": result.Notifications,
+			"next_cursor":   result.NextCursor,
+			"has_more":      result.NextCursor != "",
+		}
+		if result.Total != nil {
+			data["total"] = *result.Total
+		}
+		return data, MessageNotifications, nil
+
+	case RequestUnreadCount:
+		count, err := db.CountUnreadInAppNotifications(applicationID, userID)
+		if err != nil {
+			return nil, "", errors.New("failed to get unread count")
+		}
+		return map[string]interface{}{"unread_count": count}, MessageUnreadCount, nil
+
+	case RequestMarkRead:
+		id, err := uuid.Parse(req.NotificationID)
+		if err != nil {
+			return nil, "", errors.New("invalid notification_id")
+		}
+		updated, err := db.MarkInAppNotificationRead(applicationID, userID, id)
+		if err != nil {
+			return nil, "", errors.New("failed to mark notification as read")
+		}
+		if updated == 0 {
+			return nil, "", errors.New("notification not found")
+		}
+		c.publishReadState(applicationID, userID, []string{id.String()})
+		return map[string]interface{}{"notification_id": id.String()}, MessageMarkedRead, nil
+
+	case RequestMarkAllRead:
+		updated, err := db.MarkAllInAppNotificationsRead(applicationID, userID)
+		if err != nil {
+			return nil, "", errors.New("failed to mark notifications as read")
+		}
+		if updated > 0 {
+			c.publishReadState(applicationID, userID, nil)
+		}
+		return map[string]interface{}{"count": updated}, MessageMarkedAllRead, nil
+
+	case RequestAck:
+		ids := make([]uuid.UUID, 0, len(req.NotificationIDs))
+		for _, raw := range req.NotificationIDs {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return nil, "", errors.New("invalid notification_ids")
+			}
+			ids = append(ids, id)
+		}
+		acked, err := db.AckInAppNotifications(applicationID, userID, ids)
+		if err != nil {
+			return nil, "", errors.New("failed to ack notifications")
+		}
+		return map[string]interface{}{"count": acked}, MessageAcked, nil
+
+	default:
+		return nil, "", errors.New("unknown request type: " + req.Type)
+	}
+}
+
+// publishReadState syncs the user's other devices, including those connected to other nodes
+func (c *Client) publishReadState(applicationID, userID string, ids []string) {
+	if c.hub != nil {
+		PublishReadState(c.hub.ctx, c.hub.rdb, applicationID, userID, ids)
+	}
+}
+
+func encodeResponse(response Response) []byte {
+	data, _ := json.Marshal(response)
+	return data
+}
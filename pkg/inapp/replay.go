@@ -0,0 +1,150 @@
+package inapp
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// liveEvent is a broadcast received while the client was still replaying
+type liveEvent struct {
+	id   string
+	data []byte
+}
+
+// cursorRe matches a stream entry id (ms-seq) or a unix time in milliseconds
+var cursorRe = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+// replay sends the entries of the user's replay stream after since, then the live events
+// that arrived meanwhile and weren't part of the replay
+func (h *Hub) replay(c *Client, since string) {
+	var last string
+	defer func() {
+		// like a live event, a client that can't keep up is dropped and replays again on reconnect
+		if !c.finishReplay(last) {
+			log.Printf("⚠️  Dropping client %s, it can't keep up with the events held back during replay", c.userID)
+			h.Unregister(c)
+		}
+	}()
+
+	if !cursorRe.MatchString(since) {
+		log.Printf("⚠️  Ignoring invalid replay cursor %q for %s", since, c.userID)
+		return
+	}
+	start := since
+	if strings.Contains(since, "-") {
+		// exclusive, the client already has the event since
+		start = "(" + since
+	}
+
+	entries, err := h.rdb.XRange(h.ctx, UserStream(c.userID), start, "+").Result()
+	if err != nil {
+		log.Printf("❌ Failed to read replay stream for %s: %v", c.userID, err)
+		return
+	}
+
+	for _, entry := range entries {
+		raw, _ := entry.Values["payload"].(string)
+		var payload map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &payload); err != nil || expired(payload, time.Now()) {
+			continue
+		}
+		if !c.deliver(notificationEvent(payload, entry.ID)) {
+			return
+		}
+		last = entry.ID
+	}
+	if len(entries) > 0 {
+		log.Printf("🔁 Replayed %d notifications to %s", len(entries), c.userID)
+	}
+}
+
+// send delivers a live event without blocking, it reports false when the client can't keep up
+func (c *Client) send(event liveEvent) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return true
+	}
+	if c.replaying {
+		c.backlog = append(c.backlog, event)
+		return true
+	}
+	select {
+	case c.Write <- event.data:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver waits for room in the write buffer, it reports false once the client is gone.
+// It doesn't take c.mu, Unregister closes the client meanwhile.
+func (c *Client) deliver(data []byte) bool {
+	select {
+	case <-c.quit:
+		return false
+	default:
+	}
+	select {
+	case c.Write <- data:
+		return true
+	case <-c.quit:
+		return false
+	case <-c.done:
+		return false
+	}
+}
+
+// finishReplay switches the client to live delivery, skipping the held back events that were
+// replayed. Like send, it reports false when the write buffer has no room for the others.
+func (c *Client) finishReplay(last string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	backlog := c.backlog
+	c.backlog = nil
+	c.replaying = false
+	if c.closed {
+		return true
+	}
+	for _, event := range backlog {
+		if last != "" && event.id != "" && compareEventIDs(event.id, last) <= 0 {
+			continue
+		}
+		select {
+		case c.Write <- event.data:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// compareEventIDs orders two stream entry ids
+func compareEventIDs(a, b string) int {
+	aMs, aSeq := splitEventID(a)
+	bMs, bSeq := splitEventID(b)
+	switch {
+	case aMs != bMs:
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	case aSeq != bSeq:
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func splitEventID(id string) (uint64, uint64) {
+	msPart, seqPart, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msPart, 10, 64)
+	seq, _ := strconv.ParseUint(seqPart, 10, 64)
+	return ms, seq
+}
@@ -0,0 +1,83 @@
+package inapp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SnoozesKey sorts the snoozed notifications by the time they return to the inbox, the in-app
+// service tells the user's devices then (see WatchSnoozes)
+const SnoozesKey = "inapp:snoozes"
+
+// snoozeCheckInterval is how often WatchSnoozes looks for the snoozes that ended
+var snoozeCheckInterval = 5 * time.Second
+
+// claimSnoozeEnd removes an entry that is still due, a snooze extended meanwhile stays scheduled
+var claimSnoozeEnd = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score and tonumber(score) <= tonumber(ARGV[2]) then
+	return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return 0`)
+
+func snoozeMember(applicationID, userID, notificationID string) string {
+	return applicationID + ":" + userID + ":" + notificationID
+}
+
+// ScheduleSnoozeEnd records when a snoozed notification returns to the inbox, nil cancels it
+func ScheduleSnoozeEnd(ctx context.Context, rdb *redis.Client, applicationID, userID, notificationID string, until *time.Time) {
+	member := snoozeMember(applicationID, userID, notificationID)
+	var err error
+	if until == nil {
+		err = rdb.ZRem(ctx, SnoozesKey, member).Err()
+	} else {
+		err = rdb.ZAdd(ctx, SnoozesKey, redis.Z{Score: float64(until.UnixMilli()), Member: member}).Err()
+	}
+	if err != nil {
+		log.Printf("failed to schedule the snooze end of %s: %v", notificationID, err)
+	}
+}
+
+// WatchSnoozes publishes an unsnoozed event, and the new unread count, when the snooze of a
+// notification ends, until ctx is done. Every node runs it, the node that claims an entry
+// publishes it.
+func WatchSnoozes(ctx context.Context, rdb *redis.Client) {
+	ticker := time.NewTicker(snoozeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			publishSnoozeEnds(ctx, rdb)
+		}
+	}
+}
+
+func publishSnoozeEnds(ctx context.Context, rdb *redis.Client) {
+	now := fmt.Sprint(time.Now().UnixMilli())
+	due, err := rdb.ZRangeByScore(ctx, SnoozesKey, &redis.ZRangeBy{Min: "-inf", Max: now, Count: 100}).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("❌ Failed to read the ended snoozes: %v", err)
+		}
+		return
+	}
+	for _, member := range due {
+		claimed, err := claimSnoozeEnd.Run(ctx, rdb, []string{SnoozesKey}, member, now).Int()
+		if err != nil || claimed == 0 {
+			continue
+		}
+		applicationID, rest, _ := strings.Cut(member, ":")
+		sep := strings.LastIndex(rest, ":")
+		if sep < 0 {
+			continue
+		}
+		PublishInboxChange(ctx, rdb, applicationID, rest[:sep], rest[sep+1:], InboxUnsnoozed, nil)
+	}
+}
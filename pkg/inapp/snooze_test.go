@@ -0,0 +1,78 @@
+package inapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnoozeEndIsPublishedOnceByRacingNodes(t *testing.T) {
+	rdb := testRedis(t)
+	testDB(t)
+	previous := snoozeCheckInterval
+	snoozeCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { snoozeCheckInterval = previous })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	applicationID := fmt.Sprintf("app-%d", time.Now().UnixNano())
+	sub := rdb.PSubscribe(ctx, BroadcastChannel(applicationID+":*"))
+	t.Cleanup(func() { sub.Close() })
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ended := time.Now().Add(-time.Millisecond)
+	later := time.Now().Add(time.Hour)
+	const due = 20
+	for i := 0; i < due; i++ {
+		ScheduleSnoozeEnd(ctx, rdb, applicationID, fmt.Sprintf("user:%d", i), fmt.Sprintf("n-%d", i), &ended)
+	}
+	ScheduleSnoozeEnd(ctx, rdb, applicationID, "user:later", "n-later", &later)
+	laterMember := snoozeMember(applicationID, "user:later", "n-later")
+	t.Cleanup(func() { rdb.ZRem(context.Background(), SnoozesKey, laterMember) })
+
+	// every node watches, both see the same entries due
+	go WatchSnoozes(ctx, rdb)
+	go WatchSnoozes(ctx, rdb)
+
+	// collected until no event arrived for a while, a duplicate would come right after the first
+	published := map[string]int{}
+	messages := sub.Channel()
+	quiet := time.NewTimer(5 * time.Second)
+	defer quiet.Stop()
+collect:
+	for {
+		select {
+		case msg := <-messages:
+			var event Response
+			json.Unmarshal([]byte(msg.Payload), &event)
+			if event.Type != EventUpdated {
+				continue
+			}
+			data, _ := event.Data.(map[string]interface{})
+			if data["action"] != InboxUnsnoozed {
+				t.Fatalf("unexpected event %s", msg.Payload)
+			}
+			id, _ := data["notification_id"].(string)
+			published[id]++
+			quiet.Reset(300 * time.Millisecond)
+		case <-quiet.C:
+			break collect
+		}
+	}
+
+	if len(published) != due {
+		t.Fatalf("%d of %d snooze ends were published", len(published), due)
+	}
+	for id, count := range published {
+		if count != 1 {
+			t.Errorf("the snooze end of %s was published %d times", id, count)
+		}
+	}
+	if published["n-later"] != 0 || rdb.ZScore(ctx, SnoozesKey, laterMember).Err() != nil {
+		t.Fatal("a snooze that hasn't ended was published")
+	}
+}
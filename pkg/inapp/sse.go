@@ -0,0 +1,83 @@
+package inapp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// sseHeartbeat keeps proxies from closing an idle stream and detects clients that are gone
+	sseHeartbeat = 15 * time.Second
+	// sseRetry is how long EventSource waits before reconnecting
+	sseRetry = 3 * time.Second
+)
+
+// ServeSSE streams the broadcasts of a user as server-sent events, for clients behind proxies
+// that break WebSockets. The events carry the same payloads as the WebSocket, notifications use
+// their event_id as the event id so EventSource resumes with Last-Event-ID after a reconnect.
+func (h *Hub) ServeSSE(c *fiber.Ctx, userID, since string) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		client := h.add(userID, nil, since != "")
+		go client.ssePump(w, conn)
+
+		if since != "" {
+			h.replay(client, since)
+		}
+		<-client.done
+		h.Unregister(client)
+	})
+	return nil
+}
+
+// ssePump writes the client's events to the stream, with a comment as heartbeat
+func (c *Client) ssePump(w *bufio.Writer, conn net.Conn) {
+	ticker := time.NewTicker(sseHeartbeat)
+	defer func() {
+		ticker.Stop()
+		close(c.done)
+	}()
+
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	for {
+		// the server's write timeout would otherwise end the stream
+		conn.SetWriteDeadline(time.Now().Add(2 * sseHeartbeat))
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case msg := <-c.Write:
+			writeSSEEvent(w, msg)
+		case <-c.quit:
+			for msg, ok := c.pending(); ok; msg, ok = c.pending() {
+				writeSSEEvent(w, msg)
+			}
+			w.Flush()
+			return
+		case <-ticker.C:
+			w.WriteString(": ping\n\n")
+		}
+	}
+}
+
+// writeSSEEvent writes one event, notifications carry their event_id as the event id
+func writeSSEEvent(w *bufio.Writer, msg []byte) {
+	var event struct {
+		EventID string `json:"event_id"`
+	}
+	if json.Unmarshal(msg, &event) == nil && event.EventID != "" {
+		fmt.Fprintf(w, "id: %s\n", event.EventID)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", msg)
+}
@@ -0,0 +1,168 @@
+package inapp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// serveSSE runs the server-sent events endpoint of a hub
+func serveSSE(t *testing.T, hub *Hub) string {
+	t.Helper()
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/sse", func(c *fiber.Ctx) error {
+		return hub.ServeSSE(c, c.Query("key"), c.Get("Last-Event-ID", c.Query("since")))
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() {
+		// the streams only end with their clients
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		hub.Drain(ctx)
+		app.Shutdown()
+	})
+	return ln.Addr().String()
+}
+
+type sseEvent struct {
+	id   string
+	data map[string]interface{}
+}
+
+// openSSE opens the stream of a user like EventSource reconnecting after lastEventID, and
+// returns its events
+func openSSE(t *testing.T, addr, key, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/sse?key=%s", addr, key), nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.data)
+			case line == "" && event.data != nil:
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return events
+}
+
+func readSSEEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("the stream ended")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+// replayEntry adds a notification to the replay stream of the user and returns its event id
+func replayEntry(t *testing.T, rdb *redis.Client, key, id string) string {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]string{"id": id})
+	eventID, err := rdb.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: UserStream(key),
+		Values: map[string]interface{}{"payload": string(payload)},
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return eventID
+}
+
+// waitClients waits until n clients of the user are registered on the hub
+func waitClients(t *testing.T, hub *Hub, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.mu.RLock()
+		registered := len(hub.clients[key])
+		hub.mu.RUnlock()
+		if registered == n {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expected %d clients for %s", n, key)
+}
+
+func TestSSEResumesAfterLastEventID(t *testing.T) {
+	rdb := testRedis(t)
+	hub := NewHub(context.Background(), rdb)
+	t.Cleanup(func() { hub.Close() })
+	addr := serveSSE(t, hub)
+	key := fmt.Sprintf("app-%d:user", time.Now().UnixNano())
+	t.Cleanup(func() { rdb.Del(context.Background(), UserStream(key)) })
+
+	seen := replayEntry(t, rdb, key, "seen")
+	missed := []string{replayEntry(t, rdb, key, "missed-1"), replayEntry(t, rdb, key, "missed-2")}
+
+	events := openSSE(t, addr, key, seen)
+	for i, eventID := range missed {
+		event := readSSEEvent(t, events)
+		if event.id != eventID || event.data["event_id"] != eventID || event.data["id"] != fmt.Sprintf("missed-%d", i+1) {
+			t.Fatalf("replayed %+v, want the event %s", event, eventID)
+		}
+		if event.data["type"] != MessageNotification {
+			t.Fatalf("unexpected type %v", event.data["type"])
+		}
+	}
+
+	// then live delivery continues, events without an event id have no SSE id
+	waitClients(t, hub, key, 1)
+	live := replayEntry(t, rdb, key, "live")
+	rdb.Publish(context.Background(), BroadcastChannel(key), notificationEvent(map[string]interface{}{"id": "live"}, live))
+	rdb.Publish(context.Background(), BroadcastChannel(key), encodeResponse(Response{Type: EventUnreadCount, Data: map[string]int{"unread_count": 3}}))
+	if event := readSSEEvent(t, events); event.id != live || event.data["id"] != "live" {
+		t.Fatalf("unexpected live event %+v", event)
+	}
+	if event := readSSEEvent(t, events); event.id != "" || event.data["type"] != EventUnreadCount {
+		t.Fatalf("unexpected state event %+v", event)
+	}
+
+	// EventSource reconnects with the id of the last event it got
+	events = openSSE(t, addr, key, live)
+	waitClients(t, hub, key, 2)
+	rdb.Publish(context.Background(), BroadcastChannel(key), notificationEvent(map[string]interface{}{"id": "after"}, ""))
+	if event := readSSEEvent(t, events); event.data["id"] != "after" {
+		t.Fatalf("replayed %+v after the last event", event)
+	}
+}
@@ -0,0 +1,210 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/queue"
+)
+
+const (
+	DefaultWebhookBaseURL = "https://discord.com/api/webhooks"
+
+	// Discord limits for embed fields
+	maxTitleLength       = 256
+	maxDescriptionLength = 4096
+	embedColor           = 0xF97316
+)
+
+// DiscordNotifier posts embeds to Discord webhooks
+type DiscordNotifier struct {
+	webhookBaseURL string
+	httpClient     *http.Client
+
+	// resetAt tracks when an exhausted rate limit bucket resets, per webhook URL
+	mu      sync.Mutex
+	resetAt map[string]time.Time
+}
+
+var DiscordChannel *DiscordNotifier
+
+func NewDiscordNotifier(webhookBaseURL string) error {
+	if webhookBaseURL == "" {
+		webhookBaseURL = DefaultWebhookBaseURL
+	}
+	DiscordChannel = &DiscordNotifier{
+		webhookBaseURL: strings.TrimRight(webhookBaseURL, "/"),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		resetAt:        make(map[string]time.Time),
+	}
+	return nil
+}
+
+type discordEmbed struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Color       int    `json:"color,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+}
+
+type discordPayload struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+// rateLimitResponse is the body Discord returns with HTTP 429
+type rateLimitResponse struct {
+	RetryAfter float64 `json:"retry_after"`
+	Global     bool    `json:"global"`
+}
+
+func (n *DiscordNotifier) Send(ctx context.Context, notification *notification.Notification) error {
+	if notification.Provider != "webhook" {
+		return fmt.Errorf("unknown discord provider: %s", notification.Provider)
+	}
+
+	body, err := buildPayload(notification)
+	if err != nil {
+		return err
+	}
+	return n.sendWebhook(ctx, notification.Recipient, body)
+}
+
+func (n *DiscordNotifier) sendWebhook(ctx context.Context, recipient string, body []byte) error {
+	webhookURL, err := notification.ResolveWebhookURL(n.webhookBaseURL, recipient)
+	if err != nil {
+		return err
+	}
+
+	// Don't spend a request on a bucket Discord already told us is empty
+	if wait := n.waitFor(webhookURL); wait > 0 {
+		return &notification.RateLimitError{Provider: "discord", RetryAfter: wait}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create discord request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send discord webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	n.updateRateLimit(webhookURL, resp.Header)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := notification.ParseRetryAfter(resp.Header.Get("Retry-After"), 0)
+		var limited rateLimitResponse
+		if err := json.Unmarshal(respBody, &limited); err == nil && limited.RetryAfter > 0 {
+			retryAfter = time.Duration(limited.RetryAfter * float64(time.Second))
+		}
+		if retryAfter == 0 {
+			retryAfter = 5 * time.Second
+		}
+		n.setResetAt(webhookURL, time.Now().Add(retryAfter))
+		return &notification.RateLimitError{Provider: "discord", RetryAfter: retryAfter}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("discord webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// updateRateLimit records the bucket reset time once X-RateLimit-Remaining reaches zero
+func (n *DiscordNotifier) updateRateLimit(webhookURL string, header http.Header) {
+	if header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+	resetAfter := notification.ParseRetryAfter(header.Get("X-RateLimit-Reset-After"), 0)
+	if resetAfter > 0 {
+		n.setResetAt(webhookURL, time.Now().Add(resetAfter))
+	}
+}
+
+func (n *DiscordNotifier) setResetAt(webhookURL string, at time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.resetAt[webhookURL] = at
+}
+
+func (n *DiscordNotifier) waitFor(webhookURL string) time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	at, ok := n.resetAt[webhookURL]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(at)
+	if wait <= 0 {
+		delete(n.resetAt, webhookURL)
+		return 0
+	}
+	return wait
+}
+
+// buildPayload creates an embed from the subject and message.
+// With message_content_type "application/json" the message is sent as the raw webhook payload.
+func buildPayload(n *notification.Notification) ([]byte, error) {
+	if n.MessageContentType == "application/json" {
+		if !json.Valid([]byte(n.Message)) {
+			return nil, fmt.Errorf("invalid discord payload: message is not valid JSON")
+		}
+		return []byte(n.Message), nil
+	}
+
+	embed := discordEmbed{
+		Title:       truncate(n.Subject, maxTitleLength),
+		Description: truncate(n.Message, maxDescriptionLength),
+		Color:       embedColor,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+	}
+	return json.Marshal(discordPayload{Embeds: []discordEmbed{embed}})
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+func ProcessDiscordNotifications(notif *queue.QueuedNotification) (*notification.Notification, error) {
+	log.Printf("Processing Discord notification %s", notif.ID)
+	notification := &notification.Notification{
+		ID:                 notif.ID,
+		ApplicationID:      notif.ApplicationID,
+		QueueID:            notif.QueueID,
+		Recipient:          notif.Recipient,
+		Subject:            notif.Subject,
+		Message:            notif.Message,
+		Channel:            notif.Channel,
+		Provider:           notif.Provider,
+		Status:             notif.Status,
+		CreatedAt:          notif.CreatedAt,
+		MessageContentType: notif.MessageContentType,
+		TemplateID:         notif.TemplateID,
+	}
+	if DiscordChannel == nil {
+		return notification, fmt.Errorf("discord notifier is not initialized")
+	}
+	if err := DiscordChannel.Send(context.Background(), notification); err != nil {
+		log.Printf("Error sending Discord notification: %v", err)
+		return notification, err
+	}
+	notification.Status = "sent"
+	return notification, nil
+}
@@ -0,0 +1,105 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+func newStubDiscord(t *testing.T, handler http.HandlerFunc) *int {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	if err := NewDiscordNotifier(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	return &requests
+}
+
+func TestWebhookPostsAnEmbed(t *testing.T) {
+	var payload discordPayload
+	newStubDiscord(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/123/token" {
+			t.Errorf("posted to %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	err := DiscordChannel.Send(context.Background(), &notification.Notification{
+		Provider: "webhook", Recipient: "123/token", Subject: "Deploy finished", Message: "agni is live",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Embeds) != 1 || payload.Embeds[0].Title != "Deploy finished" || payload.Embeds[0].Description != "agni is live" {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+}
+
+func TestRateLimitUsesTheRetryAfterOfTheBody(t *testing.T) {
+	requests := newStubDiscord(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"retry_after": 2.5, "global": false}`))
+	})
+
+	send := func() error {
+		return DiscordChannel.Send(context.Background(), &notification.Notification{Provider: "webhook", Recipient: "123/token", Message: "hi"})
+	}
+	var rateLimitErr *notification.RateLimitError
+	if err := send(); !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 2500*time.Millisecond {
+		t.Fatalf("expected a 2.5s rate limit, got %v", err)
+	}
+
+	// the bucket is known to be empty, no request is spent on it
+	if err := send(); !errors.As(err, &rateLimitErr) || *requests != 1 {
+		t.Fatalf("expected the rate limit without a request, got %v after %d requests", err, *requests)
+	}
+}
+
+func TestExhaustedBucketIsNotPostedTo(t *testing.T) {
+	requests := newStubDiscord(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset-After", "30")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	notif := &notification.Notification{Provider: "webhook", Recipient: "123/token", Message: "hi"}
+	if err := DiscordChannel.Send(context.Background(), notif); err != nil {
+		t.Fatal(err)
+	}
+	err := DiscordChannel.Send(context.Background(), notif)
+	var rateLimitErr *notification.RateLimitError
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter <= 25*time.Second {
+		t.Fatalf("expected to wait for the bucket reset, got %v", err)
+	}
+	if *requests != 1 {
+		t.Fatalf("the exhausted bucket received %d requests", *requests)
+	}
+
+	// other webhooks have their own bucket
+	if err := DiscordChannel.Send(context.Background(), &notification.Notification{Provider: "webhook", Recipient: "456/token", Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookURLsOutsideOfTheBaseURLAreRefused(t *testing.T) {
+	requests := newStubDiscord(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	err := DiscordChannel.Send(context.Background(), &notification.Notification{
+		Provider: "webhook", Recipient: "https://example.com/api/webhooks/123/token", Message: "hi",
+	})
+	if err == nil || *requests != 0 {
+		t.Fatalf("expected the foreign webhook URL to be refused, got %v", err)
+	}
+}
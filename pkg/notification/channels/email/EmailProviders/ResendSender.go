@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/compose"
 	"github.com/resend/resend-go/v2"
 )
 
@@ -31,19 +32,31 @@ func (s *ResendSender) Send(ctx context.Context, notification *notification.Noti
 		return "", fmt.Errorf("resend notifier is not initialized")
 	}
 
+	message, err := compose.FromNotification(ctx, s.from, notification)
+	if err != nil {
+		return "", fmt.Errorf("failed to build email: %w", err)
+	}
+
 	email := &resend.SendEmailRequest{
 		From:    s.from,
-		To:      []string{notification.Recipient},
-		Subject: notification.Subject,
+		To:      message.To,
+		Cc:      message.Cc,
+		Bcc:     message.Bcc,
+		ReplyTo: message.ReplyTo,
+		Subject: message.Subject,
+		Html:    message.HTML,
+		Text:    message.Text,
+		Headers: message.Headers,
+		// lets the webhook map bounces and complaints back to the application
+		Tags: []resend.Tag{{Name: "application_id", Value: notification.ApplicationID}},
 	}
-	switch notification.MessageContentType {
-	case "text/html":
-		email.Html = notification.Message
-	case "text/plain":
-		email.Text = notification.Message
-	default:
-		// Fallback to plain text if not specified
-		email.Text = notification.Message
+	for _, a := range message.Attachments {
+		email.Attachments = append(email.Attachments, &resend.Attachment{
+			Content:     a.Data,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			ContentId:   a.ContentID,
+		})
 	}
 
 	resp, err := s.Client.Emails.Send(email)
@@ -52,6 +65,7 @@ func (s *ResendSender) Send(ctx context.Context, notification *notification.Noti
 	}
 
 	fmt.Printf("Email sent via Resend to %s with subject %s\n", notification.Recipient, notification.Subject)
+	notification.Status = "sent"
 
 	return resp.Id, nil
 }
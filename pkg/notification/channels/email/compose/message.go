@@ -0,0 +1,307 @@
+package compose
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Attachment is a file added to a message. Attachments with a ContentID are
+// placed inline next to the HTML body, everything else is a regular attachment.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	Data        []byte
+}
+
+// Inline reports whether the attachment is referenced from the HTML body
+func (a Attachment) Inline() bool {
+	return a.ContentID != ""
+}
+
+// Message is an email that can be rendered as an RFC 5322 / MIME message
+type Message struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string // only used for the envelope, never written as a header
+	ReplyTo     string
+	Subject     string
+	Text        string
+	HTML        string
+	Headers     map[string]string
+	Attachments []Attachment
+	MessageID   string
+	Date        time.Time
+}
+
+// reservedHeaders can't be overridden through Message.Headers
+var reservedHeaders = map[string]bool{
+	"From": true, "To": true, "Cc": true, "Bcc": true, "Reply-To": true,
+	"Subject": true, "Date": true, "Message-Id": true, "Mime-Version": true,
+	"Content-Type": true, "Content-Transfer-Encoding": true,
+}
+
+// Recipients returns every envelope recipient (To, Cc and Bcc) as bare addresses
+func (m *Message) Recipients() ([]string, error) {
+	var rcpts []string
+	for _, list := range [][]string{m.To, m.Cc, m.Bcc} {
+		for _, raw := range list {
+			addr, err := mail.ParseAddress(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %w", raw, err)
+			}
+			rcpts = append(rcpts, addr.Address)
+		}
+	}
+	return rcpts, nil
+}
+
+// Bytes renders the message with CRLF line endings, ready to be sent over SMTP
+func (m *Message) Bytes() ([]byte, error) {
+	if len(m.To) == 0 {
+		return nil, fmt.Errorf("message has no recipients")
+	}
+
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %w", m.From, err)
+	}
+	if m.MessageID == "" {
+		m.MessageID = newMessageID(from.Address)
+	}
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", from.String())
+	for _, h := range []struct {
+		name string
+		list []string
+	}{{"To", m.To}, {"Cc", m.Cc}} {
+		if len(h.list) == 0 {
+			continue
+		}
+		addrs, err := formatAddressList(h.list)
+		if err != nil {
+			return nil, err
+		}
+		writeHeader(&buf, h.name, addrs)
+	}
+	if m.ReplyTo != "" {
+		replyTo, err := mail.ParseAddress(m.ReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply-to address %q: %w", m.ReplyTo, err)
+		}
+		writeHeader(&buf, "Reply-To", replyTo.String())
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(&buf, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", m.MessageID)
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	// custom headers are written in a stable order
+	names := make([]string, 0, len(m.Headers))
+	for name := range m.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		canonical := textproto.CanonicalMIMEHeaderKey(name)
+		if reservedHeaders[canonical] {
+			return nil, fmt.Errorf("header %s can't be set as a custom header", name)
+		}
+		value := m.Headers[name]
+		if strings.ContainsAny(name, "\r\n: ") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid custom header %q", name)
+		}
+		writeHeader(&buf, name, mime.QEncoding.Encode("utf-8", value))
+	}
+
+	writeEntity(&buf, m.body())
+	return buf.Bytes(), nil
+}
+
+// body builds the MIME tree:
+//
+//	multipart/mixed                   (only with regular attachments)
+//	  multipart/alternative           (only with both text and html)
+//	    text/plain
+//	    multipart/related             (only with inline attachments)
+//	      text/html
+//	      inline attachments
+//	  attachments
+func (m *Message) body() entity {
+	var inline, attached []Attachment
+	for _, a := range m.Attachments {
+		if a.Inline() && m.HTML != "" {
+			inline = append(inline, a)
+		} else {
+			attached = append(attached, a)
+		}
+	}
+
+	var parts []entity
+	if m.Text != "" || m.HTML == "" {
+		parts = append(parts, textEntity("text/plain", m.Text))
+	}
+	if m.HTML != "" {
+		html := textEntity("text/html", m.HTML)
+		if len(inline) > 0 {
+			related := []entity{html}
+			for _, a := range inline {
+				related = append(related, attachmentEntity(a))
+			}
+			html = multipartEntity("related", related)
+		}
+		parts = append(parts, html)
+	}
+
+	body := parts[0]
+	if len(parts) > 1 {
+		body = multipartEntity("alternative", parts)
+	}
+	if len(attached) > 0 {
+		mixed := []entity{body}
+		for _, a := range attached {
+			mixed = append(mixed, attachmentEntity(a))
+		}
+		body = multipartEntity("mixed", mixed)
+	}
+	return body
+}
+
+type entity struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+func textEntity(contentType, content string) entity {
+	var buf bytes.Buffer
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(normalizeNewlines(content)))
+	qp.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return entity{header: header, body: buf.Bytes()}
+}
+
+func attachmentEntity(a Attachment) entity {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(a.Filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	header := textproto.MIMEHeader{}
+	disposition := "attachment"
+	if a.Inline() {
+		disposition = "inline"
+		header.Set("Content-ID", "<"+strings.Trim(a.ContentID, "<>")+">")
+	}
+	if a.Filename != "" {
+		if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+			params["name"] = a.Filename
+			contentType = mime.FormatMediaType(mediaType, params)
+		}
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename})
+	}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Disposition", disposition)
+	header.Set("Content-Transfer-Encoding", "base64")
+	return entity{header: header, body: wrapBase64(a.Data)}
+}
+
+func multipartEntity(subtype string, parts []entity) entity {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		// writing to a bytes.Buffer can't fail
+		pw, _ := w.CreatePart(p.header)
+		pw.Write(p.body)
+	}
+	w.Close()
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/"+subtype, map[string]string{"boundary": w.Boundary()}))
+	return entity{header: header, body: buf.Bytes()}
+}
+
+func writeEntity(buf *bytes.Buffer, e entity) {
+	names := make([]string, 0, len(e.header))
+	for name := range e.header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range e.header[name] {
+			writeHeader(buf, name, value)
+		}
+	}
+	buf.WriteString("\r\n")
+	buf.Write(e.body)
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
+
+func formatAddressList(list []string) (string, error) {
+	formatted := make([]string, 0, len(list))
+	for _, raw := range list {
+		addr, err := mail.ParseAddress(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid address %q: %w", raw, err)
+		}
+		formatted = append(formatted, addr.String())
+	}
+	return strings.Join(formatted, ", "), nil
+}
+
+// wrapBase64 encodes data as base64 split into 76 character lines
+func wrapBase64(data []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76])
+		buf.WriteString("\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded)
+	return buf.Bytes()
+}
+
+func normalizeNewlines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+func newMessageID(fromAddress string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(fromAddress, "@"); at >= 0 {
+		domain = fromAddress[at+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s.%d@%s>", hex.EncodeToString(b), time.Now().UnixNano(), domain)
+}
@@ -0,0 +1,204 @@
+package compose
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/notification/tracking"
+	"github.com/r1i2t3/agni/pkg/notification/unsubscribe"
+)
+
+// MaxAttachmentSize limits the size of a single attachment, inline or downloaded
+const MaxAttachmentSize = 10 << 20
+
+const attachmentTimeout = 15 * time.Second
+
+// attachmentClient downloads the attachment URLs of API callers, from public addresses only
+var attachmentClient = notification.NewPublicHTTPClient(attachmentTimeout, nil)
+
+// AllowAttachmentHosts limits attachment downloads to the given hosts, "*.example.com" matches subdomains
+func AllowAttachmentHosts(hosts []string) {
+	attachmentClient = notification.NewPublicHTTPClient(attachmentTimeout, hosts)
+}
+
+// FromNotification builds the message for an email notification.
+// HTML messages get a plain text alternative, either the one from the request or one derived from the HTML.
+func FromNotification(ctx context.Context, from string, notif *notification.Notification) (*Message, error) {
+	msg := &Message{
+		From:    from,
+		To:      []string{notif.Recipient},
+		Subject: notif.Subject,
+	}
+	if notif.MessageContentType == "text/html" {
+		msg.HTML = notif.Message
+	} else {
+		msg.Text = notif.Message
+	}
+
+	if opts := notif.Email; opts != nil {
+		msg.Cc = opts.Cc
+		msg.Bcc = opts.Bcc
+		msg.ReplyTo = opts.ReplyTo
+		msg.Headers = opts.Headers
+		if opts.TextMessage != "" {
+			msg.Text = opts.TextMessage
+		}
+
+		attachments, err := LoadAttachments(ctx, opts.Attachments)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attachments = attachments
+	}
+	if msg.HTML != "" && msg.Text == "" {
+		msg.Text = HTMLToText(msg.HTML)
+	}
+	if opts := notif.Email; opts != nil && opts.Category != "" {
+		addUnsubscribeHeaders(msg, notif)
+	}
+	if opts := notif.Email; opts != nil && msg.HTML != "" && (opts.TrackOpens || opts.TrackClicks) {
+		if tracking.Tracker != nil {
+			msg.HTML = tracking.Tracker.Instrument(msg.HTML, notif.ID, opts.TrackOpens, opts.TrackClicks)
+		} else {
+			log.Printf("Email tracking requested for %s but tracking is not configured", notif.ID)
+		}
+	}
+	return msg, nil
+}
+
+// addUnsubscribeHeaders adds the RFC 8058 one-click unsubscribe headers unless the sender set its own
+func addUnsubscribeHeaders(msg *Message, notif *notification.Notification) {
+	if unsubscribe.Links == nil {
+		log.Printf("Email %s has category %q but unsubscribe links are not configured", notif.ID, notif.Email.Category)
+		return
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+2)
+	for name, value := range msg.Headers {
+		if strings.EqualFold(name, "List-Unsubscribe") {
+			return
+		}
+		headers[name] = value
+	}
+
+	recipient := notif.Recipient
+	if addr, err := mail.ParseAddress(recipient); err == nil {
+		recipient = addr.Address
+	}
+	claims := unsubscribe.Claims{
+		ApplicationID: notif.ApplicationID,
+		Email:         strings.ToLower(recipient),
+		Category:      notif.Email.Category,
+	}
+	for name, value := range unsubscribe.Links.Headers(claims) {
+		headers[name] = value
+	}
+	msg.Headers = headers
+}
+
+// LoadAttachments decodes base64 attachments and downloads the ones given by URL
+func LoadAttachments(ctx context.Context, requested []notification.EmailAttachment) ([]Attachment, error) {
+	attachments := make([]Attachment, 0, len(requested))
+	for _, a := range requested {
+		attachment := Attachment{
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			ContentID:   a.ContentID,
+		}
+		switch {
+		case a.Content != "":
+			data, err := base64.StdEncoding.DecodeString(a.Content)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %q is not valid base64: %w", a.Filename, err)
+			}
+			if len(data) > MaxAttachmentSize {
+				return nil, fmt.Errorf("attachment %q exceeds %d bytes", a.Filename, MaxAttachmentSize)
+			}
+			attachment.Data = data
+		case a.URL != "":
+			data, contentType, err := downloadAttachment(ctx, a.URL)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %q: %w", a.Filename, err)
+			}
+			attachment.Data = data
+			if attachment.ContentType == "" {
+				attachment.ContentType = contentType
+			}
+			if attachment.Filename == "" {
+				if u, err := url.Parse(a.URL); err == nil {
+					attachment.Filename = path.Base(u.Path)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("attachment %q needs either content or url", a.Filename)
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+func downloadAttachment(ctx context.Context, rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, "", fmt.Errorf("invalid attachment url %q", rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := attachmentClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("download returned HTTP %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxAttachmentSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("download failed: %w", err)
+	}
+	if len(data) > MaxAttachmentSize {
+		return nil, "", fmt.Errorf("download exceeds %d bytes", MaxAttachmentSize)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+var (
+	dropTagsRe   = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	lineBreakRe  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|h[1-6]|li|tr|table)>`)
+	tagRe        = regexp.MustCompile(`<[^>]*>`)
+	blankLinesRe = regexp.MustCompile(`\n\s*\n\s*\n+`)
+	spaceRunRe   = regexp.MustCompile(`[ \t]+`)
+)
+
+// HTMLToText produces a readable plain text version of an HTML body
+func HTMLToText(body string) string {
+	text := dropTagsRe.ReplaceAllString(body, "")
+	text = lineBreakRe.ReplaceAllString(text, "\n")
+	text = tagRe.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = spaceRunRe.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinesRe.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
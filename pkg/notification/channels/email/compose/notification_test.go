@@ -0,0 +1,49 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+func TestAttachmentURLsToInternalAddressesAreRefused(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":"):]
+
+	for _, url := range []string{
+		server.URL + "/report.pdf",                 // 127.0.0.1
+		"http://localhost" + port + "/report.pdf",  // resolved to loopback
+		"http://169.254.169.254/latest/meta-data/", // cloud metadata
+		"http://[::1]" + port + "/report.pdf",      // IPv6 loopback
+		"http://10.0.0.1/report.pdf",               // private
+		"http://0.0.0.0" + port + "/report.pdf",    // unspecified
+	} {
+		_, _, err := downloadAttachment(context.Background(), url)
+		if !errors.Is(err, notification.ErrForbiddenAddress) {
+			t.Errorf("%s: expected the address to be refused, got %v", url, err)
+		}
+	}
+	if requests != 0 {
+		t.Fatalf("the server received %d requests", requests)
+	}
+}
+
+func TestAttachmentHostsCanBeRestricted(t *testing.T) {
+	AllowAttachmentHosts([]string{"*.example.com"})
+	defer AllowAttachmentHosts(nil)
+
+	_, _, err := downloadAttachment(context.Background(), "https://files.example.org/report.pdf")
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected the host to be refused, got %v", err)
+	}
+}
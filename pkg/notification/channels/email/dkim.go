@@ -0,0 +1,87 @@
+package email
+
+import (
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/dkim"
+	"gorm.io/gorm"
+)
+
+// dkimCacheTTL bounds how long a per-application key (or its absence) is cached
+const dkimCacheTTL = 5 * time.Minute
+
+type cachedSigner struct {
+	signer   *dkim.Signer
+	loadedAt time.Time
+}
+
+var appSigners sync.Map // application id -> cachedSigner
+
+// ForgetDKIMSigner drops the cached signer of an application after its key changed
+func ForgetDKIMSigner(applicationID string) {
+	appSigners.Delete(applicationID)
+}
+
+// signerFor returns the application's own DKIM signer, falling back to the global one
+func (n *EmailNotifier) signerFor(applicationID string) *dkim.Signer {
+	if applicationID == "" {
+		return n.dkim
+	}
+	if cached, ok := appSigners.Load(applicationID); ok {
+		entry := cached.(cachedSigner)
+		if time.Since(entry.loadedAt) < dkimCacheTTL {
+			if entry.signer != nil {
+				return entry.signer
+			}
+			return n.dkim
+		}
+	}
+
+	var signer *dkim.Signer
+	key, err := db.GetDKIMKey(applicationID)
+	switch {
+	case err == nil && !n.AlignsWithFrom(key.Domain):
+		// the From address changed since the key was saved, receivers would fail DMARC
+		log.Printf("DKIM key of application %s signs for %s which doesn't align with the From domain %s, using the global key",
+			applicationID, key.Domain, n.FromDomain())
+	case err == nil:
+		signer, err = dkim.NewSigner(key.Domain, key.Selector, []byte(key.PrivateKey))
+		if err != nil {
+			log.Printf("Invalid DKIM key for application %s: %v", applicationID, err)
+		}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		// don't cache lookup failures, the next email retries the lookup
+		log.Printf("Failed to load DKIM key for application %s: %v", applicationID, err)
+		return n.dkim
+	}
+
+	appSigners.Store(applicationID, cachedSigner{signer: signer, loadedAt: time.Now()})
+	if signer != nil {
+		return signer
+	}
+	return n.dkim
+}
+
+// FromDomain returns the domain of the From address email is sent with
+func (n *EmailNotifier) FromDomain() string {
+	address, err := mail.ParseAddress(n.from)
+	if err != nil {
+		return ""
+	}
+	_, domain, _ := strings.Cut(address.Address, "@")
+	return strings.ToLower(domain)
+}
+
+// AlignsWithFrom reports whether signatures with d=domain pass DMARC's relaxed alignment with
+// the From domain, the domains are equal or one is a subdomain of the other
+func (n *EmailNotifier) AlignsWithFrom(domain string) bool {
+	from := n.FromDomain()
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return from != "" && (from == domain || strings.HasSuffix(from, "."+domain) || strings.HasSuffix(domain, "."+from))
+}
@@ -0,0 +1,235 @@
+// Package dkim signs outgoing messages with DKIM (RFC 6376) using relaxed/relaxed
+// canonicalization and rsa-sha256 or ed25519-sha256 (RFC 8463) keys.
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultHeaders are signed when present in the message
+var DefaultHeaders = []string{
+	"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+type Signer struct {
+	Domain   string
+	Selector string
+	Headers  []string
+	key      crypto.Signer
+}
+
+// NewSigner parses a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key
+func NewSigner(domain, selector string, keyPEM []byte) (*Signer, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("dkim domain and selector are required")
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{Domain: domain, Selector: selector, Headers: DefaultHeaders, key: key}, nil
+}
+
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("dkim private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dkim private key: %w", err)
+	}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported dkim key type %T", parsed)
+	}
+}
+
+// Algorithm returns the a= tag value for the signer's key
+func (s *Signer) Algorithm() string {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// DNSRecord returns the TXT record to publish at <selector>._domainkey.<domain>
+func (s *Signer) DNSRecord() (string, error) {
+	switch pub := s.key.Public().(type) {
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub), nil
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	default:
+		return "", fmt.Errorf("unsupported dkim key type %T", pub)
+	}
+}
+
+// Sign returns msg with a DKIM-Signature header prepended. msg must use CRLF line endings.
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body))
+
+	// sign each listed header that is present, bottom-most instance first
+	var signed []string
+	var canonical bytes.Buffer
+	used := make(map[string]int)
+	for _, name := range s.Headers {
+		key := strings.ToLower(name)
+		h, ok := lastHeader(headers, key, used[key])
+		if !ok {
+			continue
+		}
+		used[key]++
+		signed = append(signed, name)
+		canonical.WriteString(canonicalHeader(h))
+		canonical.WriteString("\r\n")
+	}
+	if len(signed) == 0 {
+		return nil, errors.New("message has none of the headers to sign")
+	}
+
+	// the header is folded before signing so the verifier sees exactly what was signed
+	sigHeader := foldHeader(fmt.Sprintf("DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		s.Algorithm(), s.Domain, s.Selector, time.Now().Unix(),
+		strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:])))
+	canonical.WriteString(canonicalHeader(sigHeader))
+
+	signature, err := s.sign(canonical.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigHeader)
+	encoded := base64.StdEncoding.EncodeToString(signature)
+	for len(encoded) > 0 {
+		n := min(len(encoded), 72)
+		out.WriteString("\r\n\t")
+		out.WriteString(encoded[:n])
+		encoded = encoded[n:]
+	}
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+func (s *Signer) sign(data []byte) ([]byte, error) {
+	hashed := sha256.Sum256(data)
+	if key, ok := s.key.(ed25519.PrivateKey); ok {
+		// RFC 8463 signs the SHA-256 hash with PureEdDSA
+		return ed25519.Sign(key, hashed[:]), nil
+	}
+	return s.key.Sign(rand.Reader, hashed[:], crypto.SHA256)
+}
+
+// splitMessage returns the raw header fields, continuation lines included, and the body
+func splitMessage(msg []byte) ([]string, []byte, error) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	if end < 0 {
+		return nil, nil, errors.New("message has no header/body separator")
+	}
+	var headers []string
+	for _, line := range strings.Split(string(msg[:end]), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1] += "\r\n" + line
+			continue
+		}
+		headers = append(headers, line)
+	}
+	return headers, msg[end+4:], nil
+}
+
+func countHeaders(headers []string, key string) []int {
+	var idx []int
+	for i, h := range headers {
+		if name, _, ok := strings.Cut(h, ":"); ok && strings.ToLower(strings.TrimSpace(name)) == key {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// lastHeader returns the skip-th instance of a header counting from the bottom
+func lastHeader(headers []string, key string, skip int) (string, bool) {
+	idx := countHeaders(headers, key)
+	if skip < 0 || skip >= len(idx) {
+		return "", false
+	}
+	return headers[idx[len(idx)-1-skip]], true
+}
+
+var wspRe = regexp.MustCompile(`[ \t]+`)
+
+// canonicalHeader implements the relaxed header canonicalization
+func canonicalHeader(h string) string {
+	name, value, _ := strings.Cut(h, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = wspRe.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value)
+}
+
+// canonicalBody implements the relaxed body canonicalization
+func canonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = wspRe.ReplaceAllString(line, " ")
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// foldHeader wraps the header at spaces so lines stay under 78 characters where possible
+func foldHeader(h string) string {
+	var out strings.Builder
+	lineLen := 0
+	for i, part := range strings.Split(h, " ") {
+		if i > 0 {
+			if lineLen+1+len(part) > 76 {
+				out.WriteString("\r\n\t")
+				lineLen = 1
+			} else {
+				out.WriteString(" ")
+				lineLen++
+			}
+		}
+		out.WriteString(part)
+		lineLen += len(part)
+	}
+	return out.String()
+}
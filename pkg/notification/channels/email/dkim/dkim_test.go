@@ -0,0 +1,99 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/compose"
+)
+
+func testMessage(t *testing.T) []byte {
+	msg := &compose.Message{
+		From:    "Agni <noreply@example.com>",
+		To:      []string{"Jöhn <john@example.org>"},
+		Subject: "Welcome to   Agni  ✓",
+		HTML:    "<p>Hello <b>John</b></p>",
+		Text:    "Hello John  \n\n",
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.com/u/abc>"},
+		Attachments: []compose.Attachment{
+			{Filename: "report.txt", Data: bytes.Repeat([]byte("agni "), 100)},
+		},
+	}
+	b, err := msg.Bytes()
+	if err != nil {
+		t.Fatalf("compose message: %v", err)
+	}
+	return b
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := map[string][]byte{
+		"rsa-sha256":     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"ed25519-sha256": pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}),
+	}
+
+	for algorithm, keyPEM := range keys {
+		t.Run(algorithm, func(t *testing.T) {
+			signer, err := NewSigner("example.com", "agni", keyPEM)
+			if err != nil {
+				t.Fatalf("new signer: %v", err)
+			}
+			if signer.Algorithm() != algorithm {
+				t.Fatalf("expected algorithm %s, got %s", algorithm, signer.Algorithm())
+			}
+			record, err := signer.DNSRecord()
+			if err != nil {
+				t.Fatal(err)
+			}
+			lookup := func(domain, selector string) (string, error) {
+				if domain != "example.com" || selector != "agni" {
+					t.Errorf("unexpected key lookup %s._domainkey.%s", selector, domain)
+				}
+				return record, nil
+			}
+
+			signed, err := signer.Sign(testMessage(t))
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+			if err := verify(signed, lookup); err != nil {
+				t.Fatalf("verify signed message: %v", err)
+			}
+
+			// relaxed canonicalization tolerates whitespace changes in headers and trailing blank lines
+			relaxed := bytes.Replace(signed, []byte("Subject: "), []byte("subject:    "), 1)
+			relaxed = append(relaxed, "\r\n\r\n"...)
+			if err := verify(relaxed, lookup); err != nil {
+				t.Errorf("verify after whitespace changes: %v", err)
+			}
+
+			tamperedBody := bytes.Replace(signed, []byte("YWduaSBhZ25p"), []byte("YWduaSBhZ25q"), 1)
+			if err := verify(tamperedBody, lookup); err == nil {
+				t.Error("expected verification to fail after changing the body")
+			}
+
+			tamperedHeader := bytes.Replace(signed, []byte("noreply@example.com"), []byte("attacker@example.com"), 1)
+			if err := verify(tamperedHeader, lookup); err == nil {
+				t.Error("expected verification to fail after changing the From header")
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// verify checks the first DKIM-Signature of msg, the way a receiving server does. lookup returns the TXT record for a selector and domain.
+func verify(msg []byte, lookup func(domain, selector string) (string, error)) error {
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return err
+	}
+	sigHeader, ok := lastHeader(headers, "dkim-signature", len(countHeaders(headers, "dkim-signature"))-1)
+	if !ok {
+		return errors.New("message is not signed")
+	}
+	tags := parseTags(sigHeader[strings.Index(sigHeader, ":")+1:])
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return errors.New("unsupported dkim signature version or canonicalization")
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body))
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("dkim body hash mismatch")
+	}
+
+	var canonical bytes.Buffer
+	used := make(map[string]int)
+	for _, name := range strings.Split(tags["h"], ":") {
+		key := strings.ToLower(strings.TrimSpace(name))
+		h, ok := lastHeader(headers, key, used[key])
+		if !ok {
+			continue
+		}
+		used[key]++
+		canonical.WriteString(canonicalHeader(h))
+		canonical.WriteString("\r\n")
+	}
+	// the signature header itself is signed with an empty b= value
+	canonical.WriteString(canonicalHeader(bTagRe.ReplaceAllString(sigHeader, "${1}")))
+
+	record, err := lookup(tags["d"], tags["s"])
+	if err != nil {
+		return fmt.Errorf("dkim key lookup failed: %w", err)
+	}
+	keyTags := parseTags(record)
+	pubDER, err := base64.StdEncoding.DecodeString(keyTags["p"])
+	if err != nil {
+		return fmt.Errorf("invalid dkim public key: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("invalid dkim signature: %w", err)
+	}
+
+	hashed := sha256.Sum256(canonical.Bytes())
+	switch tags["a"] {
+	case "ed25519-sha256":
+		if len(pubDER) != ed25519.PublicKeySize || !ed25519.Verify(ed25519.PublicKey(pubDER), hashed[:], signature) {
+			return errors.New("dkim signature verification failed")
+		}
+	case "rsa-sha256":
+		pub, err := x509.ParsePKIXPublicKey(pubDER)
+		if err != nil {
+			return fmt.Errorf("invalid dkim public key: %w", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("dkim public key is not an RSA key")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaPub, crypto.SHA256, hashed[:], signature); err != nil {
+			return errors.New("dkim signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported dkim algorithm %q", tags["a"])
+	}
+	return nil
+}
+
+// bTagRe matches the b= tag but not bh=
+var bTagRe = regexp.MustCompile(`((?:^|;)\s*b=)[^;]*`)
+
+// parseTags splits a tag=value list, folding whitespace is removed from the values
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		value = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, value)
+		tags[strings.TrimSpace(key)] = value
+	}
+	return tags
+}
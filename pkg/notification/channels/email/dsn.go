@@ -0,0 +1,103 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// DSNRecipient is the per-recipient part of a delivery status notification (RFC 3464)
+type DSNRecipient struct {
+	Email          string
+	Action         string // failed, delayed, delivered, relayed, expanded
+	Status         string // e.g. 5.1.1
+	DiagnosticCode string
+}
+
+// Permanent reports whether the delivery failed for good (a hard bounce)
+func (r DSNRecipient) Permanent() bool {
+	return r.Action == "failed" && strings.HasPrefix(r.Status, "5.")
+}
+
+// ParseDSN extracts the recipients of a multipart/report bounce message
+func ParseDSN(raw []byte) ([]DSNRecipient, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bounce message: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return nil, errors.New("bounce message is not a multipart/report")
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("bounce message has no delivery-status part")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid bounce message: %w", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType == "message/delivery-status" || partType == "message/global-delivery-status" {
+			return parseDeliveryStatus(part)
+		}
+	}
+}
+
+// parseDeliveryStatus reads the per-message block followed by one block per recipient
+func parseDeliveryStatus(r io.Reader) ([]DSNRecipient, error) {
+	tp := textproto.NewReader(bufio.NewReader(r))
+
+	// per-message fields aren't needed
+	if _, err := tp.ReadMIMEHeader(); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid delivery-status: %w", err)
+	}
+
+	var recipients []DSNRecipient
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if len(fields) > 0 {
+			recipient := DSNRecipient{
+				Email:          dsnAddress(fields.Get("Final-Recipient")),
+				Action:         strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+				Status:         strings.TrimSpace(fields.Get("Status")),
+				DiagnosticCode: strings.TrimSpace(fields.Get("Diagnostic-Code")),
+			}
+			if recipient.Email == "" {
+				recipient.Email = dsnAddress(fields.Get("Original-Recipient"))
+			}
+			if recipient.Email != "" {
+				recipients = append(recipients, recipient)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid delivery-status: %w", err)
+		}
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("delivery-status has no recipients")
+	}
+	return recipients, nil
+}
+
+// dsnAddress returns the address of an "rfc822; user@example.com" field
+func dsnAddress(field string) string {
+	if _, address, ok := strings.Cut(field, ";"); ok {
+		field = address
+	}
+	return NormalizeAddress(field)
+}
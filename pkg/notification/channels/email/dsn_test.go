@@ -0,0 +1,137 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+// postfixBounce is a hard bounce as Postfix sends it, with a folded diagnostic
+const postfixBounce = `From: MAILER-DAEMON@mail.example.com (Mail Delivery System)
+Subject: Undelivered Mail Returned to Sender
+To: bounces@example.com
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status;
+	boundary="8F2A1C0E21.1700000000/mail.example.com"
+
+--8F2A1C0E21.1700000000/mail.example.com
+Content-Description: Notification
+Content-Type: text/plain; charset=us-ascii
+
+I'm sorry to have to inform you that your message could not
+be delivered to one or more recipients.
+
+--8F2A1C0E21.1700000000/mail.example.com
+Content-Description: Delivery report
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mail.example.com
+X-Postfix-Queue-ID: 8F2A1C0E21
+Arrival-Date: Tue, 14 Nov 2023 22:13:20 +0000 (UTC)
+
+Final-Recipient: rfc822; Missing.User@Gmail.com
+Original-Recipient: rfc822;Missing.User@Gmail.com
+Action: failed
+Status: 5.1.1
+Remote-MTA: dns; gmail-smtp-in.l.google.com
+Diagnostic-Code: smtp; 550-5.1.1 The email account that you tried to reach does
+    not exist. Please try double-checking the recipient's email address
+
+Final-Recipient: rfc822; full@example.org
+Action: delayed
+Status: 4.2.2
+Diagnostic-Code: smtp; 452 4.2.2 Mailbox full
+
+--8F2A1C0E21.1700000000/mail.example.com
+Content-Description: Undelivered Message Headers
+Content-Type: text/rfc822-headers
+
+Subject: Welcome
+--8F2A1C0E21.1700000000/mail.example.com--
+`
+
+// exchangeBounce reports the recipient in Original-Recipient only, in a global delivery status
+const exchangeBounce = `From: postmaster@outlook.com
+To: bounces@example.com
+Content-Type: multipart/report; report-type=delivery-status; boundary="b1"
+
+--b1
+Content-Type: text/plain
+
+Delivery has failed.
+--b1
+Content-Type: message/global-delivery-status
+
+Reporting-MTA: dns;AM0PR01MB0000.eurprd01.prod.outlook.com
+
+Original-Recipient: rfc822;gone@outlook.com
+Action: failed
+Status: 5.2.1
+Diagnostic-Code: smtp;550 5.2.1 The email account is disabled
+--b1--
+`
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		want      []DSNRecipient
+		permanent []bool
+	}{
+		{
+			name: "postfix",
+			raw:  postfixBounce,
+			want: []DSNRecipient{
+				{Email: "missing.user@gmail.com", Action: "failed", Status: "5.1.1"},
+				{Email: "full@example.org", Action: "delayed", Status: "4.2.2", DiagnosticCode: "smtp; 452 4.2.2 Mailbox full"},
+			},
+			permanent: []bool{true, false},
+		},
+		{
+			name:      "exchange",
+			raw:       exchangeBounce,
+			want:      []DSNRecipient{{Email: "gone@outlook.com", Action: "failed", Status: "5.2.1", DiagnosticCode: "smtp;550 5.2.1 The email account is disabled"}},
+			permanent: []bool{true},
+		},
+	}
+	for _, test := range tests {
+		recipients, err := ParseDSN([]byte(strings.ReplaceAll(test.raw, "\n", "\r\n")))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(recipients) != len(test.want) {
+			t.Fatalf("%s: got %+v", test.name, recipients)
+		}
+		for i, want := range test.want {
+			got := recipients[i]
+			if got.Email != want.Email || got.Action != want.Action || got.Status != want.Status {
+				t.Errorf("%s: recipient %d is %+v, want %+v", test.name, i, got, want)
+			}
+			if want.DiagnosticCode != "" && got.DiagnosticCode != want.DiagnosticCode {
+				t.Errorf("%s: diagnostic %q, want %q", test.name, got.DiagnosticCode, want.DiagnosticCode)
+			}
+			if got.Permanent() != test.permanent[i] {
+				t.Errorf("%s: %s permanent = %v", test.name, got.Email, got.Permanent())
+			}
+		}
+	}
+
+	// the folded diagnostic is read as one value
+	recipients, _ := ParseDSN([]byte(postfixBounce))
+	if !strings.Contains(recipients[0].DiagnosticCode, "does not exist") {
+		t.Fatalf("diagnostic %q", recipients[0].DiagnosticCode)
+	}
+}
+
+func TestParseDSNRejectsOtherMessages(t *testing.T) {
+	for name, raw := range map[string]string{
+		"not a report":       "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nhello\r\n",
+		"no delivery status": "Content-Type: multipart/report; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nhi\r\n--b--\r\n",
+		"no recipients": "Content-Type: multipart/report; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: message/delivery-status\r\n\r\n" +
+			"Reporting-MTA: dns; mail.example.com\r\n\r\nAction: failed\r\nStatus: 5.1.1\r\n--b--\r\n",
+		"not a message": "",
+	} {
+		if recipients, err := ParseDSN([]byte(raw)); err == nil {
+			t.Errorf("%s: expected an error, got %+v", name, recipients)
+		}
+	}
+}
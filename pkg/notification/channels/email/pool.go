@@ -0,0 +1,290 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"time"
+)
+
+// TLS modes of the SMTP connection
+const (
+	TLSModeStartTLS = "starttls" // plain connection upgraded with STARTTLS, usually port 587
+	TLSModeImplicit = "tls"      // TLS from the first byte, usually port 465
+	TLSModeNone     = "none"     // no encryption, only for local relays
+)
+
+// Auth methods supported for SMTP
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+type pooledClient struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+// smtpPool keeps authenticated SMTP sessions open so workers can reuse them.
+// At most size sessions are open at the same time, idle ones are closed after idleTimeout.
+type smtpPool struct {
+	addr        string
+	host        string
+	tlsMode     string
+	auth        smtp.Auth
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+	idleTimeout time.Duration
+	// commandTimeout bounds the RSET and QUIT sent outside of a delivery
+	commandTimeout time.Duration
+
+	slots chan struct{}
+	mu    sync.Mutex
+	idle  []*pooledClient
+}
+
+func newSMTPPool(host, port, tlsMode string, auth smtp.Auth, size int, idleTimeout time.Duration) *smtpPool {
+	if size <= 0 {
+		size = 1
+	}
+	return &smtpPool{
+		addr:           net.JoinHostPort(host, port),
+		host:           host,
+		tlsMode:        tlsMode,
+		auth:           auth,
+		tlsConfig:      &tls.Config{ServerName: host},
+		dialTimeout:    10 * time.Second,
+		idleTimeout:    idleTimeout,
+		slots:          make(chan struct{}, size),
+		commandTimeout: 5 * time.Second,
+	}
+}
+
+// send delivers one message, retrying once on a fresh connection when a reused one turned out to be dead.
+// It gives up when ctx is done, also in the middle of the SMTP conversation.
+func (p *smtpPool) send(ctx context.Context, from string, to []string, msg []byte) error {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	pc, reused, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = pc.deliver(ctx, from, to, msg)
+	if err != nil && reused && ctx.Err() == nil && (!isSMTPReply(err) || isServiceClosing(err)) {
+		pc.client.Close()
+		if pc, err = p.dial(ctx); err != nil {
+			return err
+		}
+		err = pc.deliver(ctx, from, to, msg)
+	}
+	if err != nil {
+		// the session state is unknown after a failure, don't reuse it
+		pc.client.Close()
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		return err
+	}
+	p.put(pc)
+	return nil
+}
+
+// deliver sends the message within the deadline of ctx, cancelling ctx interrupts the connection
+func (pc *pooledClient) deliver(ctx context.Context, from string, to []string, msg []byte) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { pc.conn.SetDeadline(time.Now()) })
+	err := deliver(pc.client, from, to, msg)
+	if stop() {
+		pc.conn.SetDeadline(time.Time{})
+	}
+	return err
+}
+
+func (p *smtpPool) get(ctx context.Context) (*pooledClient, bool, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		pc := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.idleTimeout > 0 && time.Since(pc.lastUsed) > p.idleTimeout {
+			pc.client.Close()
+			continue
+		}
+		p.mu.Unlock()
+		return pc, true, nil
+	}
+	p.mu.Unlock()
+
+	pc, err := p.dial(ctx)
+	return pc, false, err
+}
+
+// put keeps the session for the next delivery, a server that doesn't answer RSET in time is hung up on
+func (p *smtpPool) put(pc *pooledClient) {
+	pc.conn.SetDeadline(time.Now().Add(p.commandTimeout))
+	if err := pc.client.Reset(); err != nil {
+		pc.client.Close()
+		return
+	}
+	pc.conn.SetDeadline(time.Time{})
+	pc.lastUsed = time.Now()
+	p.mu.Lock()
+	p.idle = append(p.idle, pc)
+	p.mu.Unlock()
+}
+
+func (p *smtpPool) dial(ctx context.Context) (*pooledClient, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: p.dialTimeout, KeepAlive: 30 * time.Second}
+	tlsConfig := p.tlsConfig
+
+	if p.tlsMode == TLSModeImplicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", p.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", p.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server %s: %w", p.addr, err)
+	}
+	// the greeting, STARTTLS and AUTH happen within the deadline of ctx too
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	client, err := smtp.NewClient(conn, p.host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+
+	if p.tlsMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", p.addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if p.auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(p.auth); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("SMTP authentication failed: %w", err)
+			}
+		}
+	}
+	return &pooledClient{conn: conn, client: client, lastUsed: time.Now()}, nil
+}
+
+// close quits every idle session
+func (p *smtpPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, pc := range idle {
+		pc.conn.SetDeadline(time.Now().Add(p.commandTimeout))
+		pc.client.Quit()
+	}
+}
+
+func deliver(client *smtp.Client, from string, to []string, msg []byte) error {
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// isSMTPReply reports whether err is a reply from the server rather than a broken connection
+func isSMTPReply(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr)
+}
+
+// isServiceClosing reports whether the server answered 421, it closes the session: an idle session
+// the server timed out, or a server shutting down
+func isServiceClosing(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code == 421
+}
+
+// loginAuth implements the non standard but widely used AUTH LOGIN mechanism
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:", "User Name\x00":
+		return []byte(a.username), nil
+	case "Password:", "Password\x00":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+func newSMTPAuth(method, username, password, host string) (smtp.Auth, error) {
+	if username == "" || method == AuthNone {
+		return nil, nil
+	}
+	switch method {
+	case AuthPlain, "":
+		return smtp.PlainAuth("", username, password, host), nil
+	case AuthLogin:
+		return &loginAuth{username: username, password: password, host: host}, nil
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(username, password), nil
+	default:
+		return nil, fmt.Errorf("unknown SMTP auth method: %s", method)
+	}
+}
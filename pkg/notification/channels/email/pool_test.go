@@ -0,0 +1,329 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is an SMTP server speaking just enough of the protocol for the pool
+type fakeSMTP struct {
+	t        *testing.T
+	listener net.Listener
+	tls      *tls.Config
+	implicit bool // TLS from the first byte instead of STARTTLS
+	username string
+	password string
+
+	mu       sync.Mutex
+	sessions int
+	auths    []string // the mechanisms clients authenticated with
+	messages []string
+	// reply answers a command instead of the default, "" keeps the default and "hang" never answers
+	reply func(session int, command string) string
+}
+
+func newFakeSMTP(t *testing.T, implicit bool) *fakeSMTP {
+	server := &fakeSMTP{t: t, tls: testTLSConfig(t), implicit: implicit, username: "agni", password: "secret"}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.listener = listener
+	t.Cleanup(func() { listener.Close() })
+	go server.serve()
+	return server
+}
+
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+// pool connects to the server, trusting its certificate
+func (s *fakeSMTP) pool(tlsMode, authMethod string, size int) *smtpPool {
+	auth, err := newSMTPAuth(authMethod, s.username, s.password, "127.0.0.1")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	pool := newSMTPPool("127.0.0.1", s.port(), tlsMode, auth, size, time.Minute)
+	pool.tlsConfig.RootCAs = x509.NewCertPool()
+	pool.tlsConfig.RootCAs.AddCert(s.tls.Certificates[0].Leaf)
+	s.t.Cleanup(pool.close)
+	return pool
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.sessions++
+		session := s.sessions
+		s.mu.Unlock()
+		if s.implicit {
+			conn = tls.Server(conn, s.tls)
+		}
+		go s.session(session, conn)
+	}
+}
+
+func (s *fakeSMTP) session(session int, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	_, secure := conn.(*tls.Conn)
+	write := func(lines ...string) {
+		fmt.Fprint(conn, strings.Join(lines, "\r\n")+"\r\n")
+	}
+	readLine := func() (string, bool) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+
+	write("220 fake ESMTP")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " ")[0])
+		if s.reply != nil {
+			switch reply := s.reply(session, command); reply {
+			case "":
+			case "hang":
+				readLine()
+				return
+			default:
+				write(reply)
+				if strings.HasPrefix(reply, "421") {
+					return
+				}
+				continue
+			}
+		}
+
+		switch command {
+		case "EHLO":
+			extensions := []string{"250-fake"}
+			if !secure && !s.implicit {
+				extensions = append(extensions, "250-STARTTLS")
+			}
+			write(append(extensions, "250-AUTH PLAIN LOGIN CRAM-MD5", "250 8BITMIME")...)
+		case "STARTTLS":
+			write("220 go ahead")
+			tlsConn := tls.Server(conn, s.tls)
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			mechanism, ok := s.authenticate(line, write, readLine)
+			if !ok {
+				write("535 authentication failed")
+				continue
+			}
+			s.mu.Lock()
+			s.auths = append(s.auths, mechanism)
+			s.mu.Unlock()
+			write("235 authenticated")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			write("250 ok")
+		case "DATA":
+			write("354 go ahead")
+			var message strings.Builder
+			for {
+				line, ok := readLine()
+				if !ok || line == "." {
+					break
+				}
+				message.WriteString(line + "\n")
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, message.String())
+			s.mu.Unlock()
+			write("250 queued")
+		case "QUIT":
+			write("221 bye")
+			return
+		default:
+			write("502 unknown command")
+		}
+	}
+}
+
+// authenticate runs the AUTH exchange of the mechanism in line
+func (s *fakeSMTP) authenticate(line string, write func(...string), readLine func() (string, bool)) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", false
+	}
+	decode := func(value string) string {
+		decoded, _ := base64.StdEncoding.DecodeString(value)
+		return string(decoded)
+	}
+	prompt := func(challenge string) string {
+		write("334 " + base64.StdEncoding.EncodeToString([]byte(challenge)))
+		response, _ := readLine()
+		return decode(response)
+	}
+
+	mechanism := strings.ToUpper(fields[1])
+	switch mechanism {
+	case "PLAIN":
+		var response string
+		if len(fields) > 2 {
+			response = decode(fields[2])
+		} else {
+			response = prompt("")
+		}
+		return mechanism, response == "\x00"+s.username+"\x00"+s.password
+	case "LOGIN":
+		username := prompt("Username:")
+		password := prompt("Password:")
+		return mechanism, username == s.username && password == s.password
+	case "CRAM-MD5":
+		challenge := "<1896.697170952@fake>"
+		mac := hmac.New(md5.New, []byte(s.password))
+		mac.Write([]byte(challenge))
+		return mechanism, prompt(challenge) == s.username+" "+hex.EncodeToString(mac.Sum(nil))
+	}
+	return mechanism, false
+}
+
+func (s *fakeSMTP) counts() (sessions, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions, len(s.messages)
+}
+
+// testTLSConfig is a self-signed certificate for 127.0.0.1
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake smtp"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}}}
+}
+
+func sendTestMessage(t *testing.T, pool *smtpPool) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg := []byte("Subject: hi\r\n\r\nhello\r\n")
+	return pool.send(ctx, "noreply@example.com", []string{"user@example.com"}, msg)
+}
+
+func TestTLSModesAndAuthMethods(t *testing.T) {
+	for _, tlsMode := range []string{TLSModeStartTLS, TLSModeImplicit, TLSModeNone} {
+		for _, authMethod := range []string{AuthPlain, AuthLogin, AuthCRAMMD5} {
+			t.Run(tlsMode+"/"+authMethod, func(t *testing.T) {
+				server := newFakeSMTP(t, tlsMode == TLSModeImplicit)
+				if err := sendTestMessage(t, server.pool(tlsMode, authMethod, 1)); err != nil {
+					t.Fatal(err)
+				}
+				if _, messages := server.counts(); messages != 1 {
+					t.Fatalf("the server received %d messages", messages)
+				}
+				if want := strings.ToUpper(authMethod); len(server.auths) != 1 || server.auths[0] != want {
+					t.Fatalf("authenticated with %v, want %s", server.auths, want)
+				}
+			})
+		}
+	}
+}
+
+func TestWrongCredentialsFail(t *testing.T) {
+	server := newFakeSMTP(t, false)
+	pool := server.pool(TLSModeStartTLS, AuthPlain, 1)
+	server.password = "changed"
+
+	if err := sendTestMessage(t, pool); err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("expected the authentication to fail, got %v", err)
+	}
+}
+
+func TestSessionsAreReused(t *testing.T) {
+	server := newFakeSMTP(t, false)
+	pool := server.pool(TLSModeStartTLS, AuthPlain, 1)
+
+	for i := 0; i < 3; i++ {
+		if err := sendTestMessage(t, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sessions, messages := server.counts(); sessions != 1 || messages != 3 {
+		t.Fatalf("%d sessions delivered %d messages, want 1 session", sessions, messages)
+	}
+}
+
+func TestClosedIdleSessionIsRetriedOnANewConnection(t *testing.T) {
+	server := newFakeSMTP(t, false)
+	server.reply = func(session int, command string) string {
+		// the first session timed out while it was idle
+		if _, messages := server.counts(); session == 1 && command == "MAIL" && messages == 1 {
+			return "421 4.4.2 idle timeout, closing connection"
+		}
+		return ""
+	}
+	pool := server.pool(TLSModeStartTLS, AuthPlain, 1)
+
+	for i := 0; i < 2; i++ {
+		if err := sendTestMessage(t, pool); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+	if sessions, messages := server.counts(); sessions != 2 || messages != 2 {
+		t.Fatalf("%d sessions delivered %d messages, want 2 each", sessions, messages)
+	}
+}
+
+func TestServerNotAnsweringRSETLosesTheSession(t *testing.T) {
+	server := newFakeSMTP(t, false)
+	server.reply = func(session int, command string) string {
+		if session == 1 && command == "RSET" {
+			return "hang"
+		}
+		return ""
+	}
+	pool := server.pool(TLSModeStartTLS, AuthPlain, 1)
+	pool.commandTimeout = 100 * time.Millisecond
+
+	// the only slot is given back once RSET timed out, the next message uses a new session
+	for i := 0; i < 2; i++ {
+		if err := sendTestMessage(t, pool); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+	if sessions, messages := server.counts(); sessions != 2 || messages != 2 {
+		t.Fatalf("%d sessions delivered %d messages, want 2 each", sessions, messages)
+	}
+}
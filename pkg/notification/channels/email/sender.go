@@ -4,44 +4,127 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/smtp"
+	"net/mail"
+	"time"
 
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/inapp/presence"
 	"github.com/r1i2t3/agni/pkg/notification"
 	"github.com/r1i2t3/agni/pkg/notification/channels/email/EmailProviders"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/compose"
+	"github.com/r1i2t3/agni/pkg/notification/channels/email/dkim"
 	"github.com/r1i2t3/agni/pkg/queue"
 )
 
+// SMTPConfig configures the SMTP email channel
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	// From is the header From, e.g. "Agni <noreply@example.com>"
+	From string
+	// EnvelopeFrom is the MAIL FROM address bounces go to, defaults to the From address
+	EnvelopeFrom string
+	// TLSMode is one of starttls, tls or none, defaults to tls on port 465 and starttls otherwise
+	TLSMode string
+	// AuthMethod is one of plain, login, cram-md5 or none
+	AuthMethod  string
+	PoolSize    int
+	IdleTimeout time.Duration
+	// DKIM signs mail of applications without their own key, nil disables signing
+	DKIM *dkim.Signer
+}
+
 type EmailNotifier struct {
 	host         string
 	port         string
-	username     string
-	app_password string
+	from         string
+	envelopeFrom string
+	pool         *smtpPool
+	dkim         *dkim.Signer
 }
 
 var EmailChannel *EmailNotifier
 
-func NewEmailNotifier(host, port, username, password string) error {
-	if host == "" || port == "" || username == "" || password == "" {
-		return fmt.Errorf("SMTP host, port, username, and password are required")
+// sendTimeout bounds one SMTP delivery, including the attachment downloads and waiting for a pooled session
+const sendTimeout = 2 * time.Minute
+
+func NewEmailNotifier(cfg SMTPConfig) error {
+	if cfg.Host == "" || cfg.Port == "" {
+		return fmt.Errorf("SMTP host and port are required")
+	}
+
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return fmt.Errorf("invalid from address %q: %w", from, err)
+	}
+	envelopeFrom := cfg.EnvelopeFrom
+	if envelopeFrom == "" {
+		envelopeFrom = fromAddress.Address
+	}
+
+	tlsMode := cfg.TLSMode
+	if tlsMode == "" {
+		tlsMode = TLSModeStartTLS
+		if cfg.Port == "465" {
+			tlsMode = TLSModeImplicit
+		}
+	}
+	if tlsMode != TLSModeStartTLS && tlsMode != TLSModeImplicit && tlsMode != TLSModeNone {
+		return fmt.Errorf("unknown SMTP TLS mode: %s", tlsMode)
+	}
+
+	auth, err := newSMTPAuth(cfg.AuthMethod, cfg.Username, cfg.Password, cfg.Host)
+	if err != nil {
+		return err
+	}
+
+	if EmailChannel != nil {
+		EmailChannel.Close()
 	}
 	EmailChannel = &EmailNotifier{
-		host:         host,
-		port:         port,
-		username:     username,
-		app_password: password,
+		host:         cfg.Host,
+		port:         cfg.Port,
+		from:         fromAddress.String(),
+		envelopeFrom: envelopeFrom,
+		pool:         newSMTPPool(cfg.Host, cfg.Port, tlsMode, auth, cfg.PoolSize, cfg.IdleTimeout),
+		dkim:         cfg.DKIM,
+	}
+	if cfg.DKIM != nil && !EmailChannel.AlignsWithFrom(cfg.DKIM.Domain) {
+		log.Printf("⚠️  DKIM domain %s doesn't align with the From domain %s, receivers enforcing DMARC will reject the email",
+			cfg.DKIM.Domain, EmailChannel.FromDomain())
 	}
 	return nil
 }
 
 func (n *EmailNotifier) Send(ctx context.Context, notification *notification.Notification) error {
-	auth := smtp.PlainAuth("", n.username, n.app_password, n.host)
 	log.Default().Printf("Sending email to %s with subject %s", notification.Recipient, notification.Subject)
-	to := []string{notification.Recipient}
-	msg := []byte("To: " + notification.Recipient + "\r\n" +
-		"Subject: " + notification.Subject + "\r\n" +
-		"Content-Type: " + notification.MessageContentType + "\r\n" +
-		"\r\n" + notification.Message + "\r\n")
-	err := smtp.SendMail(n.host+":"+n.port, auth, n.username, to, msg)
+	message, err := compose.FromNotification(ctx, n.from, notification)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	msg, err := message.Bytes()
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	to, err := message.Recipients()
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	if signer := n.signerFor(notification.ApplicationID); signer != nil {
+		signed, err := signer.Sign(msg)
+		if err != nil {
+			log.Printf("Failed to DKIM sign email to %s, sending unsigned: %v", notification.Recipient, err)
+		} else {
+			msg = signed
+		}
+	}
+	err = n.pool.send(ctx, n.envelopeFrom, to, msg)
 	if err != nil {
 		log.Printf("Failed to send email to %s: %v", notification.Recipient, err)
 		return fmt.Errorf("failed to send email: %w", err)
@@ -51,6 +134,11 @@ func (n *EmailNotifier) Send(ctx context.Context, notification *notification.Not
 	return nil
 }
 
+// Close quits the idle SMTP sessions
+func (n *EmailNotifier) Close() {
+	n.pool.close()
+}
+
 func GetEmailChannel() *EmailNotifier {
 	if EmailChannel == nil {
 		panic("Email notifier is not initialized")
@@ -73,13 +161,40 @@ func ProcessEmailNotifications(notif *queue.QueuedNotification) (*notification.N
 		CreatedAt:          notif.CreatedAt,
 		MessageContentType: notif.MessageContentType,
 		TemplateID:         notif.TemplateID,
+		Email:              notif.Email,
 	}
+
+	// Addresses that bounced, complained or unsubscribed are not emailed again
+	suppressed, err := applySuppressionList(notification)
+	if err != nil {
+		return notification, err
+	}
+	if suppressed {
+		log.Printf("Email notification %s not sent, %s is suppressed", notif.ID, notif.Recipient)
+		notification.Status = "suppressed"
+		return notification, nil
+	}
+
+	// The user is in the app right now and sees it there
+	if notif.Email != nil && notif.Email.SkipIfOnline != "" {
+		online, err := presence.IsOnline(context.Background(), db.GetRedisClient(), notif.ApplicationID, notif.Email.SkipIfOnline)
+		if err != nil {
+			log.Printf("Failed to check presence of %s, sending the email: %v", notif.Email.SkipIfOnline, err)
+		} else if online {
+			log.Printf("Email notification %s not sent, %s is online", notif.ID, notif.Email.SkipIfOnline)
+			notification.Status = "skipped_online"
+			return notification, nil
+		}
+	}
+
 	switch notif.Provider {
 	case "smtp", "email":
 		if EmailChannel == nil {
 			panic("Email notifier is not initialized")
 		}
-		err := EmailChannel.Send(context.Background(), notification)
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		err := EmailChannel.Send(ctx, notification)
 		if err != nil {
 			log.Printf("Error sending email: %v", err)
 		}
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+// applySuppressionList reports whether the recipient is suppressed, because of a bounce,
+// complaint or an unsubscribe from the email's category, and drops suppressed addresses from cc and bcc
+func applySuppressionList(notif *notification.Notification) (bool, error) {
+	addresses := []string{NormalizeAddress(notif.Recipient)}
+	if notif.Email != nil {
+		for _, address := range append(append([]string{}, notif.Email.Cc...), notif.Email.Bcc...) {
+			addresses = append(addresses, NormalizeAddress(address))
+		}
+	}
+
+	if notif.Email != nil && notif.Email.Category != "" {
+		unsubscribed, err := db.IsEmailUnsubscribed(notif.ApplicationID, addresses[0], notif.Email.Category)
+		if err != nil {
+			return false, fmt.Errorf("failed to check unsubscribes: %w", err)
+		}
+		if unsubscribed {
+			return true, nil
+		}
+	}
+
+	suppressed, err := db.GetSuppressedEmails(notif.ApplicationID, addresses)
+	if err != nil {
+		return false, fmt.Errorf("failed to check suppression list: %w", err)
+	}
+	if len(suppressed) == 0 {
+		return false, nil
+	}
+	if isSuppressed(suppressed, notif.Recipient) {
+		return true, nil
+	}
+
+	// copy the options so the queued notification is left untouched
+	options := *notif.Email
+	options.Cc = withoutSuppressed(suppressed, options.Cc)
+	options.Bcc = withoutSuppressed(suppressed, options.Bcc)
+	notif.Email = &options
+	return false, nil
+}
+
+func withoutSuppressed(suppressed map[string]bool, addresses []string) []string {
+	var kept []string
+	for _, address := range addresses {
+		if isSuppressed(suppressed, address) {
+			log.Printf("Dropping suppressed address %s", address)
+			continue
+		}
+		kept = append(kept, address)
+	}
+	return kept
+}
+
+func isSuppressed(suppressed map[string]bool, address string) bool {
+	return suppressed[NormalizeAddress(address)]
+}
+
+// NormalizeAddress returns the lower cased bare address of "Name <user@example.com>" style input
+func NormalizeAddress(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	return strings.ToLower(strings.TrimSpace(address))
+}
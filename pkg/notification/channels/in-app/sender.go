@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/r1i2t3/agni/pkg/db"
 	"github.com/r1i2t3/agni/pkg/notification"
 	"github.com/r1i2t3/agni/pkg/queue"
@@ -16,12 +18,14 @@ import (
 type InAppNotifier struct {
 	rdb    *redis.Client
 	stream string
+	maxLen int64
 }
 
 var InAppChannel *InAppNotifier
 
-func NewInAppNotifier(streamName string) {
-	InAppChannel = &InAppNotifier{rdb: db.GetRedisClient(), stream: streamName}
+// NewInAppNotifier publishes to streamName, capped at about maxLen entries (0 for no cap)
+func NewInAppNotifier(streamName string, maxLen int64) {
+	InAppChannel = &InAppNotifier{rdb: db.GetRedisClient(), stream: streamName, maxLen: maxLen}
 }
 func (n *InAppNotifier) Send(notify *notification.Notification) error {
 	b, err := json.Marshal(notify)
@@ -31,7 +35,8 @@ func (n *InAppNotifier) Send(notify *notification.Notification) error {
 
 	args := &redis.XAddArgs{
 		Stream: n.stream,
-
+		MaxLen: n.maxLen,
+		Approx: true,
 		Values: map[string]interface{}{
 			"payload": string(b),
 			"id":      notify.ID,
@@ -60,12 +65,25 @@ func ProcessInAppNotifications(notif *queue.QueuedNotification) (*notification.N
 		CreatedAt:          notif.CreatedAt,
 		MessageContentType: notif.MessageContentType,
 		TemplateID:         notif.TemplateID,
+		InApp:              notif.InApp,
 	}
 	switch notif.Provider {
 	case "InApp":
 		if InAppChannel == nil {
 			panic("InApp notifier is not initialized")
 		}
+		if notif.InApp.Expired(time.Now()) {
+			log.Printf("InApp notification %s expired before it was sent", notif.ID)
+			notification.Status = "expired"
+			return notification, nil
+		}
+		if notif.InApp.IsBroadcast() {
+			// stored before it is published, the in-app service creates the inbox rows from it
+			if err := storeBroadcast(notif); err != nil {
+				log.Printf("Error storing InApp broadcast: %v", err)
+				return notification, err
+			}
+		}
 		err := InAppChannel.Send(notification)
 		if err != nil {
 			log.Printf("Error sending Notification: %v", err)
@@ -78,3 +96,44 @@ func ProcessInAppNotifications(notif *queue.QueuedNotification) (*notification.N
 		return notification, fmt.Errorf("unknown InApp provider: %s", notif.Provider)
 	}
 }
+
+// storeBroadcast keeps a broadcast for the users that aren't connected, its id is the notification id
+func storeBroadcast(notif *queue.QueuedNotification) error {
+	id, err := uuid.Parse(notif.ID)
+	if err != nil {
+		return fmt.Errorf("invalid notification id: %w", err)
+	}
+	applicationID, err := uuid.Parse(notif.ApplicationID)
+	if err != nil {
+		return fmt.Errorf("invalid application id: %w", err)
+	}
+	return db.CreateInAppBroadcast(&db.InAppBroadcast{
+		ID:                 id,
+		ApplicationID:      applicationID,
+		Topic:              notif.InApp.Topic,
+		Subject:            notif.Subject,
+		Message:            notif.Message,
+		MessageContentType: notif.MessageContentType,
+		InAppContent:       Content(notif.InApp),
+	})
+}
+
+// Content is the structured part of an in-app notification as it is stored in the inbox
+func Content(options *notification.InAppOptions) db.InAppContent {
+	if options == nil {
+		return db.InAppContent{}
+	}
+	// both come from a JSON request, they encode again
+	actions, _ := db.NewJSONText(options.Actions)
+	data, _ := db.NewJSONText(options.Data)
+	return db.InAppContent{
+		Category:  options.Category,
+		Icon:      options.Icon,
+		Image:     options.Image,
+		URL:       options.URL,
+		Actions:   actions,
+		Data:      data,
+		Priority:  options.Priority,
+		ExpiresAt: options.ExpiresAt,
+	}
+}
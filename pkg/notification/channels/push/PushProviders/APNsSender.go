@@ -0,0 +1,195 @@
+package pushproviders
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+const (
+	DefaultAPNsBaseURL = "https://api.push.apple.com"
+	SandboxAPNsBaseURL = "https://api.sandbox.push.apple.com"
+
+	// APNs rejects provider tokens older than an hour and throttles refreshes faster than 20 minutes
+	apnsTokenLifetime = 50 * time.Minute
+)
+
+// APNsSender sends alerts over HTTP/2 with token-based (.p8 key) authentication
+type APNsSender struct {
+	keyID      string
+	teamID     string
+	topic      string
+	privateKey *ecdsa.PrivateKey
+	baseURL    string
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+var APNsClient *APNsSender
+
+// NewAPNsSender creates the APNs sender. topic is the app bundle id.
+// baseURL overrides the APNs host, e.g. the sandbox or a local fake.
+func NewAPNsSender(keyPEM []byte, keyID, teamID, topic, baseURL string) (*APNsSender, error) {
+	if len(keyPEM) == 0 || keyID == "" || teamID == "" || topic == "" {
+		return nil, fmt.Errorf("apns key, key id, team id and topic are required")
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apns private key: %w", err)
+	}
+	if baseURL == "" {
+		baseURL = DefaultAPNsBaseURL
+	}
+
+	APNsClient = &APNsSender{
+		keyID:      keyID,
+		teamID:     teamID,
+		topic:      topic,
+		privateKey: key,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+			// APNs only speaks HTTP/2
+			Transport: &http.Transport{
+				ForceAttemptHTTP2: true,
+				TLSClientConfig:   &tls.Config{MinVersion: tls.VersionTLS12},
+				IdleConnTimeout:   5 * time.Minute,
+			},
+		},
+	}
+	return APNsClient, nil
+}
+
+// APNsSend sends an alert notification to a single device token
+func (s *APNsSender) APNsSend(ctx context.Context, deviceToken, title, body string, data map[string]string) error {
+	if APNsClient == nil {
+		return fmt.Errorf("apns sender is not initialized")
+	}
+
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{
+			"alert": map[string]string{
+				"title": title,
+				"body":  body,
+			},
+			"sound": "default",
+		},
+	}
+	for k, v := range data {
+		if k != "aps" {
+			payload[k] = v
+		}
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal apns payload: %w", err)
+	}
+
+	err = s.send(ctx, deviceToken, b)
+	if errors.Is(err, errExpiredProviderToken) {
+		// token was rejected, sign a fresh one and try once more
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+		err = s.send(ctx, deviceToken, b)
+	}
+	return err
+}
+
+var errExpiredProviderToken = errors.New("apns provider token expired")
+
+// unusableTokenReasons are the APNs error reasons of a device token that can never be delivered
+// to: the app was removed, or the token is malformed or belongs to another app or environment
+var unusableTokenReasons = map[string]bool{
+	"Unregistered":           true,
+	"BadDeviceToken":         true,
+	"DeviceTokenNotForTopic": true,
+}
+
+func (s *APNsSender) send(ctx context.Context, deviceToken string, payload []byte) error {
+	authToken, err := s.getProviderToken()
+	if err != nil {
+		return err
+	}
+
+	sendURL := fmt.Sprintf("%s/3/device/%s", s.baseURL, url.PathEscape(deviceToken))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("create apns request: %w", err)
+	}
+	req.Header.Set("authorization", "bearer "+authToken)
+	req.Header.Set("apns-topic", s.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	req.Header.Set("content-type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send apns notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var apnsErr struct {
+		Reason string `json:"reason"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(respBody, &apnsErr)
+
+	switch {
+	case resp.StatusCode == http.StatusGone || unusableTokenReasons[apnsErr.Reason]:
+		return fmt.Errorf("apns: %w", ErrUnregistered)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &notification.RateLimitError{
+			Provider:   "apns",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Minute),
+		}
+	case apnsErr.Reason == "ExpiredProviderToken":
+		return errExpiredProviderToken
+	}
+	return fmt.Errorf("apns returned %d: %s", resp.StatusCode, apnsErr.Reason)
+}
+
+// getProviderToken returns the cached ES256 provider token, signing a new one when it gets old
+func (s *APNsSender) getProviderToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Since(s.issuedAt) < apnsTokenLifetime {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": s.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = s.keyID
+
+	signed, err := token.SignedString(s.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("sign apns provider token: %w", err)
+	}
+	s.token = signed
+	s.issuedAt = now
+	return s.token, nil
+}
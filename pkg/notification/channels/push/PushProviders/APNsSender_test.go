@@ -0,0 +1,98 @@
+package pushproviders
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newFakeAPNs serves /3/device/ with the handler after checking the provider token
+func newFakeAPNs(t *testing.T, handler func(w http.ResponseWriter, r *http.Request, deviceToken string)) *[]string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var providerTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authToken := strings.TrimPrefix(r.Header.Get("authorization"), "bearer ")
+		claims := jwt.MapClaims{}
+		token, err := jwt.ParseWithClaims(authToken, claims, func(*jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		if err != nil || token.Header["kid"] != "KEY123" || claims["iss"] != "TEAM123" || r.Header.Get("apns-topic") != "com.example.agni" {
+			t.Errorf("invalid provider token %v: %v", claims, err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		providerTokens = append(providerTokens, authToken)
+		handler(w, r, strings.TrimPrefix(r.URL.Path, "/3/device/"))
+	}))
+	t.Cleanup(server.Close)
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err := NewAPNsSender(keyPEM, "KEY123", "TEAM123", "com.example.agni", server.URL); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { APNsClient = nil })
+	return &providerTokens
+}
+
+func TestAPNsSignsOneProviderTokenAndRefreshesItWhenExpired(t *testing.T) {
+	expired := true
+	providerTokens := newFakeAPNs(t, func(w http.ResponseWriter, r *http.Request, deviceToken string) {
+		if expired {
+			expired = false
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"reason": "ExpiredProviderToken"}`))
+		}
+	})
+
+	// the rejected token is replaced and the notification sent again
+	if err := APNsClient.APNsSend(context.Background(), "device", "title", "body", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := APNsClient.APNsSend(context.Background(), "device", "title", "body", nil); err != nil {
+		t.Fatal(err)
+	}
+	tokens := *providerTokens
+	if len(tokens) != 3 || tokens[1] != tokens[2] {
+		t.Fatalf("expected the refreshed provider token to be reused, got %d requests", len(tokens))
+	}
+}
+
+func TestAPNsUnusableDeviceTokens(t *testing.T) {
+	newFakeAPNs(t, func(w http.ResponseWriter, r *http.Request, deviceToken string) {
+		switch deviceToken {
+		case "gone":
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"reason": "Unregistered"}`))
+		case "bad", "other-app":
+			w.WriteHeader(http.StatusBadRequest)
+			reason := map[string]string{"bad": "BadDeviceToken", "other-app": "DeviceTokenNotForTopic"}[deviceToken]
+			w.Write([]byte(`{"reason": "` + reason + `"}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"reason": "ServiceUnavailable"}`))
+		}
+	})
+
+	for _, deviceToken := range []string{"gone", "bad", "other-app"} {
+		if err := APNsClient.APNsSend(context.Background(), deviceToken, "t", "b", nil); !errors.Is(err, ErrUnregistered) {
+			t.Errorf("%s: expected the token to be unregistered, got %v", deviceToken, err)
+		}
+	}
+	if err := APNsClient.APNsSend(context.Background(), "device", "t", "b", nil); err == nil || errors.Is(err, ErrUnregistered) {
+		t.Fatalf("expected a temporary error, got %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package pushproviders
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+const (
+	DefaultFCMBaseURL = "https://fcm.googleapis.com"
+	fcmScope          = "https://www.googleapis.com/auth/firebase.messaging"
+)
+
+// ErrUnregistered is returned when the provider reports the device token is no longer valid
+var ErrUnregistered = errors.New("device token is unregistered")
+
+// serviceAccount holds the fields we need from a Google service account JSON key
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMSender sends messages with the FCM HTTP v1 API using service account OAuth tokens
+type FCMSender struct {
+	projectID   string
+	clientEmail string
+	privateKey  *rsa.PrivateKey
+	tokenURL    string
+	baseURL     string
+	httpClient  *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+var FCMClient *FCMSender
+
+// NewFCMSender creates the FCM sender from a service account JSON key.
+// baseURL and tokenURL override the Google endpoints, e.g. to run against a local fake.
+func NewFCMSender(credentialsJSON []byte, baseURL, tokenURL string) (*FCMSender, error) {
+	if len(credentialsJSON) == 0 {
+		return nil, fmt.Errorf("fcm service account credentials are required")
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(credentialsJSON, &account); err != nil {
+		return nil, fmt.Errorf("invalid fcm service account: %w", err)
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("fcm service account must contain project_id, client_email and private_key")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid fcm private key: %w", err)
+	}
+
+	if baseURL == "" {
+		baseURL = DefaultFCMBaseURL
+	}
+	if tokenURL == "" {
+		tokenURL = account.TokenURI
+	}
+	if tokenURL == "" {
+		tokenURL = "https://oauth2.googleapis.com/token"
+	}
+
+	FCMClient = &FCMSender{
+		projectID:   account.ProjectID,
+		clientEmail: account.ClientEmail,
+		privateKey:  key,
+		tokenURL:    tokenURL,
+		baseURL:     strings.TrimRight(baseURL, "/"),
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+	return FCMClient, nil
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type      string `json:"@type"`
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// FCMSend sends a notification message to a single registration token
+func (s *FCMSender) FCMSend(ctx context.Context, token, title, body string, data map[string]string) error {
+	if FCMClient == nil {
+		return fmt.Errorf("fcm sender is not initialized")
+	}
+
+	accessToken, err := s.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	message := map[string]interface{}{
+		"token": token,
+		"notification": map[string]string{
+			"title": title,
+			"body":  body,
+		},
+	}
+	if len(data) > 0 {
+		message["data"] = data
+	}
+	b, err := json.Marshal(map[string]interface{}{"message": message})
+	if err != nil {
+		return fmt.Errorf("marshal fcm message: %w", err)
+	}
+
+	sendURL := fmt.Sprintf("%s/v1/projects/%s/messages:send", s.baseURL, url.PathEscape(s.projectID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sendURL, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create fcm request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send fcm message: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &notification.RateLimitError{
+			Provider:   "fcm",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Minute),
+		}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// force a new access token on the next attempt
+		s.mu.Lock()
+		s.accessToken = ""
+		s.mu.Unlock()
+	}
+
+	var fcmErr fcmErrorResponse
+	_ = json.Unmarshal(respBody, &fcmErr)
+	for _, detail := range fcmErr.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return fmt.Errorf("fcm: %w", ErrUnregistered)
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("fcm: %w", ErrUnregistered)
+	}
+	return fmt.Errorf("fcm returned %d: %s %s", resp.StatusCode, fcmErr.Error.Status, fcmErr.Error.Message)
+}
+
+// getAccessToken returns a cached OAuth access token, exchanging a signed JWT when it expires
+func (s *FCMSender) getAccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Now().Before(s.expiresAt) {
+		return s.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.clientEmail,
+		"scope": fcmScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.privateKey)
+	if err != nil {
+		return "", fmt.Errorf("sign fcm assertion: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("create fcm token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch fcm access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("fcm token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("invalid fcm token response")
+	}
+
+	s.accessToken = token.AccessToken
+	// refresh a minute early so in-flight requests don't use an expired token
+	s.expiresAt = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.accessToken, nil
+}
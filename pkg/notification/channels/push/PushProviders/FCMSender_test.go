@@ -0,0 +1,125 @@
+package pushproviders
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+// fakeFCM serves the OAuth token endpoint and messages:send, send answers the send requests
+type fakeFCM struct {
+	*httptest.Server
+	key           *rsa.PrivateKey
+	tokenRequests atomic.Int32
+	send          func(w http.ResponseWriter, token string)
+}
+
+func newFakeFCM(t *testing.T) *fakeFCM {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeFCM{key: key, send: func(w http.ResponseWriter, token string) { w.Write([]byte(`{"name": "m"}`)) }}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve(t)))
+	t.Cleanup(fake.Close)
+
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(serviceAccount{
+		ProjectID:   "agni-test",
+		ClientEmail: "push@agni-test.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	if _, err := NewFCMSender(credentials, fake.URL, fake.URL+"/token"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { FCMClient = nil })
+	return fake
+}
+
+func (f *fakeFCM) serve(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			f.tokenRequests.Add(1)
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(r.FormValue("assertion"), claims, func(*jwt.Token) (interface{}, error) {
+				return &f.key.PublicKey, nil
+			}, jwt.WithValidMethods([]string{"RS256"}))
+			if err != nil || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" ||
+				claims["iss"] != "push@agni-test.iam.gserviceaccount.com" || claims["aud"] != f.URL+"/token" || claims["scope"] != fcmScope {
+				t.Errorf("invalid assertion %v: %v", claims, err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token": "access-token", "expires_in": 3600}`))
+		case "/v1/projects/agni-test/messages:send":
+			if r.Header.Get("Authorization") != "Bearer access-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var body struct {
+				Message struct {
+					Token string `json:"token"`
+				} `json:"message"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			f.send(w, body.Message.Token)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestFCMExchangesASignedAssertionForOneAccessToken(t *testing.T) {
+	fake := newFakeFCM(t)
+
+	for i := 0; i < 3; i++ {
+		if err := FCMClient.FCMSend(context.Background(), "device-token", "title", "body", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := fake.tokenRequests.Load(); got != 1 {
+		t.Fatalf("fetched %d access tokens, want 1", got)
+	}
+}
+
+func TestFCMErrors(t *testing.T) {
+	fake := newFakeFCM(t)
+	fake.send = func(w http.ResponseWriter, token string) {
+		switch token {
+		case "unregistered":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "status": "NOT_FOUND", "details": [{"errorCode": "UNREGISTERED"}]}}`))
+		case "throttled":
+			w.Header().Set("Retry-After", "20")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": {"code": 500, "status": "INTERNAL"}}`))
+		}
+	}
+
+	if err := FCMClient.FCMSend(context.Background(), "unregistered", "t", "b", nil); !errors.Is(err, ErrUnregistered) {
+		t.Fatalf("expected the token to be unregistered, got %v", err)
+	}
+	var rateLimitErr *notification.RateLimitError
+	if err := FCMClient.FCMSend(context.Background(), "throttled", "t", "b", nil); !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 20*time.Second {
+		t.Fatalf("expected a 20s rate limit, got %v", err)
+	}
+	if err := FCMClient.FCMSend(context.Background(), "other", "t", "b", nil); err == nil || errors.Is(err, ErrUnregistered) {
+		t.Fatalf("expected a server error, got %v", err)
+	}
+}
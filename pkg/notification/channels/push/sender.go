@@ -0,0 +1,119 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification"
+	pushproviders "github.com/r1i2t3/agni/pkg/notification/channels/push/PushProviders"
+	"github.com/r1i2t3/agni/pkg/queue"
+)
+
+// ProcessPushNotifications delivers a notification to the recipient's registered mobile devices.
+// Provider "fcm" or "apns" limits delivery to tokens of that provider, "all" uses every token.
+// Tokens the providers report as unregistered are removed.
+func ProcessPushNotifications(notif *queue.QueuedNotification) (*notification.Notification, error) {
+	log.Printf("Processing push notification %s for %s", notif.ID, notif.Recipient)
+	result := &notification.Notification{
+		ID:                 notif.ID,
+		ApplicationID:      notif.ApplicationID,
+		QueueID:            notif.QueueID,
+		Recipient:          notif.Recipient,
+		Subject:            notif.Subject,
+		Message:            notif.Message,
+		Channel:            notif.Channel,
+		Provider:           notif.Provider,
+		Status:             notif.Status,
+		CreatedAt:          notif.CreatedAt,
+		MessageContentType: notif.MessageContentType,
+		TemplateID:         notif.TemplateID,
+	}
+
+	var providerFilter string
+	switch notif.Provider {
+	case "fcm", "apns":
+		providerFilter = notif.Provider
+	case "all":
+	default:
+		return result, fmt.Errorf("unknown push provider: %s", notif.Provider)
+	}
+
+	var tokens []db.DeviceToken
+	var err error
+	if len(notif.Targets) > 0 {
+		// a retry only goes to the devices that failed before
+		tokens, err = db.GetDeviceTokensByIDs(notif.ApplicationID, notif.Recipient, notif.Targets)
+	} else {
+		tokens, err = db.GetDeviceTokens(notif.ApplicationID, notif.Recipient, providerFilter)
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to get device tokens: %w", err)
+	}
+	if len(tokens) == 0 {
+		return result, fmt.Errorf("no device tokens found for user: %s", notif.Recipient)
+	}
+
+	data := map[string]string{"notification_id": notif.ID}
+	ctx := context.Background()
+
+	var delivered int
+	var lastErr error
+	var rateLimit *notification.RateLimitError
+	var unregistered, failed []string
+	for _, device := range tokens {
+		var sendErr error
+		switch device.Provider {
+		case "fcm":
+			sendErr = pushproviders.FCMClient.FCMSend(ctx, device.Token, notif.Subject, notif.Message, data)
+		case "apns":
+			sendErr = pushproviders.APNsClient.APNsSend(ctx, device.Token, notif.Subject, notif.Message, data)
+		default:
+			log.Printf("Push notification %s skipped device %s with unknown provider %s", notif.ID, device.ID, device.Provider)
+			continue
+		}
+
+		if sendErr == nil {
+			delivered++
+			continue
+		}
+		if errors.Is(sendErr, pushproviders.ErrUnregistered) {
+			unregistered = append(unregistered, device.Token)
+			continue
+		}
+		log.Printf("Failed to send push notification to %s device %s: %v", device.Provider, device.ID, sendErr)
+		failed = append(failed, device.ID.String())
+		lastErr = sendErr
+		var rateLimitErr *notification.RateLimitError
+		if errors.As(sendErr, &rateLimitErr) && (rateLimit == nil || rateLimitErr.RetryAfter > rateLimit.RetryAfter) {
+			rateLimit = rateLimitErr
+		}
+	}
+
+	if len(unregistered) > 0 {
+		if err := db.DeleteDeviceTokens(notif.ApplicationID, unregistered); err != nil {
+			log.Printf("Failed to prune unregistered device tokens: %v", err)
+		} else {
+			log.Printf("🧹 Pruned %d unregistered device tokens for user %s", len(unregistered), notif.Recipient)
+		}
+	}
+
+	if len(failed) > 0 {
+		notif.Targets = failed
+		if rateLimit != nil {
+			return result, fmt.Errorf("failed to send push notification to %d of %d devices: %w", len(failed), len(tokens), rateLimit)
+		}
+		return result, &notification.RetryError{
+			Err: fmt.Errorf("failed to send push notification to %d of %d devices: %w", len(failed), len(tokens), lastErr),
+		}
+	}
+	if delivered == 0 {
+		return result, fmt.Errorf("no registered devices for user: %s", notif.Recipient)
+	}
+
+	log.Printf("Push notification %s delivered to %d/%d devices", notif.ID, delivered, len(tokens))
+	result.Status = "sent"
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package push
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification"
+	pushproviders "github.com/r1i2t3/agni/pkg/notification/channels/push/PushProviders"
+	"github.com/r1i2t3/agni/pkg/queue"
+	"gorm.io/gorm/logger"
+)
+
+// fakeFCM answers messages:send with the status of the device token, 200 when it has none
+func fakeFCM(t *testing.T, statuses map[string]int) *[]string {
+	var mu sync.Mutex
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Write([]byte(`{"access_token": "access-token", "expires_in": 3600}`))
+			return
+		}
+		var body struct {
+			Message struct {
+				Token string `json:"token"`
+			} `json:"message"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		sent = append(sent, body.Message.Token)
+		mu.Unlock()
+		switch statuses[body.Message.Token] {
+		case http.StatusNotFound:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"details": [{"errorCode": "UNREGISTERED"}]}}`))
+		case http.StatusServiceUnavailable:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	credentials, _ := json.Marshal(map[string]string{
+		"project_id":   "agni-test",
+		"client_email": "push@agni-test.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	})
+	if _, err := pushproviders.NewFCMSender(credentials, server.URL, server.URL+"/token"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pushproviders.FCMClient = nil })
+	return &sent
+}
+
+func TestUnregisteredTokensArePrunedAndOnlyFailedDevicesRetried(t *testing.T) {
+	config := db.MySQLConfig{DSN: filepath.Join(t.TempDir(), "agni.db"), LogLevel: logger.Silent}
+	if err := db.InitMySQL("local", config, &db.Notification{}, &db.DeviceToken{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.CloseMySQL() })
+
+	applicationID := uuid.New()
+	for _, token := range []string{"ok", "gone", "down"} {
+		if err := db.SaveDeviceToken(&db.DeviceToken{ApplicationID: applicationID, UserID: "user-1", Token: token, Provider: "fcm"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statuses := map[string]int{"gone": http.StatusNotFound, "down": http.StatusServiceUnavailable}
+	sent := fakeFCM(t, statuses)
+
+	notif := &queue.QueuedNotification{ID: uuid.NewString(), ApplicationID: applicationID.String(), Recipient: "user-1", Provider: "all", Subject: "s", Message: "m"}
+	_, err := ProcessPushNotifications(notif)
+	var retryErr *notification.RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("expected a retry, got %v", err)
+	}
+
+	tokens, _ := db.GetDeviceTokens(applicationID.String(), "user-1", "")
+	if len(tokens) != 2 {
+		t.Fatalf("expected the unregistered token to be pruned, %d tokens are left", len(tokens))
+	}
+	down, _ := db.GetDeviceTokensByIDs(applicationID.String(), "user-1", notif.Targets)
+	if len(down) != 1 || down[0].Token != "down" {
+		t.Fatalf("expected the retry to target the failed device only, got %v", notif.Targets)
+	}
+
+	// the retry goes to the failed device only and succeeds
+	delete(statuses, "down")
+	*sent = nil
+	result, err := ProcessPushNotifications(notif)
+	if err != nil || result.Status != "sent" {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if len(*sent) != 1 || (*sent)[0] != "down" {
+		t.Fatalf("the retry was sent to %v", *sent)
+	}
+}
@@ -0,0 +1,254 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/queue"
+)
+
+const (
+	DefaultAPIBaseURL     = "https://slack.com/api"
+	DefaultWebhookBaseURL = "https://hooks.slack.com"
+
+	// Slack limits for block text fields
+	maxHeaderLength  = 150
+	maxSectionLength = 3000
+)
+
+// SlackNotifier sends messages through incoming webhooks or chat.postMessage
+type SlackNotifier struct {
+	apiBaseURL     string
+	webhookBaseURL string
+	botToken       string
+	httpClient     *http.Client
+}
+
+var SlackChannel *SlackNotifier
+
+func NewSlackNotifier(apiBaseURL, webhookBaseURL, botToken string) error {
+	if apiBaseURL == "" {
+		apiBaseURL = DefaultAPIBaseURL
+	}
+	if webhookBaseURL == "" {
+		webhookBaseURL = DefaultWebhookBaseURL
+	}
+	SlackChannel = &SlackNotifier{
+		apiBaseURL:     strings.TrimRight(apiBaseURL, "/"),
+		webhookBaseURL: strings.TrimRight(webhookBaseURL, "/"),
+		botToken:       botToken,
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+	}
+	if botToken == "" {
+		log.Println("Slack bot token is not set, only incoming webhooks are available")
+	}
+	return nil
+}
+
+// slackPayload is the body accepted by both incoming webhooks and chat.postMessage
+type slackPayload struct {
+	Channel string            `json:"channel,omitempty"`
+	Text    string            `json:"text,omitempty"`
+	Blocks  []json.RawMessage `json:"blocks,omitempty"`
+}
+
+// postMessageResponse is the relevant part of the chat.postMessage response
+type postMessageResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	TS    string `json:"ts,omitempty"`
+}
+
+func (n *SlackNotifier) Send(ctx context.Context, notification *notification.Notification) error {
+	payload, err := buildPayload(notification)
+	if err != nil {
+		return err
+	}
+
+	switch notification.Provider {
+	case "webhook":
+		return n.sendWebhook(ctx, notification.Recipient, payload)
+	case "bot":
+		payload.Channel = notification.Recipient
+		return n.sendBotMessage(ctx, payload)
+	default:
+		return fmt.Errorf("unknown slack provider: %s", notification.Provider)
+	}
+}
+
+// sendWebhook posts the payload to an incoming webhook URL
+func (n *SlackNotifier) sendWebhook(ctx context.Context, recipient string, payload *slackPayload) error {
+	webhookURL, err := notification.ResolveWebhookURL(n.webhookBaseURL, recipient)
+	if err != nil {
+		return err
+	}
+
+	resp, body, err := n.post(ctx, webhookURL, "", payload)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sendBotMessage posts the payload with chat.postMessage using the bot token
+func (n *SlackNotifier) sendBotMessage(ctx context.Context, payload *slackPayload) error {
+	if n.botToken == "" {
+		return fmt.Errorf("slack bot token is not configured")
+	}
+	if payload.Channel == "" {
+		return fmt.Errorf("slack channel id is required")
+	}
+
+	resp, body, err := n.post(ctx, n.apiBaseURL+"/chat.postMessage", n.botToken, payload)
+	if err != nil {
+		return err
+	}
+
+	var result postMessageResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("slack chat.postMessage returned %d: invalid response: %w", resp.StatusCode, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("slack chat.postMessage failed: %s", result.Error)
+	}
+	log.Printf("Slack message posted to %s (ts %s)", payload.Channel, result.TS)
+	return nil
+}
+
+// post sends a JSON request and turns HTTP 429 into a RateLimitError
+func (n *SlackNotifier) post(ctx context.Context, url, token string, payload *slackPayload) (*http.Response, []byte, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal slack payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, fmt.Errorf("create slack request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send slack request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read slack response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, nil, &notification.RateLimitError{
+			Provider:   "slack",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Minute),
+		}
+	}
+	return resp, body, nil
+}
+
+// buildPayload creates the Block Kit payload for a notification.
+// With message_content_type "application/json" the message is used as Block Kit JSON,
+// either a list of blocks or an object with "blocks" and optional "text".
+// Otherwise blocks are built from the subject (header) and message (mrkdwn section).
+func buildPayload(n *notification.Notification) (*slackPayload, error) {
+	if n.MessageContentType == "application/json" {
+		return parseBlockKit(n)
+	}
+
+	payload := &slackPayload{Text: n.Message}
+	if n.Subject != "" {
+		payload.Text = n.Subject
+		header, _ := json.Marshal(map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{
+				"type": "plain_text",
+				"text": truncate(n.Subject, maxHeaderLength),
+			},
+		})
+		payload.Blocks = append(payload.Blocks, header)
+	}
+	if n.Message != "" {
+		section, _ := json.Marshal(map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{
+				"type": "mrkdwn",
+				"text": truncate(n.Message, maxSectionLength),
+			},
+		})
+		payload.Blocks = append(payload.Blocks, section)
+	}
+	return payload, nil
+}
+
+func parseBlockKit(n *notification.Notification) (*slackPayload, error) {
+	raw := strings.TrimSpace(n.Message)
+	payload := &slackPayload{}
+
+	if strings.HasPrefix(raw, "[") {
+		if err := json.Unmarshal([]byte(raw), &payload.Blocks); err != nil {
+			return nil, fmt.Errorf("invalid slack blocks: %w", err)
+		}
+	} else if err := json.Unmarshal([]byte(raw), payload); err != nil {
+		return nil, fmt.Errorf("invalid slack payload: %w", err)
+	}
+
+	// Slack uses text as the notification fallback when blocks are present
+	if payload.Text == "" {
+		payload.Text = n.Subject
+	}
+	if payload.Text == "" && len(payload.Blocks) == 0 {
+		return nil, fmt.Errorf("slack payload must contain text or blocks")
+	}
+	return payload, nil
+}
+
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return string(r[:max-1]) + "…"
+}
+
+func ProcessSlackNotifications(notif *queue.QueuedNotification) (*notification.Notification, error) {
+	log.Printf("Processing Slack notification %s for %s", notif.ID, notif.Recipient)
+	notification := &notification.Notification{
+		ID:                 notif.ID,
+		ApplicationID:      notif.ApplicationID,
+		QueueID:            notif.QueueID,
+		Recipient:          notif.Recipient,
+		Subject:            notif.Subject,
+		Message:            notif.Message,
+		Channel:            notif.Channel,
+		Provider:           notif.Provider,
+		Status:             notif.Status,
+		CreatedAt:          notif.CreatedAt,
+		MessageContentType: notif.MessageContentType,
+		TemplateID:         notif.TemplateID,
+	}
+	if SlackChannel == nil {
+		return notification, fmt.Errorf("slack notifier is not initialized")
+	}
+	if err := SlackChannel.Send(context.Background(), notification); err != nil {
+		log.Printf("Error sending Slack notification: %v", err)
+		return notification, err
+	}
+	notification.Status = "sent"
+	return notification, nil
+}
@@ -0,0 +1,122 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+// stubSlack serves the webhook and chat.postMessage endpoints and records the requests
+type stubSlack struct {
+	*httptest.Server
+	requests []*http.Request
+	payloads []slackPayload
+}
+
+func newStubSlack(t *testing.T, handler http.HandlerFunc) *stubSlack {
+	stub := &stubSlack{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload slackPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		stub.requests = append(stub.requests, r)
+		stub.payloads = append(stub.payloads, payload)
+		handler(w, r)
+	}))
+	t.Cleanup(stub.Close)
+	if err := NewSlackNotifier(stub.URL+"/api", stub.URL, "xoxb-test"); err != nil {
+		t.Fatal(err)
+	}
+	return stub
+}
+
+func TestWebhookPostsBlocksUnderTheBaseURL(t *testing.T) {
+	stub := newStubSlack(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	err := SlackChannel.Send(context.Background(), &notification.Notification{
+		Provider:  "webhook",
+		Recipient: "services/T000/B000/XXXX",
+		Subject:   "Deploy finished",
+		Message:   "*agni* is live",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.requests[0].URL.Path; got != "/services/T000/B000/XXXX" {
+		t.Fatalf("posted to %s", got)
+	}
+	if payload := stub.payloads[0]; payload.Text != "Deploy finished" || len(payload.Blocks) != 2 {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+
+	// full URLs outside of the base URL are refused before any request
+	err = SlackChannel.Send(context.Background(), &notification.Notification{
+		Provider:  "webhook",
+		Recipient: "https://example.com/services/T000/B000/XXXX",
+		Message:   "hi",
+	})
+	if err == nil || len(stub.requests) != 1 {
+		t.Fatalf("expected the foreign webhook URL to be refused, got %v", err)
+	}
+}
+
+func TestBotPostsWithTheToken(t *testing.T) {
+	stub := newStubSlack(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(postMessageResponse{OK: r.URL.Path == "/api/chat.postMessage", TS: "1.2"})
+	})
+
+	err := SlackChannel.Send(context.Background(), &notification.Notification{
+		Provider:           "bot",
+		Recipient:          "C0123",
+		MessageContentType: "application/json",
+		Message:            `[{"type": "divider"}]`,
+		Subject:            "fallback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.requests[0].Header.Get("Authorization"); got != "Bearer xoxb-test" {
+		t.Fatalf("authorization %q", got)
+	}
+	if payload := stub.payloads[0]; payload.Channel != "C0123" || payload.Text != "fallback" || len(payload.Blocks) != 1 {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+}
+
+func TestBotReportsSlackErrors(t *testing.T) {
+	newStubSlack(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(postMessageResponse{Error: "channel_not_found"})
+	})
+
+	err := SlackChannel.Send(context.Background(), &notification.Notification{Provider: "bot", Recipient: "C0123", Message: "hi"})
+	if err == nil || notification.IsRetryable(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestRateLimitHonorsRetryAfter(t *testing.T) {
+	newStubSlack(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	for _, provider := range []string{"webhook", "bot"} {
+		err := SlackChannel.Send(context.Background(), &notification.Notification{Provider: provider, Recipient: "C0123", Message: "hi"})
+		var rateLimitErr *notification.RateLimitError
+		if !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 30*time.Second {
+			t.Fatalf("%s: expected a 30s rate limit, got %v", provider, err)
+		}
+		if !notification.IsRetryable(err) {
+			t.Fatalf("%s: rate limits must be retried", provider)
+		}
+	}
+}
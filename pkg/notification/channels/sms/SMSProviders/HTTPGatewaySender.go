@@ -0,0 +1,200 @@
+package smsproviders
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+// HTTPGatewayConfig describes a generic HTTP SMS gateway.
+// URLTemplate and BodyTemplate are Go text/templates rendered with .To, .From and .Message.
+// The values are query-escaped in the URL, use {{json .Message}} in JSON bodies.
+type HTTPGatewayConfig struct {
+	URLTemplate  string
+	Method       string
+	AuthHeader   string
+	AuthValue    string
+	ContentType  string
+	BodyTemplate string
+	From         string
+	// SuccessStatus is a comma separated list of status codes, e.g. "200,201,202"
+	SuccessStatus string
+	// SuccessBodyPattern is an optional regular expression the response body must match
+	SuccessBodyPattern string
+	// MessageIDPattern is an optional regular expression whose first group is the message id
+	MessageIDPattern string
+}
+
+type HTTPGatewaySender struct {
+	urlTemplate   *template.Template
+	bodyTemplate  *template.Template
+	method        string
+	authHeader    string
+	authValue     string
+	contentType   string
+	FromNumber    string
+	successStatus map[int]bool
+	successBody   *regexp.Regexp
+	messageID     *regexp.Regexp
+	httpClient    *http.Client
+}
+
+var HTTPGatewayClient *HTTPGatewaySender
+
+var gatewayFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// urlFuncs replace urlquery in the URL template, the values are escaped already
+var urlFuncs = template.FuncMap{
+	"urlquery": func(args ...interface{}) string {
+		return fmt.Sprint(args...)
+	},
+}
+
+type gatewayTemplateData struct {
+	To      string
+	From    string
+	Message string
+}
+
+// escaped is the data with query-escaped values, a "&" or "#" in a message can't change the URL
+func (d gatewayTemplateData) escaped() gatewayTemplateData {
+	return gatewayTemplateData{
+		To:      url.QueryEscape(d.To),
+		From:    url.QueryEscape(d.From),
+		Message: url.QueryEscape(d.Message),
+	}
+}
+
+func NewHTTPGatewaySender(cfg HTTPGatewayConfig) (*HTTPGatewaySender, error) {
+	if cfg.URLTemplate == "" {
+		return nil, fmt.Errorf("sms gateway URL template is required")
+	}
+
+	sender := &HTTPGatewaySender{
+		method:        strings.ToUpper(cfg.Method),
+		authHeader:    cfg.AuthHeader,
+		authValue:     cfg.AuthValue,
+		contentType:   cfg.ContentType,
+		FromNumber:    cfg.From,
+		successStatus: make(map[int]bool),
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if sender.method == "" {
+		sender.method = http.MethodPost
+	}
+
+	var err error
+	if sender.urlTemplate, err = template.New("url").Funcs(gatewayFuncs).Funcs(urlFuncs).Parse(cfg.URLTemplate); err != nil {
+		return nil, fmt.Errorf("invalid sms gateway URL template: %w", err)
+	}
+	if cfg.BodyTemplate != "" {
+		if sender.bodyTemplate, err = template.New("body").Funcs(gatewayFuncs).Parse(cfg.BodyTemplate); err != nil {
+			return nil, fmt.Errorf("invalid sms gateway body template: %w", err)
+		}
+	}
+
+	successStatus := cfg.SuccessStatus
+	if successStatus == "" {
+		successStatus = "200,201,202"
+	}
+	for _, code := range strings.Split(successStatus, ",") {
+		status, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sms gateway success status %q", code)
+		}
+		sender.successStatus[status] = true
+	}
+
+	if cfg.SuccessBodyPattern != "" {
+		if sender.successBody, err = regexp.Compile(cfg.SuccessBodyPattern); err != nil {
+			return nil, fmt.Errorf("invalid sms gateway success body pattern: %w", err)
+		}
+	}
+	if cfg.MessageIDPattern != "" {
+		if sender.messageID, err = regexp.Compile(cfg.MessageIDPattern); err != nil {
+			return nil, fmt.Errorf("invalid sms gateway message id pattern: %w", err)
+		}
+	}
+
+	HTTPGatewayClient = sender
+	return HTTPGatewayClient, nil
+}
+
+func (s *HTTPGatewaySender) HTTPGatewaySend(to, message string) (string, error) {
+	if HTTPGatewayClient == nil {
+		return "", fmt.Errorf("sms gateway sender is not initialized")
+	}
+
+	data := gatewayTemplateData{To: to, From: s.FromNumber, Message: message}
+
+	var urlBuf bytes.Buffer
+	if err := s.urlTemplate.Execute(&urlBuf, data.escaped()); err != nil {
+		return "", fmt.Errorf("render sms gateway URL: %w", err)
+	}
+	gatewayURL := strings.TrimSpace(urlBuf.String())
+	if _, err := url.ParseRequestURI(gatewayURL); err != nil {
+		return "", fmt.Errorf("invalid sms gateway URL: %w", err)
+	}
+
+	var body io.Reader
+	if s.bodyTemplate != nil {
+		var bodyBuf bytes.Buffer
+		if err := s.bodyTemplate.Execute(&bodyBuf, data); err != nil {
+			return "", fmt.Errorf("render sms gateway body: %w", err)
+		}
+		body = &bodyBuf
+	}
+
+	req, err := http.NewRequest(s.method, gatewayURL, body)
+	if err != nil {
+		return "", fmt.Errorf("create sms gateway request: %w", err)
+	}
+	if s.contentType != "" && body != nil {
+		req.Header.Set("Content-Type", s.contentType)
+	}
+	if s.authHeader != "" {
+		req.Header.Set(s.authHeader, s.authValue)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send SMS via gateway: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", &notification.RateLimitError{
+			Provider:   "http",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), 5*time.Second),
+		}
+	}
+	if !s.successStatus[resp.StatusCode] {
+		return "", fmt.Errorf("sms gateway returned HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	if s.successBody != nil && !s.successBody.Match(respBody) {
+		return "", fmt.Errorf("sms gateway response did not match success pattern: %s", strings.TrimSpace(string(respBody)))
+	}
+
+	if s.messageID != nil {
+		if match := s.messageID.FindSubmatch(respBody); len(match) > 1 {
+			return string(match[1]), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,75 @@
+package smsproviders
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGatewayEscapesTheValuesInTheURL(t *testing.T) {
+	var query map[string][]string
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("X-Api-Key") != "gateway-key" {
+			t.Errorf("missing auth header")
+		}
+		w.Write([]byte(`{"status": "queued", "id": "gw-42"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPGatewaySender(HTTPGatewayConfig{
+		// urlquery of older templates doesn't escape twice
+		URLTemplate:        server.URL + "/send?to={{.To}}&from={{urlquery .From}}&text={{.Message}}",
+		AuthHeader:         "X-Api-Key",
+		AuthValue:          "gateway-key",
+		ContentType:        "application/json",
+		BodyTemplate:       `{"to": {{json .To}}, "text": {{json .Message}}}`,
+		From:               "Agni & Co",
+		SuccessBodyPattern: `"status":\s*"queued"`,
+		MessageIDPattern:   `"id":\s*"([^"]+)"`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := "Tom & Jerry #1 100% done?"
+	id, err := HTTPGatewayClient.HTTPGatewaySend("+4915112345678", message)
+	if err != nil || id != "gw-42" {
+		t.Fatalf("got %q, %v", id, err)
+	}
+	if query["text"][0] != message || query["to"][0] != "+4915112345678" || query["from"][0] != "Agni & Co" || len(query) != 3 {
+		t.Fatalf("unexpected query %v", query)
+	}
+	if body["text"] != message {
+		t.Fatalf("unexpected body %v", body)
+	}
+}
+
+func TestGatewaySuccessChecks(t *testing.T) {
+	status, response := http.StatusOK, `{"status": "failed"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	_, err := NewHTTPGatewaySender(HTTPGatewayConfig{
+		URLTemplate:        server.URL + "/send",
+		SuccessStatus:      "200",
+		SuccessBodyPattern: `"status":\s*"queued"`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := HTTPGatewayClient.HTTPGatewaySend("+49", "hi"); err == nil || !strings.Contains(err.Error(), "success pattern") {
+		t.Fatalf("expected the body to fail the pattern, got %v", err)
+	}
+	status, response = http.StatusAccepted, `{"status": "queued"}`
+	if _, err := HTTPGatewayClient.HTTPGatewaySend("+49", "hi"); err == nil || !strings.Contains(err.Error(), "HTTP 202") {
+		t.Fatalf("expected 202 to fail, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package smsproviders
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+const DefaultMessageBirdBaseURL = "https://rest.messagebird.com"
+
+type MessageBirdSender struct {
+	accessKey  string
+	Originator string
+	baseURL    string
+	httpClient *http.Client
+}
+
+var MessageBirdClient *MessageBirdSender
+
+func NewMessageBirdSender(originator, accessKey, baseURL string) (*MessageBirdSender, error) {
+	if originator == "" || accessKey == "" {
+		return nil, fmt.Errorf("messagebird originator and access key are required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultMessageBirdBaseURL
+	}
+	MessageBirdClient = &MessageBirdSender{
+		accessKey:  accessKey,
+		Originator: originator,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	return MessageBirdClient, nil
+}
+
+type messageBirdResponse struct {
+	ID     string `json:"id"`
+	Errors []struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"errors"`
+}
+
+func (s *MessageBirdSender) MessageBirdSend(to, message string) (string, error) {
+	if MessageBirdClient == nil {
+		return "", fmt.Errorf("messagebird sender is not initialized")
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"originator": s.Originator,
+		"recipients": []string{to},
+		"body":       message,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal messagebird message: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.baseURL+"/messages", bytes.NewReader(b))
+	if err != nil {
+		return "", fmt.Errorf("create messagebird request: %w", err)
+	}
+	req.Header.Set("Authorization", "AccessKey "+s.accessKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send SMS via MessageBird: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", &notification.RateLimitError{
+			Provider:   "messagebird",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Second),
+		}
+	}
+
+	var result messageBirdResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response from MessageBird (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		if len(result.Errors) > 0 {
+			return "", fmt.Errorf("messagebird rejected SMS (code %d): %s", result.Errors[0].Code, result.Errors[0].Description)
+		}
+		return "", fmt.Errorf("messagebird returned HTTP %d", resp.StatusCode)
+	}
+	if result.ID == "" {
+		return "", fmt.Errorf("empty response or message ID from MessageBird API")
+	}
+	return result.ID, nil
+}
@@ -0,0 +1,61 @@
+package smsproviders
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+func TestMessageBirdSendsTheMessage(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" || r.Header.Get("Authorization") != "AccessKey live-key" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "mb-1"}`))
+	}))
+	defer server.Close()
+	if _, err := NewMessageBirdSender("Agni", "live-key", server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := MessageBirdClient.MessageBirdSend("+4915112345678", "hi")
+	if err != nil || id != "mb-1" {
+		t.Fatalf("got %q, %v", id, err)
+	}
+	if body["originator"] != "Agni" || body["body"] != "hi" {
+		t.Fatalf("unexpected body %v", body)
+	}
+}
+
+func TestMessageBirdErrors(t *testing.T) {
+	status := http.StatusUnprocessableEntity
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "3")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"errors": [{"code": 9, "description": "no (correct) recipients found"}]}`))
+	}))
+	defer server.Close()
+	if _, err := NewMessageBirdSender("Agni", "live-key", server.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MessageBirdClient.MessageBirdSend("+49", "hi"); err == nil || !strings.Contains(err.Error(), "code 9") {
+		t.Fatalf("expected the MessageBird error, got %v", err)
+	}
+	status = http.StatusTooManyRequests
+	var rateLimitErr *notification.RateLimitError
+	if _, err := MessageBirdClient.MessageBirdSend("+49", "hi"); !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 3*time.Second {
+		t.Fatalf("expected a 3s rate limit, got %v", err)
+	}
+}
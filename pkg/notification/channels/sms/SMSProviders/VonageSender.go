@@ -0,0 +1,112 @@
+package smsproviders
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+const DefaultVonageBaseURL = "https://rest.nexmo.com"
+
+type VonageSender struct {
+	apiKey     string
+	apiSecret  string
+	FromNumber string
+	baseURL    string
+	httpClient *http.Client
+}
+
+var VonageClient *VonageSender
+
+func NewVonageSender(fromNumber, apiKey, apiSecret, baseURL string) (*VonageSender, error) {
+	if fromNumber == "" || apiKey == "" || apiSecret == "" {
+		return nil, fmt.Errorf("vonage from number, API key, and API secret are required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultVonageBaseURL
+	}
+	VonageClient = &VonageSender{
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		FromNumber: fromNumber,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	return VonageClient, nil
+}
+
+type vonageResponse struct {
+	Messages []struct {
+		MessageID string `json:"message-id"`
+		Status    string `json:"status"`
+		ErrorText string `json:"error-text"`
+	} `json:"messages"`
+}
+
+func (s *VonageSender) VonageSend(to, message string) (string, error) {
+	if VonageClient == nil {
+		return "", fmt.Errorf("vonage sender is not initialized")
+	}
+
+	form := url.Values{
+		"api_key":    {s.apiKey},
+		"api_secret": {s.apiSecret},
+		"from":       {s.FromNumber},
+		"to":         {strings.TrimPrefix(to, "+")},
+		"text":       {message},
+	}
+	if !isASCII(message) {
+		form.Set("type", "unicode")
+	}
+
+	resp, err := s.httpClient.PostForm(s.baseURL+"/sms/json", form)
+	if err != nil {
+		return "", fmt.Errorf("failed to send SMS via Vonage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return "", &notification.RateLimitError{
+			Provider:   "vonage",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Second),
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vonage returned HTTP %d", resp.StatusCode)
+	}
+
+	var result vonageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid response from Vonage: %w", err)
+	}
+	if len(result.Messages) == 0 {
+		return "", fmt.Errorf("empty response from Vonage API")
+	}
+
+	// Each message part has its own status, "0" is success and "1" is throttled
+	for _, part := range result.Messages {
+		switch part.Status {
+		case "0":
+		case "1":
+			return "", &notification.RateLimitError{Provider: "vonage", RetryAfter: time.Second}
+		default:
+			return "", fmt.Errorf("vonage rejected SMS (status %s): %s", part.Status, part.ErrorText)
+		}
+	}
+	return result.Messages[0].MessageID, nil
+}
+
+// isASCII reports whether the message can be sent as plain text instead of unicode
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 127 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package smsproviders
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+func newStubVonage(t *testing.T, response string) *url.Values {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sms/json" {
+			t.Errorf("posted to %s", r.URL.Path)
+		}
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+	if _, err := NewVonageSender("Agni", "key", "secret", server.URL); err != nil {
+		t.Fatal(err)
+	}
+	return &form
+}
+
+func TestVonageSendsTheForm(t *testing.T) {
+	form := newStubVonage(t, `{"messages": [{"message-id": "m-1", "status": "0"}, {"message-id": "m-2", "status": "0"}]}`)
+
+	id, err := VonageClient.VonageSend("+4915112345678", "Grüße & more")
+	if err != nil || id != "m-1" {
+		t.Fatalf("got %q, %v", id, err)
+	}
+	if form.Get("to") != "4915112345678" || form.Get("text") != "Grüße & more" || form.Get("type") != "unicode" || form.Get("api_key") != "key" {
+		t.Fatalf("unexpected form %v", *form)
+	}
+}
+
+func TestVonageMessageStatuses(t *testing.T) {
+	newStubVonage(t, `{"messages": [{"status": "1"}]}`)
+	var rateLimitErr *notification.RateLimitError
+	if _, err := VonageClient.VonageSend("+4915112345678", "hi"); !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected throttled messages to be rate limited, got %v", err)
+	}
+
+	newStubVonage(t, `{"messages": [{"status": "0"}, {"status": "6", "error-text": "Unroutable message"}]}`)
+	if _, err := VonageClient.VonageSend("+4915112345678", "hi"); err == nil || notification.IsRetryable(err) {
+		t.Fatalf("expected a rejected part to fail the message, got %v", err)
+	}
+}
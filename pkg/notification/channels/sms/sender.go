@@ -1,6 +1,7 @@
 package sms
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/r1i2t3/agni/pkg/notification"
@@ -32,8 +33,30 @@ func ProcessSMSNotifications(notif *queue.QueuedNotification) (*notification.Not
 			log.Printf("Failed to send SMS notification via Twilio: %v", err)
 			return notification, err
 		}
+	case "vonage":
+		// Process Vonage SMS notification
+		_, err := smsproviders.VonageClient.VonageSend(notif.Recipient, notif.Message)
+		if err != nil {
+			log.Printf("Failed to send SMS notification via Vonage: %v", err)
+			return notification, err
+		}
+	case "messagebird":
+		// Process MessageBird SMS notification
+		_, err := smsproviders.MessageBirdClient.MessageBirdSend(notif.Recipient, notif.Message)
+		if err != nil {
+			log.Printf("Failed to send SMS notification via MessageBird: %v", err)
+			return notification, err
+		}
+	case "http":
+		// Process SMS notification through the configured HTTP gateway
+		_, err := smsproviders.HTTPGatewayClient.HTTPGatewaySend(notif.Recipient, notif.Message)
+		if err != nil {
+			log.Printf("Failed to send SMS notification via HTTP gateway: %v", err)
+			return notification, err
+		}
 	default:
 		log.Printf("Unknown SMS provider %s", notif.Provider)
+		return notification, fmt.Errorf("unknown SMS provider: %s", notif.Provider)
 	}
 	return notification, nil
 }
@@ -0,0 +1,192 @@
+package teams
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/queue"
+)
+
+const adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+
+// TeamsNotifier posts adaptive cards to Microsoft Teams webhooks
+// (incoming webhook connectors and Workflows "post to a channel" URLs)
+type TeamsNotifier struct {
+	webhookBaseURL string
+	allowedHosts   []string
+	httpClient     *http.Client
+}
+
+var TeamsChannel *TeamsNotifier
+
+// NewTeamsNotifier creates the Teams notifier. Teams webhook hosts are tenant specific, so
+// without a webhookBaseURL the recipient is a full https URL on one of allowedHosts
+// (e.g. "*.webhook.office.com"), and it is only posted to when it resolves to a public address.
+func NewTeamsNotifier(webhookBaseURL string, allowedHosts []string) error {
+	if webhookBaseURL == "" && len(allowedHosts) == 0 {
+		return fmt.Errorf("teams needs a webhook base URL or allowed webhook hosts")
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	if webhookBaseURL == "" {
+		httpClient = notification.NewPublicHTTPClient(10*time.Second, allowedHosts)
+	}
+	TeamsChannel = &TeamsNotifier{
+		webhookBaseURL: strings.TrimRight(webhookBaseURL, "/"),
+		allowedHosts:   allowedHosts,
+		httpClient:     httpClient,
+	}
+	return nil
+}
+
+type cardAttachment struct {
+	ContentType string      `json:"contentType"`
+	ContentURL  *string     `json:"contentUrl"`
+	Content     interface{} `json:"content"`
+}
+
+type teamsMessage struct {
+	Type        string           `json:"type"`
+	Attachments []cardAttachment `json:"attachments"`
+}
+
+func (n *TeamsNotifier) Send(ctx context.Context, notif *notification.Notification) error {
+	if notif.Provider != "webhook" {
+		return fmt.Errorf("unknown teams provider: %s", notif.Provider)
+	}
+
+	body, err := buildPayload(notif)
+	if err != nil {
+		return err
+	}
+
+	webhookURL, err := n.resolveWebhookURL(notif.Recipient)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create teams request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send teams webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &notification.RateLimitError{
+			Provider:   "teams",
+			RetryAfter: notification.ParseRetryAfter(resp.Header.Get("Retry-After"), 30*time.Second),
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("teams webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+
+	// Legacy connectors answer 200 and report the real error in the body
+	if text := strings.TrimSpace(string(respBody)); strings.HasPrefix(text, "Microsoft Teams endpoint returned HTTP error") {
+		if strings.Contains(text, "429") {
+			return &notification.RateLimitError{Provider: "teams", RetryAfter: 30 * time.Second}
+		}
+		return fmt.Errorf("teams webhook failed: %s", text)
+	}
+	return nil
+}
+
+// resolveWebhookURL only accepts https URLs on the allowed hosts when no base URL restricts the recipient
+func (n *TeamsNotifier) resolveWebhookURL(recipient string) (string, error) {
+	if n.webhookBaseURL != "" {
+		return notification.ResolveWebhookURL(n.webhookBaseURL, recipient)
+	}
+	u, err := url.Parse(strings.TrimSpace(recipient))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("teams webhook recipient must be an https URL")
+	}
+	if !notification.HostAllowed(u.Hostname(), n.allowedHosts) {
+		return "", fmt.Errorf("teams webhook host %s is not allowed", u.Hostname())
+	}
+	return u.String(), nil
+}
+
+// buildPayload wraps an adaptive card built from the subject and message.
+// With message_content_type "application/json" the message is used as the card content.
+func buildPayload(n *notification.Notification) ([]byte, error) {
+	var content interface{}
+	if n.MessageContentType == "application/json" {
+		var card map[string]interface{}
+		if err := json.Unmarshal([]byte(n.Message), &card); err != nil {
+			return nil, fmt.Errorf("invalid adaptive card: %w", err)
+		}
+		content = card
+	} else {
+		body := []map[string]interface{}{}
+		if n.Subject != "" {
+			body = append(body, map[string]interface{}{
+				"type":   "TextBlock",
+				"text":   n.Subject,
+				"size":   "Medium",
+				"weight": "Bolder",
+				"wrap":   true,
+			})
+		}
+		body = append(body, map[string]interface{}{
+			"type": "TextBlock",
+			"text": n.Message,
+			"wrap": true,
+		})
+		content = map[string]interface{}{
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+			"type":    "AdaptiveCard",
+			"version": "1.4",
+			"body":    body,
+		}
+	}
+
+	return json.Marshal(teamsMessage{
+		Type: "message",
+		Attachments: []cardAttachment{{
+			ContentType: adaptiveCardContentType,
+			Content:     content,
+		}},
+	})
+}
+
+func ProcessTeamsNotifications(notif *queue.QueuedNotification) (*notification.Notification, error) {
+	log.Printf("Processing Teams notification %s", notif.ID)
+	notification := &notification.Notification{
+		ID:                 notif.ID,
+		ApplicationID:      notif.ApplicationID,
+		QueueID:            notif.QueueID,
+		Recipient:          notif.Recipient,
+		Subject:            notif.Subject,
+		Message:            notif.Message,
+		Channel:            notif.Channel,
+		Provider:           notif.Provider,
+		Status:             notif.Status,
+		CreatedAt:          notif.CreatedAt,
+		MessageContentType: notif.MessageContentType,
+		TemplateID:         notif.TemplateID,
+	}
+	if TeamsChannel == nil {
+		return notification, fmt.Errorf("teams notifier is not initialized")
+	}
+	if err := TeamsChannel.Send(context.Background(), notification); err != nil {
+		log.Printf("Error sending Teams notification: %v", err)
+		return notification, err
+	}
+	notification.Status = "sent"
+	return notification, nil
+}
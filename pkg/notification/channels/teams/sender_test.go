@@ -0,0 +1,102 @@
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/notification"
+)
+
+func newStubTeams(t *testing.T, handler http.HandlerFunc) *int {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	if err := NewTeamsNotifier(server.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	return &requests
+}
+
+func TestWebhookPostsAnAdaptiveCard(t *testing.T) {
+	var message teamsMessage
+	newStubTeams(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&message)
+		w.Write([]byte("1"))
+	})
+
+	err := TeamsChannel.Send(context.Background(), &notification.Notification{
+		Provider: "webhook", Recipient: "webhookb2/abc", Subject: "Deploy finished", Message: "agni is live",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Attachments) != 1 || message.Attachments[0].ContentType != adaptiveCardContentType {
+		t.Fatalf("unexpected message %+v", message)
+	}
+	card, _ := message.Attachments[0].Content.(map[string]interface{})
+	if body, _ := card["body"].([]interface{}); len(body) != 2 {
+		t.Fatalf("expected the subject and message text blocks, got %v", card)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"429": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+		},
+		"legacy connector": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Microsoft Teams endpoint returned HTTP error 429 with ContextId tcid=0"))
+		},
+	} {
+		newStubTeams(t, handler)
+		err := TeamsChannel.Send(context.Background(), &notification.Notification{Provider: "webhook", Recipient: "webhookb2/abc", Message: "hi"})
+		var rateLimitErr *notification.RateLimitError
+		if !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != 30*time.Second {
+			t.Fatalf("%s: expected a 30s rate limit, got %v", name, err)
+		}
+	}
+}
+
+func TestLegacyConnectorErrorsInTheBodyFail(t *testing.T) {
+	newStubTeams(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Microsoft Teams endpoint returned HTTP error 400 with ContextId tcid=0"))
+	})
+
+	err := TeamsChannel.Send(context.Background(), &notification.Notification{Provider: "webhook", Recipient: "webhookb2/abc", Message: "hi"})
+	if err == nil || notification.IsRetryable(err) {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+}
+
+func TestRecipientsMustBeOnTheAllowedHosts(t *testing.T) {
+	if err := NewTeamsNotifier("", []string{"*.webhook.office.com", "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for recipient, want := range map[string]string{
+		"https://example.com/webhookb2/abc":             "not allowed",
+		"http://contoso.webhook.office.com/webhookb2/a": "https URL",
+		"webhookb2/abc": "https URL",
+		// allowed, but the host resolves to loopback
+		"https://localhost:1/webhookb2/abc": notification.ErrForbiddenAddress.Error(),
+	} {
+		err := TeamsChannel.Send(context.Background(), &notification.Notification{Provider: "webhook", Recipient: recipient, Message: "hi"})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected %q, got %v", recipient, want, err)
+		}
+	}
+
+	if err := NewTeamsNotifier("", nil); err == nil {
+		t.Fatal("expected a base URL or allowed hosts to be required")
+	}
+}
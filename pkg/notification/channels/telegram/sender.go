@@ -0,0 +1,203 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/queue"
+)
+
+const DefaultAPIBaseURL = "https://api.telegram.org"
+
+// TelegramNotifier sends messages through the Telegram Bot API
+type TelegramNotifier struct {
+	apiBaseURL string
+	botToken   string
+	httpClient *http.Client
+}
+
+var TelegramChannel *TelegramNotifier
+
+func NewTelegramNotifier(apiBaseURL, botToken string) error {
+	if botToken == "" {
+		return fmt.Errorf("telegram bot token is required")
+	}
+	if apiBaseURL == "" {
+		apiBaseURL = DefaultAPIBaseURL
+	}
+	TelegramChannel = &TelegramNotifier{
+		apiBaseURL: strings.TrimRight(apiBaseURL, "/"),
+		botToken:   botToken,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	return nil
+}
+
+type sendMessageRequest struct {
+	ChatID    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// apiResponse is the envelope returned by every Bot API method
+type apiResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code,omitempty"`
+	Description string `json:"description,omitempty"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after,omitempty"`
+	} `json:"parameters,omitempty"`
+}
+
+func (n *TelegramNotifier) Send(ctx context.Context, notif *notification.Notification) error {
+	if notif.Provider != "bot" {
+		return fmt.Errorf("unknown telegram provider: %s", notif.Provider)
+	}
+
+	chatID, err := resolveChatID(notif.ApplicationID, notif.Recipient)
+	if err != nil {
+		return err
+	}
+
+	parseMode := ParseModeFor(notif.MessageContentType)
+	return n.SendMessage(ctx, chatID, formatText(notif.Subject, notif.Message, parseMode), parseMode)
+}
+
+// SendMessage calls sendMessage for a chat id or @channel username
+func (n *TelegramNotifier) SendMessage(ctx context.Context, chatID, text, parseMode string) error {
+	b, err := json.Marshal(sendMessageRequest{ChatID: chatID, Text: text, ParseMode: parseMode})
+	if err != nil {
+		return fmt.Errorf("marshal telegram message: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", n.apiBaseURL, n.botToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("create telegram request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		// the request URL contains the bot token, keep it out of the logs
+		return fmt.Errorf("failed to send telegram message: %v", stripToken(err.Error(), n.botToken))
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	var result apiResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("telegram sendMessage returned %d: invalid response", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || result.ErrorCode == http.StatusTooManyRequests {
+		retryAfter := time.Duration(result.Parameters.RetryAfter) * time.Second
+		if retryAfter == 0 {
+			retryAfter = notification.ParseRetryAfter(resp.Header.Get("Retry-After"), 30*time.Second)
+		}
+		return &notification.RateLimitError{Provider: "telegram", RetryAfter: retryAfter}
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram sendMessage failed (%d): %s", result.ErrorCode, result.Description)
+	}
+	return nil
+}
+
+// ParseModeFor maps the message content type to a Telegram parse mode
+func ParseModeFor(contentType string) string {
+	switch contentType {
+	case "text/html":
+		return "HTML"
+	case "text/markdown":
+		return "MarkdownV2"
+	default:
+		return ""
+	}
+}
+
+// formatText puts the subject in bold above the message, escaped for the parse mode
+func formatText(subject, message, parseMode string) string {
+	if subject == "" {
+		return message
+	}
+	switch parseMode {
+	case "HTML":
+		return "<b>" + html.EscapeString(subject) + "</b>\n" + message
+	case "MarkdownV2":
+		return "*" + escapeMarkdownV2(subject) + "*\n" + message
+	default:
+		return subject + "\n\n" + message
+	}
+}
+
+var markdownV2Escaper = strings.NewReplacer(
+	"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-",
+	"=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+)
+
+func escapeMarkdownV2(s string) string {
+	return markdownV2Escaper.Replace(s)
+}
+
+// resolveChatID accepts a numeric chat id or @channel username as recipient.
+// Anything else is treated as an application user id linked through /start <code>.
+func resolveChatID(applicationID, recipient string) (string, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return "", fmt.Errorf("telegram recipient is required")
+	}
+	if strings.HasPrefix(recipient, "@") {
+		return recipient, nil
+	}
+	if _, err := strconv.ParseInt(recipient, 10, 64); err == nil {
+		return recipient, nil
+	}
+
+	chatID, err := db.GetTelegramChatID(applicationID, recipient)
+	if err != nil {
+		return "", fmt.Errorf("no telegram chat linked for user %s: %w", recipient, err)
+	}
+	return strconv.FormatInt(chatID, 10), nil
+}
+
+func stripToken(s, token string) string {
+	return strings.ReplaceAll(s, token, "<token>")
+}
+
+func ProcessTelegramNotifications(notif *queue.QueuedNotification) (*notification.Notification, error) {
+	log.Printf("Processing Telegram notification %s for %s", notif.ID, notif.Recipient)
+	notification := &notification.Notification{
+		ID:                 notif.ID,
+		ApplicationID:      notif.ApplicationID,
+		QueueID:            notif.QueueID,
+		Recipient:          notif.Recipient,
+		Subject:            notif.Subject,
+		Message:            notif.Message,
+		Channel:            notif.Channel,
+		Provider:           notif.Provider,
+		Status:             notif.Status,
+		CreatedAt:          notif.CreatedAt,
+		MessageContentType: notif.MessageContentType,
+		TemplateID:         notif.TemplateID,
+	}
+	if TelegramChannel == nil {
+		return notification, fmt.Errorf("telegram notifier is not initialized")
+	}
+	if err := TelegramChannel.Send(context.Background(), notification); err != nil {
+		log.Printf("Error sending Telegram notification: %v", err)
+		return notification, err
+	}
+	notification.Status = "sent"
+	return notification, nil
+}
@@ -1,9 +1,18 @@
 package webpush
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
+	"github.com/google/uuid"
 	"github.com/r1i2t3/agni/pkg/db"
 	"github.com/r1i2t3/agni/pkg/notification"
 	"github.com/r1i2t3/agni/pkg/queue"
@@ -21,6 +30,10 @@ func NewPushNotifier(publicKey, privateKey, subject string) (*PushNotifier, erro
 	if publicKey == "" || privateKey == "" {
 		return nil, fmt.Errorf("VAPID public and private keys are required")
 	}
+	// push services like Apple's reject VAPID tokens without a subject
+	if subject == "" {
+		return nil, fmt.Errorf("VAPID subject (mailto: or https: URL) is required")
+	}
 	pushNotifier = &PushNotifier{
 		vapidPublicKey:  publicKey,
 		vapidPrivateKey: privateKey,
@@ -29,32 +42,233 @@ func NewPushNotifier(publicKey, privateKey, subject string) (*PushNotifier, erro
 	return pushNotifier, nil
 }
 
+// VAPIDPublicKey returns the application server key browsers subscribe with
+func VAPIDPublicKey() string {
+	if pushNotifier == nil {
+		return ""
+	}
+	return pushNotifier.vapidPublicKey
+}
+
+// DefaultTTL is used when the send request doesn't set a TTL
+const DefaultTTL = 24 * 60 * 60
+
+// Payload is the JSON the service worker receives in the push event
+type Payload struct {
+	Title   string                       `json:"title,omitempty"`
+	Body    string                       `json:"body"`
+	Icon    string                       `json:"icon,omitempty"`
+	Badge   string                       `json:"badge,omitempty"`
+	URL     string                       `json:"url,omitempty"`
+	Actions []notification.WebPushAction `json:"actions,omitempty"`
+	Data    map[string]interface{}       `json:"data,omitempty"`
+}
+
+func buildPayload(notif *queue.QueuedNotification) ([]byte, error) {
+	payload := Payload{
+		Title: notif.Subject,
+		Body:  notif.Message,
+		Data:  map[string]interface{}{},
+	}
+	if opts := notif.WebPush; opts != nil {
+		payload.Icon = opts.Icon
+		payload.Badge = opts.Badge
+		payload.URL = opts.URL
+		payload.Actions = opts.Actions
+		for key, value := range opts.Data {
+			payload.Data[key] = value
+		}
+	}
+	payload.Data["notification_id"] = notif.ID
+	return json.Marshal(payload)
+}
+
+// sendOptions returns the VAPID and delivery options for one send
+func (p *PushNotifier) sendOptions(opts *notification.WebPushOptions) *webpush.Options {
+	options := &webpush.Options{
+		Subscriber:      p.vapidSubject,
+		VAPIDPublicKey:  p.vapidPublicKey,
+		VAPIDPrivateKey: p.vapidPrivateKey,
+		TTL:             DefaultTTL,
+		HTTPClient:      httpClient,
+	}
+	if opts != nil {
+		if opts.TTL > 0 {
+			options.TTL = opts.TTL
+		}
+		options.Urgency = webpush.Urgency(opts.Urgency)
+		options.Topic = opts.Topic
+	}
+	return options
+}
+
+// maxConcurrentSends bounds the requests made in parallel for one notification
+const maxConcurrentSends = 10
+
+// httpClient only reaches public addresses since the endpoints come from the browsers that subscribed
+var httpClient = notification.NewPublicHTTPClient(30*time.Second, nil)
+
+// deliveryResult is the outcome of the send to one subscription
+type deliveryResult struct {
+	subscription db.WebPushSubscription
+	statusCode   int
+	retryAfter   string
+	err          error
+}
+
+// status classifies the result: expired subscriptions are pruned and only failed ones are retried
+func (r deliveryResult) status() string {
+	switch {
+	case r.statusCode == 0 && errors.Is(r.err, notification.ErrForbiddenAddress):
+		// the endpoint points at an internal address, retrying won't change that
+		return "rejected"
+	case r.statusCode == 0:
+		// the request didn't reach the push service
+		return "failed"
+	case r.statusCode >= 200 && r.statusCode < 300:
+		return "delivered"
+	case r.statusCode == http.StatusNotFound || r.statusCode == http.StatusGone:
+		return "expired"
+	case r.statusCode == http.StatusTooManyRequests || r.statusCode >= 500:
+		return "failed"
+	default:
+		// other 4xx, e.g. payload too large or bad VAPID keys, won't succeed on retry
+		return "rejected"
+	}
+}
+
+func send(payload []byte, sub db.WebPushSubscription, options *webpush.Options) deliveryResult {
+	result := deliveryResult{subscription: sub}
+	subscription := &webpush.Subscription{
+		Endpoint: sub.Endpoint,
+		Keys: webpush.Keys{
+			P256dh: sub.P256dh,
+			Auth:   sub.Auth,
+		},
+	}
+	resp, err := webpush.SendNotification(payload, subscription, options)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.statusCode = resp.StatusCode
+	result.retryAfter = resp.Header.Get("Retry-After")
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		result.err = fmt.Errorf("push service returned status %d", resp.StatusCode)
+		if detail := strings.TrimSpace(string(body)); detail != "" {
+			result.err = fmt.Errorf("push service returned status %d: %s", resp.StatusCode, detail)
+		}
+	}
+	return result
+}
+
 func ProcessWebPushNotifications(notif *queue.QueuedNotification) (*notification.Notification, error) {
-	subscriptions, err := db.GetSubscriptionByUserId(notif.Recipient)
+	if pushNotifier == nil {
+		return nil, fmt.Errorf("web push notifier is not initialized")
+	}
+
+	var subscriptions []db.WebPushSubscription
+	var err error
+	if len(notif.Targets) > 0 {
+		// a retry only goes to the devices that failed before
+		subscriptions, err = db.GetSubscriptionsByIDs(notif.ApplicationID, notif.Recipient, notif.Targets)
+	} else {
+		subscriptions, err = db.GetSubscriptionByUserId(notif.ApplicationID, notif.Recipient)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
 	}
 	if len(subscriptions) == 0 {
 		return nil, fmt.Errorf("no subscriptions found for user: %s", notif.Recipient)
 	}
-	vapid := webpush.Options{
-		VAPIDPublicKey:  pushNotifier.vapidPublicKey,
-		VAPIDPrivateKey: pushNotifier.vapidPrivateKey,
-	}
-	for _, sub := range subscriptions {
-		subscription := &webpush.Subscription{
-			Endpoint: sub.Endpoint,
-			Keys: webpush.Keys{
-				P256dh: sub.P256dh,
-				Auth:   sub.Auth,
-			},
+	payload, err := buildPayload(notif)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build web push payload: %w", err)
+	}
+	options := pushNotifier.sendOptions(notif.WebPush)
+
+	results := make([]deliveryResult, len(subscriptions))
+	slots := make(chan struct{}, maxConcurrentSends)
+	var wg sync.WaitGroup
+	for i, sub := range subscriptions {
+		wg.Add(1)
+		go func(i int, sub db.WebPushSubscription) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = send(payload, sub, options)
+		}(i, sub)
+	}
+	wg.Wait()
+
+	notificationID, _ := uuid.Parse(notif.ID)
+	deliveries := make([]db.WebPushDelivery, 0, len(results))
+	var delivered int
+	var expired, failed []string
+	var lastErr error
+	var rateLimit *notification.RateLimitError
+	for _, result := range results {
+		status := result.status()
+		delivery := db.WebPushDelivery{
+			NotificationID: notificationID,
+			SubscriptionID: result.subscription.ID,
+			Attempt:        notif.Attempts + 1,
+			Status:         status,
+			StatusCode:     result.statusCode,
+		}
+		if result.err != nil {
+			delivery.Error = truncate(result.err.Error(), 500)
 		}
-		resp, err := webpush.SendNotification([]byte(notif.Message), subscription, &vapid)
-		if err != nil {
-			return nil, fmt.Errorf("failed to send web push notification: %w", err)
+		deliveries = append(deliveries, delivery)
+
+		switch status {
+		case "delivered":
+			delivered++
+		case "expired":
+			expired = append(expired, result.subscription.ID.String())
+		case "failed":
+			failed = append(failed, result.subscription.ID.String())
+			lastErr = result.err
+			if result.statusCode == http.StatusTooManyRequests {
+				retryAfter := notification.ParseRetryAfter(result.retryAfter, time.Minute)
+				if rateLimit == nil || retryAfter > rateLimit.RetryAfter {
+					rateLimit = &notification.RateLimitError{Provider: "webpush", RetryAfter: retryAfter}
+				}
+			}
+			log.Printf("Failed to send web push notification %s to subscription %s: %v", notif.ID, result.subscription.ID, result.err)
+		default:
+			log.Printf("Web push notification %s rejected for subscription %s: %v", notif.ID, result.subscription.ID, result.err)
 		}
-		resp.Body.Close()
 	}
+
+	if err := db.CreateWebPushDeliveries(deliveries); err != nil {
+		log.Printf("Failed to record web push deliveries for %s: %v", notif.ID, err)
+	}
+	if len(expired) > 0 {
+		if err := db.DeleteWebPushSubscriptions(expired); err != nil {
+			log.Printf("Failed to prune expired web push subscriptions: %v", err)
+		} else {
+			log.Printf("🧹 Pruned %d expired web push subscriptions for user %s", len(expired), notif.Recipient)
+		}
+	}
+
+	if len(failed) > 0 {
+		notif.Targets = failed
+		if rateLimit != nil {
+			return nil, fmt.Errorf("failed to send web push notification to %d of %d subscriptions: %w", len(failed), len(results), rateLimit)
+		}
+		return nil, &notification.RetryError{
+			Err: fmt.Errorf("failed to send web push notification to %d of %d subscriptions: %w", len(failed), len(results), lastErr),
+		}
+	}
+	if delivered == 0 {
+		return nil, fmt.Errorf("no active subscriptions for user: %s", notif.Recipient)
+	}
+
+	log.Printf("Web push notification %s delivered to %d/%d subscriptions", notif.ID, delivered, len(results))
 	notification := &notification.Notification{
 		ID:                 notif.ID,
 		ApplicationID:      notif.ApplicationID,
@@ -64,10 +278,18 @@ func ProcessWebPushNotifications(notif *queue.QueuedNotification) (*notification
 		Message:            notif.Message,
 		Channel:            notif.Channel,
 		Provider:           notif.Provider,
-		Status:             notif.Status,
+		Status:             "sent",
 		CreatedAt:          notif.CreatedAt,
 		MessageContentType: notif.MessageContentType,
 		TemplateID:         notif.TemplateID,
+		WebPush:            notif.WebPush,
 	}
 	return notification, nil
 }
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "")
+}
@@ -0,0 +1,256 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SherClockHolmes/webpush-go"
+	"github.com/google/uuid"
+	"github.com/r1i2t3/agni/pkg/db"
+	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/queue"
+	"gorm.io/gorm/logger"
+)
+
+// fakePushService answers each subscription endpoint /push/<name> with the status set for
+// the name, 201 when it has none, and records the requests it received
+type fakePushService struct {
+	*httptest.Server
+	delay time.Duration
+
+	mu       sync.Mutex
+	statuses map[string]int
+	requests []*http.Request
+	inFlight int
+	peak     int // the most requests handled at the same time
+}
+
+func newFakePushService(t *testing.T) *fakePushService {
+	service := &fakePushService{statuses: map[string]int{}}
+	service.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/push/")
+		service.mu.Lock()
+		service.requests = append(service.requests, r)
+		service.inFlight++
+		service.peak = max(service.peak, service.inFlight)
+		status := service.statuses[name]
+		service.mu.Unlock()
+
+		time.Sleep(service.delay)
+
+		service.mu.Lock()
+		service.inFlight--
+		service.mu.Unlock()
+		switch status {
+		case 0:
+			w.WriteHeader(http.StatusCreated)
+		case http.StatusTooManyRequests:
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(status)
+		default:
+			w.WriteHeader(status)
+			w.Write([]byte("subscription " + name))
+		}
+	}))
+	t.Cleanup(service.Close)
+	return service
+}
+
+func (s *fakePushService) received() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *fakePushService) reset(statuses map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = statuses
+	s.requests = nil
+}
+
+// setup initializes the database and a notifier sending through client
+func setup(t *testing.T, client *http.Client) uuid.UUID {
+	t.Helper()
+	config := db.MySQLConfig{DSN: filepath.Join(t.TempDir(), "agni.db"), LogLevel: logger.Silent}
+	if err := db.InitMySQL("local", config, &db.Notification{}, &db.WebPushSubscription{}, &db.WebPushDelivery{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.CloseMySQL() })
+
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPushNotifier(publicKey, privateKey, "mailto:ops@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	previous := httpClient
+	httpClient = client
+	t.Cleanup(func() { pushNotifier, httpClient = nil, previous })
+	return uuid.New()
+}
+
+// subscribe stores a browser subscription with valid keys for the endpoint
+func subscribe(t *testing.T, applicationID uuid.UUID, endpoint string) db.WebPushSubscription {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	subscription := db.WebPushSubscription{
+		ApplicationID: applicationID,
+		UserID:        "user-1",
+		Endpoint:      endpoint,
+		P256dh:        base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		Auth:          base64.RawURLEncoding.EncodeToString(auth),
+	}
+	if err := db.SaveWebPushSubscription(&subscription); err != nil {
+		t.Fatal(err)
+	}
+	return subscription
+}
+
+func queued(applicationID uuid.UUID, opts *notification.WebPushOptions) *queue.QueuedNotification {
+	return &queue.QueuedNotification{ID: uuid.NewString(), ApplicationID: applicationID.String(), Recipient: "user-1", Subject: "Grades", Message: "Your grades are out", WebPush: opts}
+}
+
+func TestSendsToAllSubscriptionsConcurrentlyWithTheDeliveryOptions(t *testing.T) {
+	service := newFakePushService(t)
+	service.delay = 20 * time.Millisecond
+	applicationID := setup(t, service.Client())
+	for i := 0; i < 3*maxConcurrentSends; i++ {
+		subscribe(t, applicationID, service.URL+"/push/"+uuid.NewString())
+	}
+
+	opts := &notification.WebPushOptions{TTL: 600, Urgency: "high", Topic: "grades"}
+	result, err := ProcessWebPushNotifications(queued(applicationID, opts))
+	if err != nil || result.Status != "sent" {
+		t.Fatalf("got %v", err)
+	}
+
+	requests := service.received()
+	if len(requests) != 3*maxConcurrentSends {
+		t.Fatalf("the push service received %d requests", len(requests))
+	}
+	if service.peak < 2 || service.peak > maxConcurrentSends {
+		t.Fatalf("%d requests were sent at the same time, want between 2 and %d", service.peak, maxConcurrentSends)
+	}
+	for _, r := range requests {
+		if r.Header.Get("TTL") != "600" || r.Header.Get("Urgency") != "high" || r.Header.Get("Topic") != "grades" {
+			t.Fatalf("unexpected delivery headers %v", r.Header)
+		}
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || !strings.HasPrefix(r.Header.Get("Authorization"), "vapid t=") {
+			t.Fatalf("the payload isn't encrypted and signed: %v", r.Header)
+		}
+	}
+
+	var deliveries int64
+	db.GetMySQLDB().Model(&db.WebPushDelivery{}).Where("status = ?", "delivered").Count(&deliveries)
+	if deliveries != 3*maxConcurrentSends {
+		t.Fatalf("recorded %d deliveries", deliveries)
+	}
+}
+
+func TestDefaultDeliveryOptions(t *testing.T) {
+	notifier := &PushNotifier{vapidPublicKey: "public", vapidPrivateKey: "private", vapidSubject: "mailto:ops@example.com"}
+	options := notifier.sendOptions(nil)
+	if options.TTL != DefaultTTL || options.Urgency != "" || options.Topic != "" {
+		t.Fatalf("unexpected defaults %+v", options)
+	}
+	if options.HTTPClient != httpClient {
+		t.Fatal("the sends don't use the public HTTP client")
+	}
+}
+
+func TestExpiredSubscriptionsArePrunedAndOnlyFailedOnesRetried(t *testing.T) {
+	service := newFakePushService(t)
+	applicationID := setup(t, service.Client())
+	subscriptions := map[string]db.WebPushSubscription{}
+	for _, name := range []string{"ok", "gone", "missing", "down", "too-large"} {
+		subscriptions[name] = subscribe(t, applicationID, service.URL+"/push/"+name)
+	}
+	service.reset(map[string]int{
+		"gone":      http.StatusGone,
+		"missing":   http.StatusNotFound,
+		"down":      http.StatusServiceUnavailable,
+		"too-large": http.StatusRequestEntityTooLarge,
+	})
+
+	notif := queued(applicationID, nil)
+	_, err := ProcessWebPushNotifications(notif)
+	var retryErr *notification.RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("expected a retry, got %v", err)
+	}
+	if len(notif.Targets) != 1 || notif.Targets[0] != subscriptions["down"].ID.String() {
+		t.Fatalf("expected the retry to target the failed subscription only, got %v", notif.Targets)
+	}
+	left, _ := db.GetSubscriptionByUserId(applicationID.String(), "user-1")
+	if len(left) != 3 {
+		t.Fatalf("expected the 404 and 410 subscriptions to be pruned, %d are left", len(left))
+	}
+
+	// the retry only goes to the failed subscription and succeeds
+	service.reset(nil)
+	notif.Attempts++
+	result, err := ProcessWebPushNotifications(notif)
+	if err != nil || result.Status != "sent" {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if requests := service.received(); len(requests) != 1 || requests[0].URL.Path != "/push/down" {
+		t.Fatalf("the retry was sent to %d subscriptions", len(requests))
+	}
+
+	var statuses []string
+	db.GetMySQLDB().Model(&db.WebPushDelivery{}).Where("subscription_id = ?", subscriptions["down"].ID).Order("attempt").Pluck("status", &statuses)
+	if strings.Join(statuses, ",") != "failed,delivered" {
+		t.Fatalf("recorded %v for the failed subscription", statuses)
+	}
+}
+
+func TestRateLimitedSubscriptionsWaitForRetryAfter(t *testing.T) {
+	service := newFakePushService(t)
+	applicationID := setup(t, service.Client())
+	subscribe(t, applicationID, service.URL+"/push/ok")
+	subscribe(t, applicationID, service.URL+"/push/busy")
+	service.reset(map[string]int{"busy": http.StatusTooManyRequests})
+
+	_, err := ProcessWebPushNotifications(queued(applicationID, nil))
+	var rateLimit *notification.RateLimitError
+	if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != 2*time.Minute {
+		t.Fatalf("expected a rate limit of 2m, got %v", err)
+	}
+}
+
+func TestEndpointsOnInternalAddressesAreRefused(t *testing.T) {
+	service := newFakePushService(t)
+	applicationID := setup(t, notification.NewPublicHTTPClient(5*time.Second, nil))
+	subscription := subscribe(t, applicationID, service.URL+"/push/internal")
+
+	_, err := ProcessWebPushNotifications(queued(applicationID, nil))
+	var retryErr *notification.RetryError
+	if err == nil || errors.As(err, &retryErr) {
+		t.Fatalf("expected a final error, got %v", err)
+	}
+	if requests := service.received(); len(requests) != 0 {
+		t.Fatalf("the internal endpoint received %d requests", len(requests))
+	}
+
+	var delivery db.WebPushDelivery
+	db.GetMySQLDB().Where("subscription_id = ?", subscription.ID).First(&delivery)
+	if delivery.Status != "rejected" {
+		t.Fatalf("recorded %q", delivery.Status)
+	}
+}
@@ -0,0 +1,61 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitError is returned by senders when a provider rejects a request
+// because of rate limiting. RetryAfter is how long the provider asked us to wait.
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %v", e.Provider, e.RetryAfter)
+}
+
+// RetryError asks the worker to retry a notification. Retries are opt-in, senders wrap
+// the failures worth another attempt, e.g. the devices that couldn't be reached.
+type RetryError struct {
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether the worker retries a notification that failed with err
+func IsRetryable(err error) bool {
+	var rateLimitErr *RateLimitError
+	var retryErr *RetryError
+	return errors.As(err, &rateLimitErr) || errors.As(err, &retryErr)
+}
+
+// ParseRetryAfter parses a Retry-After header value given either in seconds
+// or as an HTTP date. The fallback is returned when the value is missing or invalid.
+func ParseRetryAfter(value string, fallback time.Duration) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+	return fallback
+}
@@ -0,0 +1,100 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// MaxRedirects is how many redirects a PublicHTTPClient follows
+const MaxRedirects = 3
+
+// ErrForbiddenAddress is returned when a URL supplied by an API caller leads to an internal address
+var ErrForbiddenAddress = errors.New("destination is not a public address")
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), some clouds serve metadata from it
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublicIP reports whether ip may be reached through a URL supplied by an API caller:
+// loopback, private, link-local (e.g. 169.254.169.254 metadata), multicast and unspecified
+// addresses are not
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// HostAllowed reports whether host is in the list, "*.example.com" matches the subdomains
+// of example.com. An empty list allows every host.
+func HostAllowed(host string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// NewPublicHTTPClient returns a client for URLs supplied by API callers. It only connects to
+// public addresses, checked when dialing so DNS answers and redirects can't reach internal
+// hosts, follows at most MaxRedirects redirects and, with allowedHosts, only requests those hosts.
+func NewPublicHTTPClient(timeout time.Duration, allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the destination and bypass the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &allowedHostsTransport{next: transport, hosts: allowedHosts},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// allowedHostsTransport refuses requests, including redirects, to hosts outside of the list
+type allowedHostsTransport struct {
+	next  http.RoundTripper
+	hosts []string
+}
+
+func (t *allowedHostsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", req.URL.Scheme)
+	}
+	if !HostAllowed(req.URL.Hostname(), t.hosts) {
+		return nil, fmt.Errorf("host %s is not allowed", req.URL.Hostname())
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,89 @@
+// Package tracking rewrites HTML email so opens and clicks are reported back to the Agni server.
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type LinkTracker struct {
+	baseURL string
+	secret  []byte
+}
+
+var Tracker *LinkTracker
+
+// NewLinkTracker configures tracking. baseURL is the public URL of the Agni server the
+// /t/o and /t/c endpoints are reachable on, secret signs the redirect links.
+func NewLinkTracker(baseURL, secret string) error {
+	if baseURL == "" || secret == "" {
+		return fmt.Errorf("tracking base URL and secret are required")
+	}
+	Tracker = &LinkTracker{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  []byte(secret),
+	}
+	return nil
+}
+
+// hrefRe matches absolute http(s) links in href attributes
+var hrefRe = regexp.MustCompile(`(?i)(<a\s[^>]*?href\s*=\s*)(["'])(https?://[^"']+)(["'])`)
+
+var bodyCloseRe = regexp.MustCompile(`(?i)</body\s*>`)
+
+// Instrument rewrites the links of an HTML body through the click endpoint and appends the open pixel
+func (t *LinkTracker) Instrument(body, notificationID string, opens, clicks bool) string {
+	if clicks {
+		body = hrefRe.ReplaceAllStringFunc(body, func(match string) string {
+			parts := hrefRe.FindStringSubmatch(match)
+			target := html.UnescapeString(parts[3])
+			return parts[1] + parts[2] + html.EscapeString(t.ClickURL(notificationID, target)) + parts[4]
+		})
+	}
+	if opens {
+		pixel := fmt.Sprintf(`<img src="%s" width="1" height="1" alt="" style="display:none;border:0">`, html.EscapeString(t.OpenURL(notificationID)))
+		if loc := bodyCloseRe.FindStringIndex(body); loc != nil {
+			body = body[:loc[0]] + pixel + body[loc[0]:]
+		} else {
+			body += pixel
+		}
+	}
+	return body
+}
+
+// OpenURL is the pixel of the notification, signed with an empty target since click links always have one
+func (t *LinkTracker) OpenURL(notificationID string) string {
+	query := url.Values{"s": {t.sign(notificationID, "")}}
+	return t.baseURL + "/t/o/" + url.PathEscape(notificationID) + "?" + query.Encode()
+}
+
+func (t *LinkTracker) ClickURL(notificationID, target string) string {
+	query := url.Values{"u": {target}, "s": {t.sign(notificationID, target)}}
+	return t.baseURL + "/t/c/" + url.PathEscape(notificationID) + "?" + query.Encode()
+}
+
+// VerifyClick reports whether the redirect target was signed for this notification,
+// so the click endpoint can't be used as an open redirect
+func (t *LinkTracker) VerifyClick(notificationID, target, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(t.sign(notificationID, target)))
+}
+
+// VerifyOpen reports whether the pixel was signed for this notification,
+// so opens can't be recorded for notification IDs that were guessed
+func (t *LinkTracker) VerifyOpen(notificationID, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(t.sign(notificationID, "")))
+}
+
+func (t *LinkTracker) sign(notificationID, target string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(notificationID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(target))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,107 @@
+package tracking
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testTracker(t *testing.T) *LinkTracker {
+	t.Helper()
+	if err := NewLinkTracker("https://agni.example.com/", "link secret"); err != nil {
+		t.Fatal(err)
+	}
+	return Tracker
+}
+
+// trackingURL parses the first tracking URL of the kind ("o" or "c") out of an instrumented body
+func trackingURL(t *testing.T, body, kind string) *url.URL {
+	t.Helper()
+	prefix := "https://agni.example.com/t/" + kind + "/"
+	start := strings.Index(body, prefix)
+	if start < 0 {
+		t.Fatalf("no /t/%s/ URL in %s", kind, body)
+	}
+	end := strings.IndexAny(body[start:], `"'`)
+	parsed, err := url.Parse(strings.ReplaceAll(body[start:start+end], "&amp;", "&"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestInstrumentRewritesLinksAndAddsThePixel(t *testing.T) {
+	tracker := testTracker(t)
+	body := `<html><body><a href="https://example.com/a?x=1&amp;y=2">A</a>` +
+		`<A class="b" HREF='http://example.com/b'>B</A><a href="mailto:me@example.com">mail</a>` +
+		`<a href="#top">top</a></BODY></html>`
+
+	instrumented := tracker.Instrument(body, "n1", true, true)
+
+	click := trackingURL(t, instrumented, "c")
+	if click.Path != "/t/c/n1" || click.Query().Get("u") != "https://example.com/a?x=1&y=2" {
+		t.Fatalf("unexpected click URL %s", click)
+	}
+	if !tracker.VerifyClick("n1", click.Query().Get("u"), click.Query().Get("s")) {
+		t.Fatal("the rewritten link doesn't verify")
+	}
+	if strings.Count(instrumented, "/t/c/n1") != 2 {
+		t.Fatalf("expected the two http links to be rewritten: %s", instrumented)
+	}
+	if !strings.Contains(instrumented, `href="mailto:me@example.com"`) || !strings.Contains(instrumented, `href="#top"`) {
+		t.Fatalf("non http links were rewritten: %s", instrumented)
+	}
+
+	open := trackingURL(t, instrumented, "o")
+	if open.Path != "/t/o/n1" || !tracker.VerifyOpen("n1", open.Query().Get("s")) {
+		t.Fatalf("unexpected open URL %s", open)
+	}
+	if !strings.HasSuffix(instrumented, `style="display:none;border:0"></BODY></html>`) {
+		t.Fatalf("the pixel isn't placed before </body>: %s", instrumented)
+	}
+}
+
+func TestInstrumentOnlyDoesWhatIsAsked(t *testing.T) {
+	tracker := testTracker(t)
+	body := `<p><a href="https://example.com">link</a></p>`
+
+	if got := tracker.Instrument(body, "n1", false, false); got != body {
+		t.Fatalf("body changed: %s", got)
+	}
+	if got := tracker.Instrument(body, "n1", true, false); !strings.HasPrefix(got, body) || !strings.Contains(got, "/t/o/n1") {
+		t.Fatalf("expected the pixel appended to the unchanged body: %s", got)
+	}
+	if got := tracker.Instrument(body, "n1", false, true); strings.Contains(got, "/t/o/") || !strings.Contains(got, "/t/c/n1") {
+		t.Fatalf("expected the link rewritten without a pixel: %s", got)
+	}
+}
+
+func TestSignaturesAreBoundToTheNotificationAndTarget(t *testing.T) {
+	tracker := testTracker(t)
+	click, _ := url.Parse(tracker.ClickURL("n1", "https://example.com"))
+	signature := click.Query().Get("s")
+	open, _ := url.Parse(tracker.OpenURL("n1"))
+	openSignature := open.Query().Get("s")
+
+	if !tracker.VerifyClick("n1", "https://example.com", signature) || !tracker.VerifyOpen("n1", openSignature) {
+		t.Fatal("valid signatures were refused")
+	}
+	for name, ok := range map[string]bool{
+		"other target":           tracker.VerifyClick("n1", "https://evil.example", signature),
+		"other notification":     tracker.VerifyClick("n2", "https://example.com", signature),
+		"empty click signature":  tracker.VerifyClick("n1", "https://example.com", ""),
+		"open for another id":    tracker.VerifyOpen("n2", openSignature),
+		"click signature opens":  tracker.VerifyOpen("n1", signature),
+		"empty open signature":   tracker.VerifyOpen("n1", ""),
+		"open signature as link": tracker.VerifyClick("n1", "", signature),
+	} {
+		if ok {
+			t.Errorf("%s: the signature was accepted", name)
+		}
+	}
+
+	other := &LinkTracker{baseURL: tracker.baseURL, secret: []byte("other secret")}
+	if other.VerifyClick("n1", "https://example.com", signature) || other.VerifyOpen("n1", openSignature) {
+		t.Fatal("a signature made with another secret was accepted")
+	}
+}
@@ -3,6 +3,10 @@ package notification
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,15 +16,19 @@ import (
 type NotificationChannel string
 
 const (
-	ChannelEmail   NotificationChannel = "email"
-	ChannelSMS     NotificationChannel = "sms"
-	ChannelPush    NotificationChannel = "push"
-	ChannelWebhook NotificationChannel = "webhook"
+	ChannelEmail    NotificationChannel = "email"
+	ChannelSMS      NotificationChannel = "sms"
+	ChannelPush     NotificationChannel = "push"
+	ChannelWebhook  NotificationChannel = "webhook"
+	ChannelSlack    NotificationChannel = "slack"
+	ChannelDiscord  NotificationChannel = "discord"
+	ChannelTeams    NotificationChannel = "teams"
+	ChannelTelegram NotificationChannel = "telegram"
 )
 
-// IsValidChannel checks if the channel is one of the 4 allowed types
+// IsValidChannel checks if the channel is one of the allowed types
 func IsValidChannel(channel string) bool {
-	validChannels := []string{"email", "sms", "push", "webhook"}
+	validChannels := []string{"email", "sms", "push", "webhook", "slack", "discord", "teams", "telegram"}
 	for _, valid := range validChannels {
 		if channel == valid {
 			return true
@@ -32,7 +40,7 @@ func IsValidChannel(channel string) bool {
 // ValidateChannel returns an error if channel is not valid
 func ValidateChannel(channel string) error {
 	if !IsValidChannel(channel) {
-		return errors.New("channel must be one of: email, sms, push, webhook")
+		return errors.New("channel must be one of: email, sms, push, webhook, slack, discord, teams, telegram")
 	}
 	return nil
 }
@@ -41,7 +49,7 @@ type Notification struct {
 	ID                 string              `json:"id"`
 	ApplicationID      string              `json:"application_id"`
 	QueueID            string              `gorm:"type:text;uniqueIndex" json:"queue_id"`
-	Channel            NotificationChannel `json:"channel" validate:"required,oneof=email sms push webhook"`
+	Channel            NotificationChannel `json:"channel" validate:"required,oneof=email sms push webhook slack discord teams telegram"`
 	Provider           string              `json:"provider"`
 	Recipient          string              `json:"recipient" validate:"required"`
 	Subject            string              `json:"subject,omitempty"`
@@ -52,6 +60,195 @@ type Notification struct {
 	CreatedAt          time.Time           `json:"created_at"`
 	UpdatedAt          time.Time           `json:"updated_at"`
 	Attempts           int                 `json:"attempts"`
+	Email              *EmailOptions       `json:"email,omitempty"`
+	WebPush            *WebPushOptions     `json:"webpush,omitempty"`
+	InApp              *InAppOptions       `json:"in_app,omitempty"`
+}
+
+// EmailOptions holds the email specific parts of a notification
+type EmailOptions struct {
+	Cc          []string          `json:"cc,omitempty"`
+	Bcc         []string          `json:"bcc,omitempty"`
+	ReplyTo     string            `json:"reply_to,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	TextMessage string            `json:"text_message,omitempty"` // plain text alternative of an html message
+	Attachments []EmailAttachment `json:"attachments,omitempty"`
+	TrackOpens  bool              `json:"track_opens,omitempty"`  // html only, adds a tracking pixel
+	TrackClicks bool              `json:"track_clicks,omitempty"` // html only, rewrites links through the redirect endpoint
+	// Category marks bulk mail (e.g. "course-announcements"), it gets one-click unsubscribe
+	// headers and isn't sent to recipients that unsubscribed from the category
+	Category string `json:"category,omitempty"`
+	// SkipIfOnline is the in-app user id of the recipient, the email isn't sent while the
+	// user has the app open, i.e. is connected to the in-app service
+	SkipIfOnline string `json:"skip_if_online,omitempty"`
+}
+
+// WebPushOptions holds the web push specific parts of a notification.
+// Subject and Message become the title and body of the payload.
+type WebPushOptions struct {
+	Icon    string                 `json:"icon,omitempty"`
+	Badge   string                 `json:"badge,omitempty"`
+	URL     string                 `json:"url,omitempty"` // opened when the notification is clicked
+	Actions []WebPushAction        `json:"actions,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	TTL     int                    `json:"ttl,omitempty"`     // seconds the push service keeps an undelivered message
+	Urgency string                 `json:"urgency,omitempty"` // very-low, low, normal or high
+	Topic   string                 `json:"topic,omitempty"`   // collapse key, replaces a pending message with the same topic
+}
+
+type WebPushAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
+	Icon   string `json:"icon,omitempty"`
+}
+
+var webPushTopicRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Validate checks the options against what push services accept (RFC 8030)
+func (o *WebPushOptions) Validate() error {
+	switch o.Urgency {
+	case "", "very-low", "low", "normal", "high":
+	default:
+		return errors.New("webpush urgency must be one of: very-low, low, normal, high")
+	}
+	if o.TTL < 0 {
+		return errors.New("webpush ttl can't be negative")
+	}
+	if o.Topic != "" && !webPushTopicRe.MatchString(o.Topic) {
+		return errors.New("webpush topic must be at most 32 characters of the URL-safe base64 alphabet")
+	}
+	for _, action := range o.Actions {
+		if action.Action == "" || action.Title == "" {
+			return errors.New("webpush actions need an action and a title")
+		}
+	}
+	return nil
+}
+
+// InAppOptions holds the in-app specific parts of a notification.
+// Subject and Message are the title and body shown in the inbox.
+type InAppOptions struct {
+	// Broadcast sends the notification to every user of the application instead of Recipient,
+	// a Topic limits it to the users subscribed to the topic
+	Broadcast bool   `json:"broadcast,omitempty"`
+	Topic     string `json:"topic,omitempty"`
+
+	Category string                 `json:"category,omitempty"` // e.g. "assignments", the inbox can be filtered by it
+	Icon     string                 `json:"icon,omitempty"`
+	Image    string                 `json:"image,omitempty"`
+	URL      string                 `json:"url,omitempty"` // deep link opened when the notification is clicked
+	Actions  []InAppAction          `json:"actions,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Priority string                 `json:"priority,omitempty"` // low, normal or high
+	// ExpiresAt hides the notification from the inbox, and it isn't delivered anymore, once passed
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// InAppAction is a button of an in-app notification
+type InAppAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
+	URL    string `json:"url,omitempty"`
+}
+
+var inAppTopicRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,100}$`)
+
+// ValidateInAppTopic checks a topic name, e.g. "course:cs101" or "grade-10"
+func ValidateInAppTopic(topic string) error {
+	if !inAppTopicRe.MatchString(topic) {
+		return errors.New("in_app topic must be 1 to 100 letters, digits or any of _ . : -")
+	}
+	return nil
+}
+
+// IsBroadcast reports whether the notification goes to many users rather than its recipient
+func (o *InAppOptions) IsBroadcast() bool {
+	return o != nil && (o.Broadcast || o.Topic != "")
+}
+
+// Expired reports whether the notification expired and must not be shown anymore
+func (o *InAppOptions) Expired(now time.Time) bool {
+	return o != nil && o.ExpiresAt != nil && !o.ExpiresAt.After(now)
+}
+
+func (o *InAppOptions) Validate() error {
+	if o.Topic != "" {
+		if err := ValidateInAppTopic(o.Topic); err != nil {
+			return err
+		}
+	}
+	if len(o.Category) > 100 {
+		return errors.New("in_app category must be at most 100 characters")
+	}
+	switch o.Priority {
+	case "", "low", "normal", "high":
+	default:
+		return errors.New("in_app priority must be one of: low, normal, high")
+	}
+	if o.Expired(time.Now()) {
+		return errors.New("in_app expires_at must be in the future")
+	}
+	if err := validateInAppLink("url", o.URL, true); err != nil {
+		return err
+	}
+	if err := validateInAppLink("icon", o.Icon, false); err != nil {
+		return err
+	}
+	if err := validateInAppLink("image", o.Image, false); err != nil {
+		return err
+	}
+	for _, action := range o.Actions {
+		if action.Action == "" || action.Title == "" {
+			return errors.New("in_app actions need an action and a title")
+		}
+		if err := validateInAppLink("action url", action.URL, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unsafeLinkSchemes run code or content chosen by the sender when the inbox opens or renders the link
+var unsafeLinkSchemes = map[string]bool{
+	"javascript": true, "vbscript": true, "data": true, "blob": true, "file": true, "filesystem": true, "about": true,
+}
+
+// validateInAppLink accepts http(s) URLs and paths relative to the app. Links the user opens
+// may also be deep links with the app's own scheme, e.g. "myapp://courses/42".
+func validateInAppLink(field, link string, deepLink bool) error {
+	if link == "" {
+		return nil
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("in_app %s is not a valid URL", field)
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	switch {
+	case scheme == "http" || scheme == "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("in_app %s must have a host", field)
+		}
+	case scheme == "":
+		// relative, resolved against the page showing the inbox
+	case deepLink && !unsafeLinkSchemes[scheme]:
+	default:
+		if deepLink {
+			return fmt.Errorf("in_app %s must be an http(s) URL, a relative path or an app deep link", field)
+		}
+		return fmt.Errorf("in_app %s must be an http(s) URL or a relative path", field)
+	}
+	return nil
+}
+
+// EmailAttachment is either base64 Content or a URL the worker downloads.
+// Attachments with a ContentID are sent inline and can be referenced as cid:<content_id>
+type EmailAttachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     string `json:"content,omitempty"`
+	URL         string `json:"url,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
 }
 
 // SetChannel sets the channel with validation
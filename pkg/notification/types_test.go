@@ -0,0 +1,55 @@
+package notification
+
+import "testing"
+
+func TestInAppLinksRejectScriptAndDataURLs(t *testing.T) {
+	for _, link := range []string{
+		"javascript:alert(1)",
+		"JavaScript:alert(1)",
+		" javascript:alert(1)",
+		"java\tscript:alert(1)",
+		"data:text/html,<script>alert(1)</script>",
+		"vbscript:msgbox(1)",
+		"https://",
+	} {
+		if err := (&InAppOptions{URL: link}).Validate(); err == nil {
+			t.Errorf("url %q was accepted", link)
+		}
+		if err := (&InAppOptions{Actions: []InAppAction{{Action: "open", Title: "Open", URL: link}}}).Validate(); err == nil {
+			t.Errorf("action url %q was accepted", link)
+		}
+	}
+	if err := (&InAppOptions{Icon: "edtech://icons/bell.png"}).Validate(); err == nil {
+		t.Error("icons must be http(s) URLs")
+	}
+
+	for _, link := range []string{"https://example.com/courses/42", "/courses/42", "edtech://courses/42"} {
+		if err := (&InAppOptions{URL: link, Icon: "https://cdn.example.com/bell.png"}).Validate(); err != nil {
+			t.Errorf("url %q was refused: %v", link, err)
+		}
+	}
+}
+
+func TestWebPushOptionsValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		opts WebPushOptions
+		ok   bool
+	}{
+		{"defaults", WebPushOptions{}, true},
+		{"all set", WebPushOptions{TTL: 3600, Urgency: "very-low", Topic: "grade_10-math"}, true},
+		{"zero ttl uses the default", WebPushOptions{TTL: 0}, true},
+		{"negative ttl", WebPushOptions{TTL: -1}, false},
+		{"unknown urgency", WebPushOptions{Urgency: "urgent"}, false},
+		{"urgency is case sensitive", WebPushOptions{Urgency: "High"}, false},
+		{"longest topic", WebPushOptions{Topic: "abcdefghijklmnopqrstuvwxyz012345"}, true},
+		{"topic too long", WebPushOptions{Topic: "abcdefghijklmnopqrstuvwxyz0123456"}, false},
+		{"topic outside base64url", WebPushOptions{Topic: "grades:10"}, false},
+		{"action without title", WebPushOptions{Actions: []WebPushAction{{Action: "open"}}}, false},
+	}
+	for _, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
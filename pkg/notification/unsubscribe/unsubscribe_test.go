@@ -0,0 +1,57 @@
+package unsubscribe
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestTokensRoundTrip(t *testing.T) {
+	if err := NewUnsubscriber("https://agni.example.com/", "link secret"); err != nil {
+		t.Fatal(err)
+	}
+	claims := Claims{ApplicationID: "app-1", Email: "user@example.com", Category: "newsletter"}
+
+	parsed, err := Links.Parse(Links.Token(claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != claims {
+		t.Fatalf("got %+v, want %+v", parsed, claims)
+	}
+
+	headers := Links.Headers(claims)
+	if !strings.HasPrefix(headers["List-Unsubscribe"], "<https://agni.example.com/u/") || headers["List-Unsubscribe-Post"] != "List-Unsubscribe=One-Click" {
+		t.Fatalf("unexpected headers %v", headers)
+	}
+}
+
+func TestTamperedAndForeignTokensAreRefused(t *testing.T) {
+	links := &Unsubscriber{baseURL: "https://agni.example.com", secret: []byte("link secret")}
+	token := links.Token(Claims{ApplicationID: "app-1", Email: "user@example.com"})
+	_, signature, _ := strings.Cut(token, ".")
+
+	// the same signature on the claims of another application or recipient
+	resigned := func(claims string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(claims)) + "." + signature
+	}
+	foreign := &Unsubscriber{baseURL: "https://other.example.com", secret: []byte("other secret")}
+
+	for name, token := range map[string]string{
+		"other application":   resigned(`{"a":"app-2","e":"user@example.com","c":""}`),
+		"other recipient":     resigned(`{"a":"app-1","e":"victim@example.com","c":""}`),
+		"truncated signature": token[:len(token)-1],
+		"no signature":        strings.Split(token, ".")[0],
+		"foreign secret":      foreign.Token(Claims{ApplicationID: "app-1", Email: "user@example.com"}),
+		"empty":               "",
+	} {
+		if _, err := links.Parse(token); err == nil {
+			t.Errorf("%s: the token was accepted", name)
+		}
+	}
+
+	// correctly signed but without the claims an opt-out needs
+	if _, err := links.Parse(links.Token(Claims{Email: "user@example.com"})); err == nil {
+		t.Error("a token without application was accepted")
+	}
+}
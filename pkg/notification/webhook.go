@@ -0,0 +1,32 @@
+package notification
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ResolveWebhookURL turns a webhook recipient into a full URL.
+// The recipient can be a path relative to baseURL (e.g. "services/T000/B000/XXXX")
+// or a full URL, in which case it must live under baseURL. A base URL is required,
+// recipients are supplied by API callers and must not reach arbitrary hosts.
+func ResolveWebhookURL(baseURL, recipient string) (string, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return "", fmt.Errorf("webhook recipient is required")
+	}
+	if baseURL == "" {
+		return "", fmt.Errorf("webhook base URL is not configured")
+	}
+
+	if strings.HasPrefix(recipient, "http://") || strings.HasPrefix(recipient, "https://") {
+		if _, err := url.ParseRequestURI(recipient); err != nil {
+			return "", fmt.Errorf("invalid webhook URL: %w", err)
+		}
+		if !strings.HasPrefix(recipient, strings.TrimRight(baseURL, "/")+"/") {
+			return "", fmt.Errorf("webhook URL must start with %s", baseURL)
+		}
+		return recipient, nil
+	}
+	return strings.TrimRight(baseURL, "/") + "/" + strings.TrimLeft(recipient, "/"), nil
+}
@@ -2,16 +2,23 @@ package workers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/r1i2t3/agni/pkg/db"
+	inappservice "github.com/r1i2t3/agni/pkg/inapp"
 	"github.com/r1i2t3/agni/pkg/notification"
+	"github.com/r1i2t3/agni/pkg/notification/channels/discord"
 	"github.com/r1i2t3/agni/pkg/notification/channels/email"
 	inapp "github.com/r1i2t3/agni/pkg/notification/channels/in-app"
+	"github.com/r1i2t3/agni/pkg/notification/channels/push"
+	"github.com/r1i2t3/agni/pkg/notification/channels/slack"
 	"github.com/r1i2t3/agni/pkg/notification/channels/sms"
+	"github.com/r1i2t3/agni/pkg/notification/channels/teams"
+	"github.com/r1i2t3/agni/pkg/notification/channels/telegram"
 	"github.com/r1i2t3/agni/pkg/notification/channels/webpush"
 	"github.com/r1i2t3/agni/pkg/queue"
 )
@@ -102,13 +109,22 @@ func (w *NotificationWorker) processNext() error {
 	if err != nil {
 		log.Printf("❌ Worker %d error sending notification %s: %v", w.WorkerID, queuedNotif.ID, err)
 
-		// Retry Logic
-		if queuedNotif.Attempts < w.MaxRetries {
+		// Retry Logic, only for the failures the sender marked as retryable
+		if !notification.IsRetryable(err) {
+			log.Printf("💀 Notification %s failed permanently", queuedNotif.ID)
+		} else if queuedNotif.Attempts < w.MaxRetries {
 			queuedNotif.Attempts++
+			retryDelay := w.RetryDelay
+
+			// Honor the provider's Retry-After when we were rate limited
+			var rateLimitErr *notification.RateLimitError
+			if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > retryDelay {
+				retryDelay = rateLimitErr.RetryAfter
+			}
 			log.Printf("🔄 Rescheduling notification %s for retry (Attempt %d/%d) in %v",
-				queuedNotif.ID, queuedNotif.Attempts, w.MaxRetries, w.RetryDelay)
+				queuedNotif.ID, queuedNotif.Attempts, w.MaxRetries, retryDelay)
 
-			_, retryErr := queue.DelayReEnqueueNotification(queuedNotif, w.RetryDelay)
+			_, retryErr := queue.DelayReEnqueueNotification(queuedNotif, retryDelay)
 			if retryErr != nil {
 				log.Printf("❌ Failed to reschedule notification: %v", retryErr)
 			}
@@ -138,17 +154,38 @@ func (w *NotificationWorker) processNotification(notif *queue.QueuedNotification
 		// Process web push notification
 		log.Printf("📲 Worker %d sending web push notification to %s", w.WorkerID, notif.Recipient)
 		sentNotification, err = webpush.ProcessWebPushNotifications(notif)
+	case "push":
+		// Process native mobile push notification
+		log.Printf("📱 Worker %d sending mobile push notification to %s", w.WorkerID, notif.Recipient)
+		sentNotification, err = push.ProcessPushNotifications(notif)
 
 	case "InApp":
 		// Process in App notification
 		log.Printf("📲 Worker %d sending InApp notification to %s", w.WorkerID, notif.Recipient)
 		sentNotification, err = inapp.ProcessInAppNotifications(notif)
+	case "slack":
+		// Process Slack notification
+		log.Printf("💬 Worker %d sending Slack notification to %s", w.WorkerID, notif.Recipient)
+		sentNotification, err = slack.ProcessSlackNotifications(notif)
+	case "discord":
+		// Process Discord notification
+		log.Printf("💬 Worker %d sending Discord notification", w.WorkerID)
+		sentNotification, err = discord.ProcessDiscordNotifications(notif)
+	case "teams":
+		// Process Microsoft Teams notification
+		log.Printf("💬 Worker %d sending Teams notification", w.WorkerID)
+		sentNotification, err = teams.ProcessTeamsNotifications(notif)
+	case "telegram":
+		// Process Telegram notification
+		log.Printf("✈️ Worker %d sending Telegram notification to %s", w.WorkerID, notif.Recipient)
+		sentNotification, err = telegram.ProcessTelegramNotifications(notif)
 	default:
 		log.Printf("⚠️ Worker %d unknown notification channel: %s", w.WorkerID, notif.Channel)
 		return fmt.Errorf("unknown notification channel: %s", notif.Channel)
 	}
 
-	if err == nil {
+	// a broadcast is stored once, the users' inbox rows are created from it
+	if err == nil && !(notif.Channel == "InApp" && notif.InApp.IsBroadcast()) {
 		database := db.GetMySQLDB()
 		dbNotif := db.Notification{
 			ID:                 uuid.MustParse(sentNotification.ID),
@@ -163,12 +200,16 @@ func (w *NotificationWorker) processNotification(notif *queue.QueuedNotification
 			Attempts:           sentNotification.Attempts,
 			MessageContentType: sentNotification.MessageContentType,
 			TemplateID:         sentNotification.TemplateID,
+			InAppContent:       inapp.Content(sentNotification.InApp),
 		}
 		dbErr := database.Create(&dbNotif).Error
 		if dbErr != nil {
 			log.Printf("Error saving notification record to DB: %v", dbErr)
+		} else if notif.Channel == "InApp" {
+			// the new notification is counted now, update the badge on the user's devices
+			inappservice.PublishUnreadCount(w.ctx, db.GetRedisClient(), notif.ApplicationID, notif.Recipient)
 		}
 	}
 
-	return nil
+	return err
 }
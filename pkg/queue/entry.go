@@ -31,6 +31,10 @@ type QueuedNotification struct {
 	Attempts           int                              `json:"attempts"`
 	CreatedAt          time.Time                        `json:"created_at"`
 	QueuedAt           time.Time                        `json:"queued_at"`
+	Email              *notification.EmailOptions       `json:"email,omitempty"`
+	WebPush            *notification.WebPushOptions     `json:"webpush,omitempty"`
+	InApp              *notification.InAppOptions       `json:"in_app,omitempty"`
+	Targets            []string                         `json:"targets,omitempty"` // limits a retry to the devices that failed before
 }
 
 func EnqueueNotification(Notification notification.Notification) (string, error) {
@@ -51,6 +55,9 @@ func EnqueueNotification(Notification notification.Notification) (string, error)
 		Attempts:           Notification.Attempts,
 		CreatedAt:          Notification.CreatedAt,
 		QueuedAt:           time.Now(),
+		Email:              Notification.Email,
+		WebPush:            Notification.WebPush,
+		InApp:              Notification.InApp,
 	}
 	// Serialize the notification
 	data, err := json.Marshal(queuedNotification)